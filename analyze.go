@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"code.bankrs.com/bosgo"
+	"github.com/abiosoft/ishell"
+)
+
+// analysis is the result of the analyze command. PerMonth holds the
+// average of a month per currency when transactions are grouped by month.
+type analysis struct {
+	By       string          `json:"by"`
+	Groups   []analysisGroup `json:"groups"`
+	Totals   []analysisGroup `json:"totals"`
+	PerMonth []analysisGroup `json:"per_month,omitempty"`
+}
+
+// rows lists the groups followed by the totals and averages for list based
+// output formats.
+func (a *analysis) rows() interface{} {
+	return append(append(append([]analysisGroup{}, a.Groups...), a.Totals...), a.PerMonth...)
+}
+
+// analysisGroup sums the transactions of one month, counterparty, category
+// or account in one currency.
+type analysisGroup struct {
+	Key      string `json:"key"`
+	Currency string `json:"currency"`
+	Count    int    `json:"count"`
+	Inflow   string `json:"inflow"`
+	Outflow  string `json:"outflow"`
+	Net      string `json:"net"`
+	Average  string `json:"average"`
+
+	inflow, outflow int64
+}
+
+// chartWidth is the number of characters of the longest bar in a chart.
+const chartWidth = 40
+
+func analyze(c *ishell.Context) {
+	if session.userClient == nil {
+		c.Err(errNoUser)
+		return
+	}
+
+	var by string
+	var top int
+	var chart bool
+	f := transactionFilter{all: true, now: time.Now()}
+	fs := newCmdFlags("analyze")
+	fs.StringVar(&by, "by", "month", "group transactions by month, counterparty, category or account")
+	fs.IntVar(&top, "top", 0, "only show this many groups")
+	fs.BoolVar(&chart, "chart", false, "draw inflow and outflow as bars instead of a table")
+	fs.StringVar(&f.account, "account", "", "only analyze transactions of the account with this id")
+	fs.StringVar(&f.from, "from", "", "only analyze transactions entered on or after this date (yyyy-mm-dd)")
+	fs.StringVar(&f.to, "to", "", "only analyze transactions entered on or before this date (yyyy-mm-dd)")
+	if err := parseCmdFlags(c, fs); err != nil {
+		c.Err(err)
+		return
+	}
+	if len(c.Args) > 0 {
+		c.Err(syntaxError(fmt.Errorf("unexpected argument %q", c.Args[0])))
+		return
+	}
+	if err := f.check(); err != nil {
+		c.Err(err)
+		return
+	}
+	if top < 0 {
+		c.Err(validationErrorf("--top must not be negative"))
+		return
+	}
+
+	var key func(bosgo.Transaction) string
+	switch by {
+	case "month":
+		key = func(tx bosgo.Transaction) string { return tx.EntryDate.Format("2006-01") }
+	case "counterparty":
+		key = counterpartyKey
+	case "category":
+		key = categoryKey
+	case "account":
+		accounts, err := session.userClient.Accounts.List().Send()
+		if err != nil {
+			c.Err(err)
+			return
+		}
+		names := map[int64]string{}
+		for _, a := range accounts.Accounts {
+			names[a.ID] = accountName(a)
+		}
+		key = func(tx bosgo.Transaction) string {
+			if name, ok := names[tx.UserAccount.ID]; ok {
+				return name
+			}
+			return strconv.FormatInt(tx.UserAccount.ID, 10)
+		}
+	default:
+		c.Err(validationErrorf("unknown grouping %q, expected month, counterparty, category or account", by))
+		return
+	}
+
+	txs, err := listTransactions(&f)
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	var selected []bosgo.Transaction
+	for _, tx := range txs {
+		if f.match(tx) {
+			selected = append(selected, tx)
+		}
+	}
+
+	groups, err := analyzeTransactions(selected, key, by == "month")
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	totals, months := analysisTotals(groups)
+	result := &analysis{By: by, Groups: topGroups(groups, top, by == "month"), Totals: totals}
+	if by == "month" {
+		result.PerMonth = monthlyAverages(totals, months)
+	}
+	if structuredOutput(c) {
+		printResult(c, result)
+		return
+	}
+	lastResult = result
+	if len(groups) == 0 {
+		c.Println("No transactions")
+		return
+	}
+	if chart {
+		c.Print(formatAnalysisChart(result.Groups))
+		return
+	}
+	c.Print(formatAnalysis(result))
+}
+
+func counterpartyKey(tx bosgo.Transaction) string {
+	if tx.Counterparty.Name != "" {
+		return tx.Counterparty.Name
+	}
+	if tx.Counterparty.Account.IBAN != "" {
+		return tx.Counterparty.Account.IBAN
+	}
+	return "unknown"
+}
+
+func categoryKey(tx bosgo.Transaction) string {
+	if tx.CategoryID == 0 {
+		return "uncategorized"
+	}
+	return "category " + strconv.FormatInt(tx.CategoryID, 10)
+}
+
+// analyzeTransactions sums txs per key and currency. Months are listed in
+// chronological order, all other groups by their outflow, largest first, so
+// the top merchants come first.
+func analyzeTransactions(txs []bosgo.Transaction, key func(bosgo.Transaction) string, chronological bool) ([]analysisGroup, error) {
+	type groupKey struct{ key, currency string }
+	index := map[groupKey]int{}
+	groups := []analysisGroup{}
+	for _, tx := range txs {
+		amount, err := parseCents(tx.Value.Value)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: invalid amount %q", tx.ID, tx.Value.Value)
+		}
+		k := groupKey{key(tx), tx.Value.Currency}
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, analysisGroup{Key: k.key, Currency: k.currency})
+		}
+		g := &groups[i]
+		g.Count++
+		if amount < 0 {
+			g.outflow += amount
+		} else {
+			g.inflow += amount
+		}
+	}
+
+	sortGroups(groups, chronological)
+	for i := range groups {
+		groups[i].setAmounts()
+	}
+	return groups, nil
+}
+
+// sortGroups orders groups chronologically or by their outflow, largest
+// first, and then by their inflow.
+func sortGroups(groups []analysisGroup, chronological bool) {
+	sort.SliceStable(groups, func(i, j int) bool {
+		a, b := groups[i], groups[j]
+		if !chronological && a.outflow != b.outflow {
+			return a.outflow < b.outflow
+		}
+		if !chronological && a.inflow != b.inflow {
+			return a.inflow > b.inflow
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.Currency < b.Currency
+	})
+}
+
+// topGroups returns the top groups with the largest outflow. Months are
+// chosen the same way and then listed in chronological order again.
+func topGroups(groups []analysisGroup, top int, chronological bool) []analysisGroup {
+	if top == 0 || len(groups) <= top {
+		return groups
+	}
+	groups = append([]analysisGroup(nil), groups...)
+	sortGroups(groups, false)
+	groups = groups[:top]
+	sortGroups(groups, chronological)
+	return groups
+}
+
+// setAmounts formats the sums of g, the average is that of a transaction.
+func (g *analysisGroup) setAmounts() {
+	net := g.inflow + g.outflow
+	g.Inflow = signedCents(g.inflow)
+	g.Outflow = signedCents(g.outflow)
+	g.Net = signedCents(net)
+	if g.Count > 0 {
+		g.Average = signedCents(int64(math.Round(float64(net) / float64(g.Count))))
+	}
+}
+
+// analysisTotals sums the groups per currency and counts the groups of each
+// currency.
+func analysisTotals(groups []analysisGroup) ([]analysisGroup, map[string]int) {
+	totals := []analysisGroup{}
+	index := map[string]int{}
+	n := map[string]int{}
+	for _, g := range groups {
+		i, ok := index[g.Currency]
+		if !ok {
+			i = len(totals)
+			index[g.Currency] = i
+			totals = append(totals, analysisGroup{Key: "TOTAL", Currency: g.Currency})
+		}
+		totals[i].Count += g.Count
+		totals[i].inflow += g.inflow
+		totals[i].outflow += g.outflow
+		n[g.Currency]++
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Currency < totals[j].Currency })
+	for i := range totals {
+		totals[i].setAmounts()
+	}
+	return totals, n
+}
+
+// monthlyAverages returns the average of a month of each of totals, n
+// holds the number of months of each currency.
+func monthlyAverages(totals []analysisGroup, n map[string]int) []analysisGroup {
+	avgs := make([]analysisGroup, 0, len(totals))
+	for _, t := range totals {
+		months := float64(n[t.Currency])
+		avg := analysisGroup{
+			Key:      "PER MONTH",
+			Currency: t.Currency,
+			Count:    int(math.Round(float64(t.Count) / months)),
+			inflow:   int64(math.Round(float64(t.inflow) / months)),
+			outflow:  int64(math.Round(float64(t.outflow) / months)),
+		}
+		avg.setAmounts()
+		avg.Average = ""
+		avgs = append(avgs, avg)
+	}
+	return avgs
+}
+
+// formatAnalysis prints the groups of a as a table followed by the totals
+// of each currency and, by month, the average of a month.
+func formatAnalysis(a *analysis) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tCURRENCY\tCOUNT\tINFLOW\tOUTFLOW\tNET\tAVERAGE\n", strings.ToUpper(a.By))
+	for _, rows := range [][]analysisGroup{a.Groups, a.Totals, a.PerMonth} {
+		for _, g := range rows {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", g.Key, g.Currency, g.Count, g.Inflow, g.Outflow, g.Net, g.Average)
+		}
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// formatAnalysisChart draws the inflow of every group as a bar of + and its
+// outflow as a bar of -, scaled to the largest amount.
+func formatAnalysisChart(groups []analysisGroup) string {
+	var max int64
+	for _, g := range groups {
+		if g.inflow > max {
+			max = g.inflow
+		}
+		if -g.outflow > max {
+			max = -g.outflow
+		}
+	}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	for _, g := range groups {
+		fmt.Fprintf(w, "%s\t%s\t%s %s\n", g.Key, bar("+", g.inflow, max), g.Inflow, g.Currency)
+		fmt.Fprintf(w, "\t%s\t%s %s\n", bar("-", -g.outflow, max), g.Outflow, g.Currency)
+	}
+	w.Flush()
+	return buf.String()
+}
+
+// bar returns a bar of chartWidth characters, the first of them drawn with
+// mark in proportion to v/max. Amounts above zero get at least one mark.
+func bar(mark string, v, max int64) string {
+	n := 0
+	if max > 0 {
+		n = int(math.Round(float64(v) * chartWidth / float64(max)))
+	}
+	if n == 0 && v > 0 {
+		n = 1
+	}
+	return strings.Repeat(mark, n) + strings.Repeat(" ", chartWidth-n)
+}
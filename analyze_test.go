@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTopGroups(t *testing.T) {
+	groups := []analysisGroup{
+		{Key: "2018-10", outflow: -100},
+		{Key: "2018-11", outflow: -900},
+		{Key: "2018-12", outflow: -50, inflow: 10},
+		{Key: "2019-01", outflow: -500},
+	}
+	keys := func(groups []analysisGroup) string {
+		var ks []string
+		for _, g := range groups {
+			ks = append(ks, g.Key)
+		}
+		return strings.Join(ks, ",")
+	}
+
+	tests := []struct {
+		top           int
+		chronological bool
+		want          string
+	}{
+		{0, true, "2018-10,2018-11,2018-12,2019-01"},
+		{4, true, "2018-10,2018-11,2018-12,2019-01"},
+		{2, true, "2018-11,2019-01"},
+		{3, true, "2018-10,2018-11,2019-01"},
+		{2, false, "2018-11,2019-01"},
+	}
+	for _, tt := range tests {
+		if got := keys(topGroups(groups, tt.top, tt.chronological)); got != tt.want {
+			t.Errorf("topGroups(%d, %v) = %s, want %s", tt.top, tt.chronological, got, tt.want)
+		}
+	}
+	if got := keys(groups); got != "2018-10,2018-11,2018-12,2019-01" {
+		t.Errorf("topGroups reordered the groups to %s", got)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	h := newHarness(t)
+	h.loginUser()
+
+	out := h.mustRun("", "analyze")
+	h.contains(out, "MONTH", "2018-11    EUR       6      3053.16  -1475.50  1577.66  262.94", "TOTAL", "PER MONTH")
+
+	h.mustRun("", "analyze", "--by", "counterparty", "--top", "2")
+	groups := lastResult.(*analysis).Groups
+	if len(groups) != 2 || groups[0].Key != "Hausverwaltung Berlin" || groups[1].Key != "Savings" {
+		t.Errorf("got %+v, want the 2 counterparties with the largest outflow", groups)
+	}
+
+	// Structured output holds the totals and averages of the table.
+	h.contains(h.mustRun("", "analyze", "|", "query", "{total: .totals[0].net, month: .per_month[0].net}"),
+		`"month": "1577.66"`, `"total": "1577.66"`)
+	h.contains(h.mustRun("", "analyze", "--by", "counterparty", "--top", "1", "|", "query", ".totals[0].count"), "6")
+
+	out = h.mustRun("", "analyze", "--by", "account", "--chart")
+	h.contains(out, "Girokonto  "+strings.Repeat("+", chartWidth)+"  2500.00 EUR", "-1475.50 EUR", "Tagesgeld")
+	h.contains(h.mustRun("", "analyze", "--by", "category"), "uncategorized")
+	h.contains(h.mustRun("", "analyze", "--from", "2019-01-01"), "No transactions")
+
+	h.fails(exitValidation, "unknown grouping", "", "analyze", "--by", "weekday")
+	h.fails(exitValidation, "must not be negative", "", "analyze", "--top", "-1")
+}
+
+func TestAnalyzeRows(t *testing.T) {
+	h := newHarness(t)
+	defer setOutputFormat("")
+	h.loginUser()
+
+	h.mustRun("", "set", "output", "table")
+	out := h.mustRun("", "analyze")
+	h.contains(out, "KEY        CURRENCY  COUNT", "2018-11    EUR       6      3053.16  -1475.50  1577.66  262.94",
+		"TOTAL      EUR       6", "PER MONTH  EUR       6")
+	if _, ok := lastResult.(*analysis); !ok {
+		t.Errorf("got result %T, want the analysis", lastResult)
+	}
+	h.mustRun("", "set", "output", "csv")
+	h.contains(h.mustRun("", "analyze", "--by", "account"), "Key,Currency,Count,Inflow,Outflow,Net,Average\n",
+		"Girokonto,EUR,5,2500.00,-1475.50,1024.50,204.90\n", "TOTAL,EUR,6,3053.16,-1475.50,1577.66,262.94\n")
+	h.mustRun("", "set", "output", "yaml")
+	h.contains(h.mustRun("", "analyze"), "by: month\n", "totals:\n", "per_month:\n")
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+var (
+	recordFile   = flag.String("record", "", "record all http traffic with the api into a cassette file")
+	replayFile   = flag.String("replay", "", "replay the http traffic recorded in a cassette file instead of using the network")
+	replayStrict = flag.Bool("replay-strict", false, "with -replay, fail requests whose recorded responses have all been used instead of repeating the last one")
+)
+
+// cassette holds recorded http traffic. Secrets are masked before they are
+// written, as in trace output.
+type cassette struct {
+	Interactions []*interaction `json:"interactions"`
+}
+
+type interaction struct {
+	Request  recordedRequest  `json:"request"`
+	Response recordedResponse `json:"response"`
+
+	used bool
+}
+
+type recordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type recordedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// cassetteTransport records traffic into a cassette file or replays the
+// traffic recorded in one.
+type cassetteTransport struct {
+	next   http.RoundTripper
+	file   string
+	replay bool
+	strict bool
+
+	mu       sync.Mutex
+	cassette cassette
+}
+
+// recorder is the cassette transport in use, if any.
+var recorder *cassetteTransport
+
+// startCassette sets up recording or replaying as requested by flags.
+func startCassette() error {
+	switch {
+	case *recordFile != "" && *replayFile != "":
+		return validationErrorf("-record and -replay cannot be used together")
+	case *replayStrict && *replayFile == "":
+		return validationErrorf("-replay-strict requires -replay")
+	case *recordFile != "":
+		recorder = &cassetteTransport{file: *recordFile}
+		return recorder.save()
+	case *replayFile != "":
+		data, err := ioutil.ReadFile(*replayFile)
+		if err != nil {
+			return err
+		}
+		recorder = &cassetteTransport{file: *replayFile, replay: true, strict: *replayStrict}
+		if err := json.Unmarshal(data, &recorder.cassette); err != nil {
+			return validationErrorf("reading cassette %s: %v", *replayFile, err)
+		}
+	}
+	return nil
+}
+
+func (t *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	rec := recordedRequest{
+		Method: req.Method,
+		URL:    redactURL(req.URL),
+		Header: redactHeader(req.Header),
+		Body:   redactBody(req.Header.Get("Content-Type"), body),
+	}
+
+	if t.replay {
+		return t.find(req, rec)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	header := redactHeader(resp.Header)
+	// The body is reformatted when secrets are masked.
+	header.Del("Content-Length")
+
+	t.mu.Lock()
+	t.cassette.Interactions = append(t.cassette.Interactions, &interaction{
+		Request: rec,
+		Response: recordedResponse{
+			Status: resp.StatusCode,
+			Header: header,
+			Body:   redactBody(resp.Header.Get("Content-Type"), respBody),
+		},
+	})
+	t.mu.Unlock()
+
+	if err := t.save(); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// find returns the response recorded for a request. Requests match when
+// their method, path, query and body are the same. Recorded interactions
+// are replayed in order; once all matching interactions have been used the
+// last one is repeated, e.g. when polling a job, unless replay is strict.
+func (t *cassetteTransport) find(req *http.Request, rec recordedRequest) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var last *interaction
+	for _, in := range t.cassette.Interactions {
+		if !in.matches(rec) {
+			continue
+		}
+		if !in.used {
+			in.used = true
+			return in.response(req), nil
+		}
+		last = in
+	}
+	if last != nil {
+		if t.strict {
+			return nil, fmt.Errorf("all responses recorded in %s for %s %s have been used", t.file, rec.Method, rec.URL)
+		}
+		return last.response(req), nil
+	}
+	return nil, fmt.Errorf("no response recorded in %s for %s %s", t.file, rec.Method, rec.URL)
+}
+
+func (in *interaction) matches(rec recordedRequest) bool {
+	if in.Request.Method != rec.Method || in.Request.Body != rec.Body {
+		return false
+	}
+	u1, err1 := parseRecordedURL(in.Request.URL)
+	u2, err2 := parseRecordedURL(rec.URL)
+	return err1 == nil && err2 == nil && u1 == u2
+}
+
+func (in *interaction) response(req *http.Request) *http.Response {
+	header := http.Header{}
+	for k, v := range in.Response.Header {
+		header[k] = v
+	}
+	header.Set("Content-Length", strconv.Itoa(len(in.Response.Body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", in.Response.Status, http.StatusText(in.Response.Status)),
+		StatusCode:    in.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(in.Response.Body))),
+		ContentLength: int64(len(in.Response.Body)),
+		Request:       req,
+	}
+}
+
+// parseRecordedURL returns the path and query of a recorded URL so that a
+// cassette can be replayed against another address.
+func parseRecordedURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return u.RequestURI(), nil
+}
+
+// save writes the cassette file.
+func (t *cassetteTransport) save() error {
+	t.mu.Lock()
+	data, err := json.MarshalIndent(&t.cassette, "", "  ")
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(t.file, data, 0600)
+}
+
+// redactHeader returns a copy of h with the values of secret headers masked.
+func redactHeader(h http.Header) http.Header {
+	r := http.Header{}
+	for k, v := range h {
+		if secretHeader(k) {
+			v = []string{redacted}
+		}
+		r[k] = v
+	}
+	return r
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useCassette sets the cassette flags, starts the cassette and connects
+// through it.
+func useCassette(t *testing.T, record, replay string, strict bool) error {
+	t.Helper()
+	flag.Set("record", record)
+	flag.Set("replay", replay)
+	if strict {
+		flag.Set("replay-strict", "true")
+	} else {
+		flag.Set("replay-strict", "false")
+	}
+	recorder = nil
+	if err := startCassette(); err != nil {
+		return err
+	}
+	return connect()
+}
+
+func TestCassette(t *testing.T) {
+	h := newHarness(t)
+	defer func() {
+		useCassette(t, "", "", false)
+	}()
+	file := filepath.Join(tempDir(t), "cassette.json")
+
+	if err := useCassette(t, file, "", false); err != nil {
+		t.Fatal(err)
+	}
+	h.loginUser()
+	h.mustRun("", "accounts")
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Interactions) != 2 {
+		t.Fatalf("got %d interactions, want 2:\n%s", len(c.Interactions), data)
+	}
+	if in := c.Interactions[1]; in.Request.Method != "GET" || !strings.HasSuffix(in.Request.URL, "/accounts") || in.Response.Status != 200 {
+		t.Errorf("got %+v, want the accounts request", in)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("cassette contains the password:\n%s", data)
+	}
+
+	// Replay works without the server since requests match by path.
+	flag.Set("a", "127.0.0.1:1")
+	if err := useCassette(t, "", file, false); err != nil {
+		t.Fatal(err)
+	}
+	h.loginUser()
+	h.contains(h.mustRun("", "accounts"), "Girokonto")
+	h.contains(h.mustRun("", "accounts"), "Girokonto")
+	h.fails(exitNetwork, "no response recorded in "+file+" for GET", "", "transactions")
+	// Passwords are masked in the cassette, so any password matches.
+	h.mustRun("", "loginuser", "alice", "other")
+
+	// In strict mode each recorded response is used once.
+	if err := useCassette(t, "", file, true); err != nil {
+		t.Fatal(err)
+	}
+	h.loginUser()
+	h.contains(h.mustRun("", "accounts"), "Girokonto")
+	h.fails(exitNetwork, "all responses recorded in "+file+" for GET", "", "accounts")
+}
+
+func TestCassetteFlags(t *testing.T) {
+	newHarness(t)
+	defer useCassette(t, "", "", false)
+	dir := tempDir(t)
+	bad := filepath.Join(dir, "bad.json")
+	if err := ioutil.WriteFile(bad, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		record, replay string
+		strict         bool
+		err            string
+	}{
+		{filepath.Join(dir, "a.json"), bad, false, "-record and -replay cannot be used together"},
+		{"", "", true, "-replay-strict requires -replay"},
+		{"", bad, false, "reading cassette"},
+	}
+	for _, tt := range tests {
+		if err := useCassette(t, tt.record, tt.replay, tt.strict); exitCode(err) != exitValidation || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("got %v, want %q", err, tt.err)
+		}
+	}
+}
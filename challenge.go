@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"code.bankrs.com/bosgo"
+	"github.com/abiosoft/ishell"
+)
+
+func answer(c *ishell.Context) {
+	if session.userClient == nil {
+		c.Err(errNoUser)
+		return
+	}
+	uri := readArg(0, "Job URI", c)
+	answers := promptChallengeAnswers(c)
+
+	req := session.userClient.Jobs.Answer(uri)
+	for _, answer := range answers {
+		req.ChallengeAnswer(answer)
+	}
+
+	err := req.Send()
+	if err != nil {
+		c.Err(err)
+		return
+	}
+}
+
+func promptChallengeAnswers(c *ishell.Context) bosgo.ChallengeAnswerList {
+	c.ShowPrompt(false)
+	defer c.ShowPrompt(true)
+
+	var answers bosgo.ChallengeAnswerList
+	for {
+		var answer bosgo.ChallengeAnswer
+
+		c.Print("Challenge ID (q to quit): ")
+		answer.ID = c.ReadLine()
+		if strings.ToLower(answer.ID) == "q" {
+			return answers
+		}
+
+		c.Print("Value: ")
+		answer.Value = c.ReadLine()
+		answer.Store = promptBool(c, "Store (y/n)")
+
+		answers = append(answers, answer)
+	}
+}
+
+// promptProviderChallenges asks for the answers to the challenges of a
+// provider. Secure challenges are read without echo, answers to unstoreable
+// challenges are never stored and numeric and alphanumeric challenges only
+// accept digits or letters and digits. An empty answer skips a challenge.
+func promptProviderChallenges(c *ishell.Context, provider *bosgo.Provider) bosgo.ChallengeAnswerList {
+	c.ShowPrompt(false)
+	defer c.ShowPrompt(true)
+
+	var answers bosgo.ChallengeAnswerList
+	for _, spec := range provider.Challenges {
+		var value string
+		for {
+			c.Printf("%s (%s): ", challengeLabel(spec), spec.Type)
+			if spec.Secure {
+				value = c.ReadPassword()
+			} else {
+				value = c.ReadLine()
+			}
+			if value == "" || validChallengeValue(spec.Type, value) {
+				break
+			}
+			c.Printf("%s must be %s\n", challengeLabel(spec), spec.Type)
+		}
+		if value == "" {
+			continue
+		}
+
+		answer := bosgo.ChallengeAnswer{ID: spec.ID, Value: value}
+		if !spec.UnStoreable {
+			answer.Store = promptBool(c, "Store (y/n)")
+		}
+		answers = append(answers, answer)
+	}
+	return answers
+}
+
+func challengeLabel(spec bosgo.ChallengeSpec) string {
+	if spec.Description != "" {
+		return spec.Description
+	}
+	return spec.ID
+}
+
+// validChallengeValue reports whether value is valid for a challenge of
+// type typ. Numeric values hold digits only and alphanumeric values letters
+// and digits only. Types other than numeric and alphanumeric are not checked.
+func validChallengeValue(typ, value string) bool {
+	for _, r := range value {
+		switch typ {
+		case "numeric":
+			if r < '0' || r > '9' {
+				return false
+			}
+		case "alphanumeric":
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				return false
+			}
+		}
+	}
+	return true
+}
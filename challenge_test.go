@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"code.bankrs.com/bosgo"
+)
+
+func TestChallenge(t *testing.T) {
+	h := newHarness(t)
+	h.loginUser()
+
+	h.mustRun("alice\ny\n1234\ny\n1\n", "addaccess", "DE-TAN")
+	uri := lastResult.(string)
+	for i := 0; i < 3; i++ {
+		h.mustRun("", "job", uri)
+	}
+	h.mustRun("", "accesses")
+	accesses := lastResult.(*bosgo.AccessPage).Accesses
+	id := strconv.FormatInt(accesses[len(accesses)-1].ID, 10)
+
+	// Refreshing asks for a new TAN.
+	h.mustRun("", "refreshaccess", id)
+	uri = lastResult.(string)
+	h.contains(h.mustRun("", "job", uri), `"stage": "challenge"`, `"id": "tan"`)
+	out := h.mustRun(uri+"\ntan\n123456\nno\nq\n", "answer")
+	h.contains(out, "Job URI: ", "Challenge ID (q to quit): ")
+	for i := 0; i < 3; i++ {
+		h.mustRun("", "job", uri)
+	}
+	if status := lastResult.(*bosgo.JobStatus); !status.Finished || len(status.Errors) != 0 {
+		t.Errorf("got job status %+v after answering, want finished", status)
+	}
+
+	// A wrong login name makes the login fail.
+	h.mustRun("wrong\ny\n1234\ny\n", "addaccess", "DE-TEST")
+	h.mustRun("", "job", lastResult.(string))
+	if status := lastResult.(*bosgo.JobStatus); !status.Finished || len(status.Errors) == 0 || status.Errors[0].Code != "login_failed" {
+		t.Errorf("got job status %+v for wrong login name, want login_failed", status)
+	}
+}
+
+func TestValidChallengeValue(t *testing.T) {
+	tests := []struct {
+		typ, value string
+		want       bool
+	}{
+		{"numeric", "1234", true},
+		{"numeric", "12a4", false},
+		{"numeric", "١٢", false},
+		{"alphanumeric", "alice42", true},
+		{"alphanumeric", "Jörg", true},
+		{"alphanumeric", "alice smith", false},
+		{"alphanumeric", "alice-1", false},
+		{"alphanumeric", "a\tb", false},
+		{"text", "any thing!", true},
+	}
+	for _, tt := range tests {
+		if got := validChallengeValue(tt.typ, tt.value); got != tt.want {
+			t.Errorf("validChallengeValue(%s, %q) = %v, want %v", tt.typ, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestAccessNeedsApplication(t *testing.T) {
+	h := newHarness(t)
+	h.loginUser()
+	session.appClient = nil
+
+	h.fails(exitAuth, errNoApplication.Error(), "", "addaccess", "DE-TEST")
+	h.fails(exitAuth, errNoApplication.Error(), "", "updateaccess", "1")
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"strings"
+
+	"github.com/abiosoft/ishell"
+)
+
+// newCmdFlags returns a flag set for the flags of a shell command.
+func newCmdFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	return fs
+}
+
+// parseCmdFlags parses the flags of a command and leaves the remaining
+// arguments in c.Args. Unlike the flag package it accepts flags anywhere
+// among the arguments, e.g. addaccess DE-TEST --wait. Arguments after --
+// are never taken as flags.
+func parseCmdFlags(c *ishell.Context, fs *flag.FlagSet) error {
+	var flags, args []string
+	for i := 0; i < len(c.Args); i++ {
+		arg := c.Args[i]
+		if arg == "--" {
+			args = append(args, c.Args[i+1:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			args = append(args, arg)
+			continue
+		}
+		flags = append(flags, arg)
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		// Flags other than booleans take the next argument as value.
+		if f := fs.Lookup(name); f != nil && !isBoolFlag(f) && i+1 < len(c.Args) {
+			i++
+			flags = append(flags, c.Args[i])
+		}
+	}
+	if err := fs.Parse(flags); err != nil {
+		return syntaxError(err)
+	}
+	c.Args = args
+	return nil
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
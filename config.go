@@ -0,0 +1,277 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/abiosoft/ishell"
+	"gopkg.in/yaml.v2"
+)
+
+var profileName = flag.String("profile", "", "name of the profile in the config file to use, defaults to the default profile of the config file")
+
+// configDir returns the directory holding the configuration of bosh. It is
+// $XDG_CONFIG_HOME/bosh, falling back to ~/.config/bosh.
+func configDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "bosh")
+	}
+	return filepath.Join(os.Getenv("HOME"), ".config", "bosh")
+}
+
+func configPath() string {
+	return filepath.Join(configDir(), "config")
+}
+
+// config is the content of the config file, a set of named profiles:
+//
+//	default: staging
+//	profiles:
+//	  staging:
+//	    address: api.staging.example.com
+//	    environment: sandbox
+//	    tls:
+//	      ca: staging-ca.pem
+//	      cert: client.pem
+//	      key: client-key.pem
+//	      min_version: "1.2"
+//	      server_name: gateway.internal
+//	      pins: [sha256/...]
+//	    email: dev@example.com
+//	    app: 3f4a...
+//	    output: table
+type config struct {
+	Default  string              `yaml:"default"`
+	Profiles map[string]*profile `yaml:"profiles"`
+}
+
+// profile holds the settings for working with one api.
+type profile struct {
+	Address     string     `yaml:"address"`
+	Environment string     `yaml:"environment"`
+	TLS         tlsProfile `yaml:"tls"`
+	Email       string     `yaml:"email"`
+	AppKey      string     `yaml:"app"`
+	Output      string     `yaml:"output"`
+}
+
+// tlsProfile holds the TLS settings of a profile. Relative file names are
+// relative to the config dir.
+type tlsProfile struct {
+	Insecure   bool     `yaml:"insecure"`
+	CA         string   `yaml:"ca"`
+	Cert       string   `yaml:"cert"`
+	Key        string   `yaml:"key"`
+	MinVersion string   `yaml:"min_version"`
+	ServerName string   `yaml:"server_name"`
+	Pins       []string `yaml:"pins"`
+}
+
+// activeProfile is the name of the profile in use, if any.
+var activeProfile string
+
+// profileEmail is the developer email of the active profile. It is used by
+// login when no email is given.
+var profileEmail string
+
+// profileAppKey is the application key of the active profile. It is used
+// when the session is not restored or given by flags.
+var profileAppKey string
+
+// loadConfig reads the config file. A missing file is an empty config.
+func loadConfig() (*config, error) {
+	cfg := &config{}
+	data, err := ioutil.ReadFile(configPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, validationErrorf("reading %s: %v", configPath(), err)
+	}
+	for name, p := range cfg.Profiles {
+		if p == nil || p.Address == "" {
+			return nil, validationErrorf("reading %s: profile %s has no address", configPath(), name)
+		}
+	}
+	return cfg, nil
+}
+
+// lookupProfile returns the profile name from the config file. An empty
+// name selects the default profile, which may not exist.
+func lookupProfile(cfg *config, name string) (string, *profile, error) {
+	if name == "" {
+		name = cfg.Default
+		if name == "" {
+			return "", nil, nil
+		}
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return "", nil, validationErrorf("unknown profile %q in %s", name, configPath())
+	}
+	return name, p, nil
+}
+
+// applyProfile sets the flags for the settings of a profile, except for the
+// flags in keep which were given on the command line.
+func applyProfile(name string, p *profile, keep map[string]bool) error {
+	values := [][2]string{
+		{"a", p.Address},
+		{"environment", p.Environment},
+		{"insecure", strconv.FormatBool(p.TLS.Insecure)},
+		{"ca", configFile(p.TLS.CA)},
+		{"cert", configFile(p.TLS.Cert)},
+		{"key", configFile(p.TLS.Key)},
+		{"tls-min", p.TLS.MinVersion},
+		{"server-name", p.TLS.ServerName},
+		{"pin", strings.Join(p.TLS.Pins, ",")},
+	}
+	if p.Output != "" {
+		values = append(values, [2]string{"output", p.Output})
+	}
+	for _, v := range values {
+		if keep[v[0]] {
+			continue
+		}
+		if err := flag.Set(v[0], v[1]); err != nil {
+			return validationErrorf("profile %s: %v", name, err)
+		}
+	}
+
+	activeProfile = name
+	profileEmail = p.Email
+	profileAppKey = p.AppKey
+	return nil
+}
+
+// configFile resolves a file name given in the config file.
+func configFile(name string) string {
+	if name == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(configDir(), name)
+}
+
+// explicitFlags returns the names of the flags given on the command line.
+func explicitFlags() map[string]bool {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// startProfile applies the profile selected by the -profile flag, or the
+// default profile of the config file.
+func startProfile() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	name, p, err := lookupProfile(cfg, *profileName)
+	if err != nil || p == nil {
+		return err
+	}
+	return applyProfile(name, p, explicitFlags())
+}
+
+func useProfile(c *ishell.Context) {
+	name, err := readOneArg("Profile", c)
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	if name == "" {
+		c.Err(validationErrorf("usage: profile use NAME"))
+		return
+	}
+	name, p, err := lookupProfile(cfg, name)
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	if err := applyProfile(name, p, nil); err != nil {
+		c.Err(err)
+		return
+	}
+	if err := setOutputFormat(*outputFormat); err != nil {
+		c.Err(err)
+		return
+	}
+
+	// Sessions belong to the api they were created for.
+	if err := connect(); err != nil {
+		c.Err(err)
+		return
+	}
+	if dropped := resetContexts(); len(dropped) > 0 {
+		c.Printf("Dropped contexts of the previous api: %s\n", strings.Join(dropped, ", "))
+	}
+	if profileAppKey != "" {
+		session.appClient = session.client.WithApplicationKey(profileAppKey)
+		session.applicationKey = profileAppKey
+	}
+	c.SetPrompt(prompt())
+}
+
+// profileInfo describes a profile in the output of profile list.
+type profileInfo struct {
+	Name        string `json:"name"`
+	Active      bool   `json:"active"`
+	Address     string `json:"address"`
+	Environment string `json:"environment,omitempty"`
+	Email       string `json:"email,omitempty"`
+	AppKey      string `json:"app,omitempty"`
+}
+
+func listProfiles(c *ishell.Context) {
+	cfg, err := loadConfig()
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	names := make([]string, 0, len(cfg.Profiles))
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	infos := make([]profileInfo, 0, len(names))
+	for _, name := range names {
+		p := cfg.Profiles[name]
+		infos = append(infos, profileInfo{
+			Name:        name,
+			Active:      name == activeProfile,
+			Address:     p.Address,
+			Environment: p.Environment,
+			Email:       p.Email,
+			AppKey:      p.AppKey,
+		})
+	}
+
+	if structuredOutput(c) {
+		printResult(c, infos)
+		return
+	}
+	lastResult = names
+	for _, info := range infos {
+		marker := " "
+		if info.Active {
+			marker = "*"
+		}
+		c.Printf("%s %s\t%s\n", marker, info.Name, info.Address)
+	}
+}
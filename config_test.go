@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes the config file of bosh in dir.
+func writeConfig(t *testing.T, dir, config string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, "bosh"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "bosh", "config"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProfilePrecedence(t *testing.T) {
+	api := startServer(t)
+	dir := tempDir(t)
+	writeConfig(t, dir, `default: local
+profiles:
+  local:
+    address: `+api+`
+    app: demo-key
+    output: table
+    tls:
+      insecure: true
+  json:
+    address: `+api+`
+    app: demo-key
+    output: json
+    tls:
+      insecure: true
+  down:
+    address: 127.0.0.1:1
+    app: demo-key
+`)
+
+	user := []string{"-user", "alice", "-user-password", "secret"}
+	tests := []struct {
+		name string
+		args []string
+		env  []string
+		code int
+		msg  string
+	}{
+		{name: "default profile", args: with(user, "accounts"), msg: "ID  NAME"},
+		{name: "profile flag", args: with(user, "-profile", "json", "accounts"), msg: `"provider_id"`},
+		{name: "output flag over profile", args: with(user, "-output", "json", "accounts"), msg: `"provider_id"`},
+		{name: "profile address", args: with(user, "-profile", "down", "accounts"), code: exitNetwork, msg: "connection refused"},
+		{name: "address flag over profile", args: with(user, "-profile", "down", "-a", api, "-insecure", "accounts"), msg: "Girokonto"},
+		{name: "app flag over profile", args: with(user, "-app", "other-key", "accounts"), code: exitAuth, msg: "application key is missing or invalid"},
+		{name: "environment over profile", args: with(user, "accounts"), env: []string{"BOSH_APP_KEY=other-key"}, code: exitAuth, msg: "application key is missing or invalid"},
+		{name: "profile app without user", args: []string{"-no-session", "searchproviders", "bank"}, msg: "DE-TEST"},
+		{name: "unknown profile", args: with(user, "-profile", "nope", "accounts"), code: exitValidation, msg: `unknown profile "nope"`},
+	}
+	for _, tt := range tests {
+		out, code := runBosh(t, dir, "", tt.env, tt.args...)
+		if code != tt.code {
+			t.Errorf("%s: got exit code %d, want %d:\n%s", tt.name, code, tt.code, out)
+		}
+		if !strings.Contains(out, tt.msg) {
+			t.Errorf("%s: output does not contain %q:\n%s", tt.name, tt.msg, out)
+		}
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	tests := []struct {
+		config string
+		msg    string
+	}{
+		{"profiles:\n  local:\n    environment: sandbox\n", "profile local has no address"},
+		{"profiles:\n  local:\n    address: localhost\n    colour: red\n", "field colour not found"},
+		{"default: [", "reading "},
+	}
+	for _, tt := range tests {
+		dir := tempDir(t)
+		writeConfig(t, dir, tt.config)
+		out, code := runBosh(t, dir, "", nil, "accounts")
+		if code != exitValidation || !strings.Contains(out, tt.msg) {
+			t.Errorf("%q: got exit code %d, want %d and %q:\n%s", tt.config, code, exitValidation, tt.msg, out)
+		}
+	}
+}
+
+func TestProfileUse(t *testing.T) {
+	h := newHarness(t)
+	api := flag.Lookup("a").Value.String()
+	writeConfig(t, os.Getenv("XDG_CONFIG_HOME"), `profiles:
+  mock:
+    address: `+api+`
+    email: dev@example.com
+    app: demo-key
+    tls:
+      insecure: true
+`)
+	defer func() { activeProfile = "" }()
+
+	h.fails(exitValidation, `unknown profile "nope"`, "", "profile", "use", "nope")
+
+	h.mustRun("", "profile", "use", "mock")
+	if activeProfile != "mock" || session.applicationKey != "demo-key" {
+		t.Errorf("got profile %q with application %q, want mock with demo-key", activeProfile, session.applicationKey)
+	}
+	h.contains(h.mustRun("", "profile", "list"), "* mock\t"+api)
+
+	// login uses the email of the profile.
+	h.contains(h.mustRun("secret\n", "login"), "Password: ")
+	if session.devEmail != "dev@example.com" {
+		t.Errorf("got developer %q, want dev@example.com", session.devEmail)
+	}
+}
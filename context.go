@@ -0,0 +1,179 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/abiosoft/ishell"
+)
+
+// contexts holds the named sessions of the shell. Each context has its own
+// developer login, application and user login. session points to the
+// context in use.
+var contexts = map[string]*state{"default": session}
+
+// contextName is the name of the context in use.
+var contextName = "default"
+
+// resetContexts replaces all contexts with a single empty default context.
+// It returns the names of the contexts other than default that were dropped.
+func resetContexts() []string {
+	var dropped []string
+	for name := range contexts {
+		if name != "default" {
+			dropped = append(dropped, name)
+		}
+	}
+	sort.Strings(dropped)
+
+	session = &state{client: session.client}
+	contexts = map[string]*state{"default": session}
+	contextName = "default"
+	return dropped
+}
+
+// prompt returns the shell prompt for the context in use. Contexts other
+// than the default context are named in the prompt.
+func prompt() string {
+	if contextName == "default" {
+		return session.prompt()
+	}
+	return "(" + contextName + ") " + session.prompt()
+}
+
+func createContext(c *ishell.Context) {
+	name, err := readOneArg("Name", c)
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	if !validName(name) {
+		c.Err(validationErrorf("invalid context name %q", name))
+		return
+	}
+	if _, ok := contexts[name]; ok {
+		c.Err(validationErrorf("context %s already exists", name))
+		return
+	}
+	session = &state{client: session.client}
+	contexts[name] = session
+	contextName = name
+	c.SetPrompt(prompt())
+}
+
+func useContext(c *ishell.Context) {
+	name, err := readOneArg("Name", c)
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	st, ok := contexts[name]
+	if !ok {
+		c.Err(validationErrorf("no context %s", name))
+		return
+	}
+	session = st
+	contextName = name
+	c.SetPrompt(prompt())
+}
+
+func dropContext(c *ishell.Context) {
+	name, err := readOneArg("Name", c)
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	if _, ok := contexts[name]; !ok {
+		c.Err(validationErrorf("no context %s", name))
+		return
+	}
+	if name == contextName {
+		c.Err(validationErrorf("cannot drop the context in use"))
+		return
+	}
+	delete(contexts, name)
+}
+
+// contextInfo describes a context in the output of ctx list.
+type contextInfo struct {
+	Name           string `json:"name"`
+	Current        bool   `json:"current"`
+	DevEmail       string `json:"dev_email,omitempty"`
+	ApplicationKey string `json:"application_key,omitempty"`
+	UserName       string `json:"user_name,omitempty"`
+}
+
+func listContexts(c *ishell.Context) {
+	names := make([]string, 0, len(contexts))
+	for name := range contexts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	infos := make([]contextInfo, 0, len(names))
+	for _, name := range names {
+		st := contexts[name]
+		infos = append(infos, contextInfo{
+			Name:           name,
+			Current:        name == contextName,
+			DevEmail:       st.devEmail,
+			ApplicationKey: st.applicationKey,
+			UserName:       st.userName,
+		})
+	}
+
+	if structuredOutput(c) {
+		printResult(c, infos)
+		return
+	}
+	lastResult = names
+	for _, name := range names {
+		marker := " "
+		if name == contextName {
+			marker = "*"
+		}
+		c.Printf("%s %s\t%s\n", marker, name, contexts[name].prompt())
+	}
+}
+
+// runInContext runs a command against another context without switching to
+// it, e.g. ctx run bob accounts.
+func runInContext(c *ishell.Context) {
+	if len(c.Args) < 2 {
+		c.Err(validationErrorf("usage: ctx run NAME command [args...]"))
+		return
+	}
+	st, ok := contexts[c.Args[0]]
+	if !ok {
+		c.Err(validationErrorf("no context %s", c.Args[0]))
+		return
+	}
+	if c.Args[1] == "ctx" {
+		c.Err(validationErrorf("ctx commands cannot be run in another context"))
+		return
+	}
+
+	root := &ishell.Cmd{}
+	for _, cmd := range c.Cmds() {
+		root.AddCmd(cmd)
+	}
+	cmd, args := root.FindCmd(c.Args[1:])
+	if cmd == nil || cmd.Func == nil {
+		c.Err(validationErrorf("unknown command %q", c.Args[1]))
+		return
+	}
+
+	prev := session
+	session = st
+	defer func() {
+		// Unless the command replaced all contexts, as profile use does,
+		// return to the context in use.
+		if contexts[contextName] == prev {
+			session = prev
+		}
+		// Commands such as login set the prompt for the context they ran in.
+		c.SetPrompt(prompt())
+	}()
+	c.Args = args
+	c.Cmd = *cmd
+	cmd.Func(c)
+}
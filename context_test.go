@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/abiosoft/ishell"
+)
+
+func TestContexts(t *testing.T) {
+	h := newHarness(t)
+	h.loginDev()
+	dev := session
+
+	h.mustRun("", "ctx", "new", "bob")
+	if contextName != "bob" || session.devClient != nil {
+		t.Fatalf("got context %q with developer %q, want an empty context bob", contextName, session.devEmail)
+	}
+	h.loginUser()
+	if prompt() != "(bob) demo-key/alice> " {
+		t.Errorf("got prompt %q", prompt())
+	}
+
+	h.mustRun("", "ctx", "use", "default")
+	if session != dev || prompt() != "dev@example.com> " {
+		t.Errorf("got prompt %q, want the default context", prompt())
+	}
+	h.contains(h.mustRun("", "ctx", "list"), "  bob\tdemo-key/alice> ", "* default\tdev@example.com> ")
+
+	h.contains(h.mustRun("", "ctx", "run", "bob", "accounts"), "Girokonto")
+	h.contains(h.mustRun("", "ctx", "run", "bob", "accounts", "|", "query", ".accounts[1].name"), `"Tagesgeld"`)
+	if session != dev {
+		t.Error("ctx run switched the context")
+	}
+	h.fails(exitAuth, "login as a user first", "", "accounts")
+
+	h.fails(exitValidation, "usage: ctx run NAME command", "", "ctx", "run", "bob")
+	h.fails(exitValidation, "no context carol", "", "ctx", "run", "carol", "accounts")
+	h.fails(exitValidation, "ctx commands cannot be run in another context", "", "ctx", "run", "bob", "ctx", "list")
+	h.fails(exitValidation, `unknown command "nosuch"`, "", "ctx", "run", "bob", "nosuch")
+	h.fails(exitValidation, "context bob already exists", "", "ctx", "new", "bob")
+	h.fails(exitValidation, `invalid context name "a b"`, "", "ctx", "new", "a b")
+	h.fails(exitValidation, "no context carol", "", "ctx", "use", "carol")
+	h.fails(exitValidation, "cannot drop the context in use", "", "ctx", "drop", "default")
+
+	h.mustRun("", "ctx", "drop", "bob")
+	h.fails(exitValidation, "no context bob", "", "ctx", "drop", "bob")
+}
+
+func TestRunInContextRestoresSession(t *testing.T) {
+	h := newHarness(t)
+	h.mustRun("", "ctx", "new", "bob")
+	h.mustRun("", "ctx", "use", "default")
+	def := session
+
+	h.shell.AddCmd(&ishell.Cmd{Name: "boom", Func: func(c *ishell.Context) {
+		if session != contexts["bob"] {
+			t.Error("boom did not run in the context bob")
+		}
+		panic("boom")
+	}})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("boom did not panic")
+			}
+		}()
+		h.shell.Process("ctx", "run", "bob", "boom")
+	}()
+	if session != def {
+		t.Error("session not restored after a panic")
+	}
+}
+
+func TestProfileUseDropsContexts(t *testing.T) {
+	h := newHarness(t)
+	writeConfig(t, os.Getenv("XDG_CONFIG_HOME"), `profiles:
+  mock:
+    address: `+flag.Lookup("a").Value.String()+`
+    tls:
+      insecure: true
+`)
+	defer func() { activeProfile = "" }()
+
+	h.mustRun("", "ctx", "new", "bob")
+	h.mustRun("", "ctx", "new", "carol")
+	out := h.mustRun("", "profile", "use", "mock")
+	h.contains(out, "Dropped contexts of the previous api: bob, carol")
+	if contextName != "default" || len(contexts) != 1 {
+		t.Errorf("got context %q of %d, want only the default context", contextName, len(contexts))
+	}
+
+	// Running profile use in another context leaves the new default context
+	// in use.
+	h.mustRun("", "ctx", "new", "dave")
+	h.mustRun("", "ctx", "use", "default")
+	h.mustRun("", "ctx", "run", "dave", "profile", "use", "mock")
+	if session != contexts["default"] || len(contexts) != 1 {
+		t.Errorf("got %d contexts, want the default context in use", len(contexts))
+	}
+}
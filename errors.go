@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"code.bankrs.com/bosgo"
+)
+
+// errorKind is the category of an error reported by a command.
+type errorKind int
+
+const (
+	kindGeneral errorKind = iota
+	kindSyntax
+	kindAuth
+	kindValidation
+	kindNetwork
+	kindAPI
+)
+
+// Exit codes used by bosh when running non-interactively. Each category of
+// error has its own exit code so callers can react to them.
+const (
+	exitOK         = 0
+	exitGeneral    = 1
+	exitSyntax     = 2
+	exitAuth       = 3
+	exitValidation = 4
+	exitNetwork    = 5
+	exitAPI        = 6
+)
+
+// Errors reported by commands that require a particular session.
+var (
+	errNoDeveloper   = authError("login to a developer account first")
+	errNoApplication = authError("use an application id first")
+	errNoUser        = authError("login as a user first")
+	errNotLoggedIn   = authError("not logged in as a user")
+)
+
+// cmdError is an error with a known category.
+type cmdError struct {
+	kind errorKind
+	err  error
+}
+
+func (e *cmdError) Error() string {
+	return e.err.Error()
+}
+
+func authError(msg string) error {
+	return &cmdError{kind: kindAuth, err: fmt.Errorf("%s", msg)}
+}
+
+func validationError(err error) error {
+	return &cmdError{kind: kindValidation, err: err}
+}
+
+func validationErrorf(format string, args ...interface{}) error {
+	return validationError(fmt.Errorf(format, args...))
+}
+
+func syntaxError(err error) error {
+	return &cmdError{kind: kindSyntax, err: err}
+}
+
+// classify returns the category of err.
+func classify(err error) errorKind {
+	switch e := err.(type) {
+	case *scriptError:
+		return classify(e.err)
+	case failureList:
+		if len(e) > 0 {
+			return classify(e[0])
+		}
+	case *cmdError:
+		return e.kind
+	case *url.Error:
+		return kindNetwork
+	case net.Error:
+		return kindNetwork
+	case *bosgo.Error:
+		if e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden {
+			return kindAuth
+		}
+		return kindAPI
+	}
+	return kindGeneral
+}
+
+// exitCode returns the process exit code for err.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	switch classify(err) {
+	case kindSyntax:
+		return exitSyntax
+	case kindAuth:
+		return exitAuth
+	case kindValidation:
+		return exitValidation
+	case kindNetwork:
+		return exitNetwork
+	case kindAPI:
+		return exitAPI
+	}
+	return exitGeneral
+}
+
+// failureList holds the errors of the statements that failed while a
+// script was running with errexit turned off.
+type failureList []error
+
+func (f failureList) Error() string {
+	lines := make([]string, 0, len(f)+1)
+	if len(f) == 1 {
+		lines = append(lines, "1 command failed:")
+	} else {
+		lines = append(lines, fmt.Sprintf("%d commands failed:", len(f)))
+	}
+	for _, err := range f {
+		lines = append(lines, "  "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
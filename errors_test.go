@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"code.bankrs.com/bosh/mockserver"
+)
+
+// TestExitCodes runs bosh as a separate process and checks that every
+// category of error ends it with its exit code.
+func TestExitCodes(t *testing.T) {
+	dir := tempDir(t)
+	api := []string{"-a", startServer(t), "-insecure"}
+	user := with(api, "-app", "demo-key", "-user", "alice", "-user-password", "secret")
+	tests := []struct {
+		name  string
+		args  []string
+		stdin string
+		code  int
+		msg   string
+	}{
+		{"ok", with(user, "accounts"), "", exitOK, "Girokonto"},
+		{"ok script", api, "useapp demo-key\nloginuser alice secret\naccounts\n", exitOK, "Tagesgeld"},
+		{"general", api, "nosuchcommand\n", exitGeneral, "<stdin>:1: incorrect input"},
+		{"syntax", with(user, "transactions", "--bogus"), "", exitSyntax, "flag provided but not defined: -bogus"},
+		{"syntax script", api, "echo 'open\n", exitSyntax, "<stdin>:1: unterminated single quote"},
+		{"auth", with(api, "accounts"), "", exitAuth, "login as a user first"},
+		{"auth login", with(api, "-app", "demo-key", "-user", "alice", "-user-password", "wrong", "accounts"), "", exitAuth, "login user alice"},
+		{"validation", with(user, "transactions", "--sort", "name"), "", exitValidation, "unknown sort order"},
+		{"network", []string{"-a", "127.0.0.1:1", "createdev", "new@example.com", "secret"}, "", exitNetwork, "connection refused"},
+		{"api", with(api, "createdev", "dev@example.com", "secret"), "", exitAPI, "email_taken"},
+		{"fail fast", api, "accounts\nnosuchcommand\n", exitAuth, "<stdin>:1: login as a user first"},
+		{"continue on error", with(api, "-continue-on-error"), "accounts\nnosuchcommand\n", exitAuth, "2 commands failed:"},
+	}
+	for _, tt := range tests {
+		out, code := runBosh(t, dir, tt.stdin, nil, tt.args...)
+		if code != tt.code {
+			t.Errorf("%s: got exit code %d, want %d:\n%s", tt.name, code, tt.code, out)
+		}
+		if !strings.Contains(out, tt.msg) {
+			t.Errorf("%s: output does not contain %q:\n%s", tt.name, tt.msg, out)
+		}
+	}
+}
+
+// startServer starts a mock server for the test and returns its address.
+func startServer(t *testing.T) string {
+	t.Helper()
+	srv, err := mockserver.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Log = ioutil.Discard
+	ts := httptest.NewTLSServer(srv)
+	t.Cleanup(ts.Close)
+	return strings.TrimPrefix(ts.URL, "https://")
+}
+
+// tempDir creates a directory that is removed at the end of the test.
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "bosh-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// runBosh runs bosh as a separate process with dir as its config home and
+// returns its output and exit code. The credentials of the environment are
+// cleared, env adds variables.
+func runBosh(t *testing.T, dir, stdin string, env []string, args ...string) (string, int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "BOSH_RUN_MAIN=1", "XDG_CONFIG_HOME="+dir,
+		"BOSH_EMAIL=", "BOSH_APP_KEY=", "BOSH_USER=")
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		exit, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatal(err)
+		}
+		return string(out), exit.ExitCode()
+	}
+	return string(out), exitOK
+}
+
+// with returns a copy of args with more appended.
+func with(args []string, more ...string) []string {
+	return append(append([]string(nil), args...), more...)
+}
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.bankrs.com/bosgo"
+	"github.com/abiosoft/ishell"
+)
+
+// exportFormats are the statement file formats written by exporttransactions.
+var exportFormats = map[string]func(io.Writer, []*statement) error{
+	"csv":     writeCSVStatements,
+	"ofx":     writeOFXStatements,
+	"qif":     writeQIFStatements,
+	"camt053": writeCAMTStatements,
+	"mt940":   writeMT940Statements,
+}
+
+// statement holds the transactions of an account entered between two dates
+// and the balances of the account before and after them.
+type statement struct {
+	account  bosgo.Account
+	txs      []bosgo.Transaction
+	from, to time.Time
+	opening  int64 // in cents
+	closing  int64 // in cents
+	created  time.Time
+}
+
+func exportTransactions(c *ishell.Context) {
+	if session.userClient == nil {
+		c.Err(errNoUser)
+		return
+	}
+
+	var format, out string
+	f := transactionFilter{now: time.Now()}
+	fs := newCmdFlags("exporttransactions")
+	fs.StringVar(&format, "format", "csv", "statement format: csv, ofx, qif, camt053 or mt940")
+	fs.StringVar(&out, "out", "", "file to write the statement to instead of the shell")
+	fs.StringVar(&f.account, "account", "", "only export transactions of the account with this id")
+	fs.StringVar(&f.from, "from", "", "only export transactions entered on or after this date (yyyy-mm-dd)")
+	fs.StringVar(&f.to, "to", "", "only export transactions entered on or before this date (yyyy-mm-dd)")
+	if err := parseCmdFlags(c, fs); err != nil {
+		c.Err(err)
+		return
+	}
+	if len(c.Args) > 0 {
+		c.Err(syntaxError(fmt.Errorf("unexpected argument %q", c.Args[0])))
+		return
+	}
+	write, ok := exportFormats[strings.ToLower(format)]
+	if !ok {
+		c.Err(validationErrorf("unknown export format %q, expected csv, ofx, qif, camt053 or mt940", format))
+		return
+	}
+	if err := f.check(); err != nil {
+		c.Err(err)
+		return
+	}
+
+	stmts, err := loadStatements(&f)
+	if err != nil {
+		c.Err(err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := write(&buf, stmts); err != nil {
+		c.Err(err)
+		return
+	}
+	if out == "" {
+		lastResult = buf.String()
+		c.Print(buf.String())
+		return
+	}
+	if err := ioutil.WriteFile(out, buf.Bytes(), 0644); err != nil {
+		c.Err(err)
+		return
+	}
+	n := 0
+	for _, stmt := range stmts {
+		n += len(stmt.txs)
+	}
+	lastResult = out
+	c.Printf("Exported %d transactions to %s\n", n, out)
+}
+
+// loadStatements requests the accounts selected by f with all of their
+// transactions and builds a statement for each of them.
+func loadStatements(f *transactionFilter) ([]*statement, error) {
+	var accounts []bosgo.Account
+	if f.account != "" {
+		account, err := session.userClient.Accounts.Get(f.account).Send()
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, *account)
+	} else {
+		page, err := session.userClient.Accounts.List().Send()
+		if err != nil {
+			return nil, err
+		}
+		accounts = page.Accounts
+	}
+
+	now := time.Now()
+	var stmts []*statement
+	for _, account := range accounts {
+		txs, err := listTransactions(&transactionFilter{account: strconv.FormatInt(account.ID, 10), all: true})
+		if err != nil {
+			return nil, err
+		}
+		stmt, err := newStatement(account, txs, f, now)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts, nil
+}
+
+// newStatement selects the transactions of an account entered in the date
+// range of f, oldest first. The balances are derived from the current
+// balance of the account and all of its transactions.
+func newStatement(account bosgo.Account, txs []bosgo.Transaction, f *transactionFilter, now time.Time) (*statement, error) {
+	balance, err := parseCents(account.Balance)
+	if err != nil {
+		return nil, fmt.Errorf("account %d: invalid balance %q", account.ID, account.Balance)
+	}
+	stmt := &statement{account: account, closing: balance, created: now}
+	for _, tx := range txs {
+		amount, err := parseCents(tx.Value.Value)
+		if err != nil {
+			return nil, fmt.Errorf("transaction %d: invalid amount %q", tx.ID, tx.Value.Value)
+		}
+		date := tx.EntryDate.Format("2006-01-02")
+		switch {
+		case f.to != "" && date > f.to:
+			stmt.closing -= amount
+		case f.from != "" && date < f.from:
+		default:
+			stmt.txs = append(stmt.txs, tx)
+		}
+	}
+	stmt.opening = stmt.closing
+	for _, tx := range stmt.txs {
+		amount, _ := parseCents(tx.Value.Value)
+		stmt.opening -= amount
+	}
+	(&transactionFilter{sort: "date"}).sortTransactions(stmt.txs)
+
+	stmt.from, _ = time.Parse("2006-01-02", f.from)
+	stmt.to, _ = time.Parse("2006-01-02", f.to)
+	if f.from == "" && len(stmt.txs) > 0 {
+		stmt.from = stmt.txs[0].EntryDate
+	}
+	if f.to == "" {
+		stmt.to = now
+		if len(stmt.txs) > 0 {
+			stmt.to = stmt.txs[len(stmt.txs)-1].EntryDate
+		}
+	}
+	if stmt.from.IsZero() {
+		stmt.from = stmt.to
+	}
+	return stmt, nil
+}
+
+// parseCents parses a decimal amount such as -12.3 into cents. Amounts
+// with more than two decimals are rounded half to even, as banks do.
+func parseCents(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	digits := strings.TrimLeft(s, "+-")
+	units, frac := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		units, frac = digits[:i], digits[i+1:]
+	}
+	if units == "" && frac == "" {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	var rest string
+	if len(frac) > 2 {
+		frac, rest = frac[:2], frac[2:]
+		if strings.Trim(rest, "0123456789") != "" {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	if units == "" {
+		units = "0"
+	}
+	cents, err := strconv.ParseUint(units+frac, 10, 63)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	if rest != "" {
+		half := strings.TrimRight(rest[1:], "0") == ""
+		if rest[0] > '5' || rest[0] == '5' && (!half || cents%2 == 1) {
+			cents++
+		}
+	}
+	if neg {
+		return -int64(cents), nil
+	}
+	return int64(cents), nil
+}
+
+// formatCents formats an amount in cents with two decimals separated by
+// sep, without a sign.
+func formatCents(cents int64, sep string) string {
+	if cents < 0 {
+		cents = -cents
+	}
+	return fmt.Sprintf("%d%s%02d", cents/100, sep, cents%100)
+}
+
+func signedCents(cents int64) string {
+	if cents < 0 {
+		return "-" + formatCents(cents, ".")
+	}
+	return formatCents(cents, ".")
+}
+
+// booked reports whether a transaction is settled. Transactions are pending
+// until then.
+func booked(tx bosgo.Transaction, now time.Time) bool {
+	return !tx.SettlementDate.IsZero() && !tx.SettlementDate.After(now)
+}
+
+// valueDate returns the settlement date of a transaction or its entry date
+// if it is not settled yet.
+func valueDate(tx bosgo.Transaction) time.Time {
+	if tx.SettlementDate.IsZero() {
+		return tx.EntryDate
+	}
+	return tx.SettlementDate
+}
+
+func writeCSVStatements(w io.Writer, stmts []*statement) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Account", "IBAN", "Booking Date", "Value Date", "Amount", "Currency", "Counterparty", "Counterparty IBAN", "Purpose"})
+	for _, stmt := range stmts {
+		for _, tx := range stmt.txs {
+			amount, _ := parseCents(tx.Value.Value)
+			cw.Write([]string{
+				stmt.account.Name,
+				stmt.account.IBAN,
+				tx.EntryDate.Format("2006-01-02"),
+				valueDate(tx).Format("2006-01-02"),
+				signedCents(amount),
+				tx.Value.Currency,
+				tx.Counterparty.Name,
+				tx.Counterparty.Account.IBAN,
+				tx.Usage,
+			})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeQIFStatements writes the statements in Quicken Interchange Format,
+// one bank account section per statement.
+func writeQIFStatements(w io.Writer, stmts []*statement) error {
+	var buf bytes.Buffer
+	for _, stmt := range stmts {
+		fmt.Fprintf(&buf, "!Account\nN%s\nTBank\n^\n!Type:Bank\n", singleLine(accountName(stmt.account)))
+		for _, tx := range stmt.txs {
+			amount, _ := parseCents(tx.Value.Value)
+			fmt.Fprintf(&buf, "D%s\nT%s\n", tx.EntryDate.Format("01/02/2006"), signedCents(amount))
+			if tx.Counterparty.Name != "" {
+				fmt.Fprintf(&buf, "P%s\n", singleLine(tx.Counterparty.Name))
+			}
+			if tx.Usage != "" {
+				fmt.Fprintf(&buf, "M%s\n", singleLine(tx.Usage))
+			}
+			buf.WriteString("^\n")
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// singleLine replaces runs of white space in s, including line breaks,
+// with single spaces.
+func singleLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func accountName(account bosgo.Account) string {
+	if account.Name != "" {
+		return account.Name
+	}
+	if account.IBAN != "" {
+		return account.IBAN
+	}
+	return strconv.FormatInt(account.ID, 10)
+}
+
+// OFX 2 elements of bank statement responses.
+type (
+	ofxDoc struct {
+		XMLName xml.Name  `xml:"OFX"`
+		Signon  ofxSignon `xml:"SIGNONMSGSRSV1>SONRS"`
+		Stmts   []ofxStmt `xml:"BANKMSGSRSV1>STMTTRNRS"`
+	}
+	ofxStatus struct {
+		Code     int    `xml:"CODE"`
+		Severity string `xml:"SEVERITY"`
+	}
+	ofxSignon struct {
+		Status   ofxStatus `xml:"STATUS"`
+		DTServer string    `xml:"DTSERVER"`
+		Language string    `xml:"LANGUAGE"`
+	}
+	ofxStmt struct {
+		TrnUID  int       `xml:"TRNUID"`
+		Status  ofxStatus `xml:"STATUS"`
+		CurDef  string    `xml:"STMTRS>CURDEF"`
+		Account ofxAcct   `xml:"STMTRS>BANKACCTFROM"`
+		DTStart string    `xml:"STMTRS>BANKTRANLIST>DTSTART"`
+		DTEnd   string    `xml:"STMTRS>BANKTRANLIST>DTEND"`
+		Trns    []ofxTrn  `xml:"STMTRS>BANKTRANLIST>STMTTRN"`
+		BalAmt  string    `xml:"STMTRS>LEDGERBAL>BALAMT"`
+		DTAsOf  string    `xml:"STMTRS>LEDGERBAL>DTASOF"`
+	}
+	ofxAcct struct {
+		BankID   string `xml:"BANKID"`
+		AcctID   string `xml:"ACCTID"`
+		AcctType string `xml:"ACCTTYPE"`
+	}
+	ofxTrn struct {
+		TrnType  string   `xml:"TRNTYPE"`
+		DTPosted string   `xml:"DTPOSTED"`
+		DTUser   string   `xml:"DTUSER"`
+		TrnAmt   string   `xml:"TRNAMT"`
+		FITID    string   `xml:"FITID"`
+		Name     string   `xml:"NAME,omitempty"`
+		To       *ofxAcct `xml:"BANKACCTTO,omitempty"`
+		Memo     string   `xml:"MEMO,omitempty"`
+	}
+)
+
+// writeOFXStatements writes the statements as an OFX 2.1.1 bank statement
+// response.
+func writeOFXStatements(w io.Writer, stmts []*statement) error {
+	doc := ofxDoc{Signon: ofxSignon{Status: ofxStatus{0, "INFO"}, Language: "ENG"}}
+	for i, stmt := range stmts {
+		doc.Signon.DTServer = ofxDate(stmt.created)
+		s := ofxStmt{
+			TrnUID: i + 1,
+			Status: ofxStatus{0, "INFO"},
+			CurDef: stmt.account.Currency,
+			Account: ofxAcct{
+				BankID:   bankCode(stmt.account.IBAN, stmt.account.ProviderID),
+				AcctID:   accountID(stmt.account),
+				AcctType: ofxAccountType(stmt.account.Type),
+			},
+			DTStart: ofxDate(stmt.from),
+			DTEnd:   ofxDate(stmt.to),
+			BalAmt:  signedCents(stmt.closing),
+			DTAsOf:  ofxDate(stmt.to),
+		}
+		for _, tx := range stmt.txs {
+			amount, _ := parseCents(tx.Value.Value)
+			trn := ofxTrn{
+				TrnType:  "CREDIT",
+				DTPosted: ofxDate(tx.EntryDate),
+				DTUser:   ofxDate(valueDate(tx)),
+				TrnAmt:   signedCents(amount),
+				FITID:    strconv.FormatInt(tx.ID, 10),
+				Name:     truncate(tx.Counterparty.Name, 32),
+				Memo:     truncate(tx.Usage, 255),
+			}
+			if amount < 0 {
+				trn.TrnType = "DEBIT"
+			}
+			if iban := tx.Counterparty.Account.IBAN; iban != "" {
+				trn.To = &ofxAcct{BankID: bankCode(iban, ""), AcctID: iban, AcctType: "CHECKING"}
+			}
+			s.Trns = append(s.Trns, trn)
+		}
+		doc.Stmts = append(doc.Stmts, s)
+	}
+	io.WriteString(w, xml.Header)
+	io.WriteString(w, `<?OFX OFXHEADER="200" VERSION="211" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>`+"\n")
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func ofxDate(t time.Time) string {
+	return t.Format("20060102")
+}
+
+func ofxAccountType(typ bosgo.AccountType) string {
+	switch strings.ToLower(string(typ)) {
+	case "savings":
+		return "SAVINGS"
+	case "credit_card", "creditcard", "credit":
+		return "CREDITLINE"
+	}
+	return "CHECKING"
+}
+
+// bankCode returns the bank code of an IBAN, the 8 digit Bankleitzahl for
+// German IBANs and the 4 characters after the check digits otherwise.
+func bankCode(iban, fallback string) string {
+	switch {
+	case strings.HasPrefix(iban, "DE") && len(iban) == 22:
+		return iban[4:12]
+	case len(iban) >= 8:
+		return iban[4:8]
+	}
+	return truncate(fallback, 9)
+}
+
+// accountID returns the IBAN of an account or its number if it has none.
+func accountID(account bosgo.Account) string {
+	if account.IBAN != "" {
+		return account.IBAN
+	}
+	if account.Number != "" {
+		return account.Number
+	}
+	return strconv.FormatInt(account.ID, 10)
+}
+
+func truncate(s string, n int) string {
+	s = singleLine(s)
+	if r := []rune(s); len(r) > n {
+		return string(r[:n])
+	}
+	return s
+}
+
+// ISO 20022 camt.053.001.02 elements of bank to customer statements.
+// Optional elements are pointers since encoding/xml writes the parents of
+// empty elements with a path.
+type (
+	camtDoc struct {
+		XMLName xml.Name   `xml:"urn:iso:std:iso:20022:tech:xsd:camt.053.001.02 Document"`
+		MsgID   string     `xml:"BkToCstmrStmt>GrpHdr>MsgId"`
+		Created string     `xml:"BkToCstmrStmt>GrpHdr>CreDtTm"`
+		Stmts   []camtStmt `xml:"BkToCstmrStmt>Stmt"`
+	}
+	camtStmt struct {
+		ID       string      `xml:"Id"`
+		Created  string      `xml:"CreDtTm"`
+		From     string      `xml:"FrToDt>FrDtTm"`
+		To       string      `xml:"FrToDt>ToDtTm"`
+		Account  camtAccount `xml:"Acct"`
+		Balances []camtBal   `xml:"Bal"`
+		Entries  []camtEntry `xml:"Ntry"`
+	}
+	camtAccount struct {
+		ID       camtAcctID `xml:"Id"`
+		Currency string     `xml:"Ccy,omitempty"`
+		Name     string     `xml:"Nm,omitempty"`
+	}
+	camtAcctID struct {
+		IBAN  string     `xml:"IBAN,omitempty"`
+		Other *camtOther `xml:"Othr,omitempty"`
+	}
+	camtOther struct {
+		ID string `xml:"Id"`
+	}
+	camtAmount struct {
+		Currency string `xml:"Ccy,attr"`
+		Value    string `xml:",chardata"`
+	}
+	camtBal struct {
+		Type   string     `xml:"Tp>CdOrPrtry>Cd"`
+		Amount camtAmount `xml:"Amt"`
+		CdtDbt string     `xml:"CdtDbtInd"`
+		Date   string     `xml:"Dt>Dt"`
+	}
+	camtEntry struct {
+		Amount      camtAmount    `xml:"Amt"`
+		CdtDbt      string        `xml:"CdtDbtInd"`
+		Status      string        `xml:"Sts"`
+		BookingDate string        `xml:"BookgDt>Dt"`
+		ValueDate   string        `xml:"ValDt>Dt"`
+		Ref         string        `xml:"AcctSvcrRef"`
+		TxCode      string        `xml:"BkTxCd>Prtry>Cd"`
+		Details     camtTxDetails `xml:"NtryDtls>TxDtls"`
+	}
+	camtTxDetails struct {
+		Parties    *camtParties `xml:"RltdPties,omitempty"`
+		Remittance *camtRmtInf  `xml:"RmtInf,omitempty"`
+	}
+	camtParties struct {
+		Debtor       *camtParty   `xml:"Dbtr,omitempty"`
+		DebtorAcct   *camtAccount `xml:"DbtrAcct,omitempty"`
+		Creditor     *camtParty   `xml:"Cdtr,omitempty"`
+		CreditorAcct *camtAccount `xml:"CdtrAcct,omitempty"`
+	}
+	camtParty struct {
+		Name string `xml:"Nm"`
+	}
+	camtRmtInf struct {
+		Unstructured string `xml:"Ustrd"`
+	}
+)
+
+// writeCAMTStatements writes the statements as an ISO 20022 camt.053
+// message.
+func writeCAMTStatements(w io.Writer, stmts []*statement) error {
+	var doc camtDoc
+	for i, stmt := range stmts {
+		doc.Created = stmt.created.Format("2006-01-02T15:04:05")
+		doc.MsgID = "BOSH" + stmt.created.Format("20060102150405")
+		s := camtStmt{
+			ID:      fmt.Sprintf("%s-%d", doc.MsgID, i+1),
+			Created: doc.Created,
+			From:    stmt.from.Format("2006-01-02") + "T00:00:00",
+			To:      stmt.to.Format("2006-01-02") + "T23:59:59",
+			Account: camtAccount{
+				ID:       camtAccountID(accountID(stmt.account)),
+				Currency: stmt.account.Currency,
+				Name:     truncate(stmt.account.Name, 70),
+			},
+			Balances: []camtBal{
+				camtBalance("OPBD", stmt.opening, stmt.account.Currency, stmt.from),
+				camtBalance("CLBD", stmt.closing, stmt.account.Currency, stmt.to),
+			},
+		}
+		for _, tx := range stmt.txs {
+			amount, _ := parseCents(tx.Value.Value)
+			e := camtEntry{
+				Amount:      camtAmount{tx.Value.Currency, formatCents(amount, ".")},
+				CdtDbt:      "CRDT",
+				Status:      "BOOK",
+				BookingDate: tx.EntryDate.Format("2006-01-02"),
+				ValueDate:   valueDate(tx).Format("2006-01-02"),
+				Ref:         strconv.FormatInt(tx.ID, 10),
+				TxCode:      "NTRF",
+			}
+			if !booked(tx, stmt.created) {
+				e.Status = "PDNG"
+			}
+			if tx.Usage != "" {
+				e.Details.Remittance = &camtRmtInf{truncate(tx.Usage, 140)}
+			}
+			var party *camtParty
+			var acct *camtAccount
+			if name := truncate(tx.Counterparty.Name, 70); name != "" {
+				party = &camtParty{name}
+			}
+			if iban := tx.Counterparty.Account.IBAN; iban != "" {
+				acct = &camtAccount{ID: camtAccountID(iban)}
+			}
+			if amount < 0 {
+				e.CdtDbt = "DBIT"
+			}
+			if party != nil || acct != nil {
+				if amount < 0 {
+					e.Details.Parties = &camtParties{Creditor: party, CreditorAcct: acct}
+				} else {
+					e.Details.Parties = &camtParties{Debtor: party, DebtorAcct: acct}
+				}
+			}
+			s.Entries = append(s.Entries, e)
+		}
+		doc.Stmts = append(doc.Stmts, s)
+	}
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// camtAccountID identifies an account by its IBAN or, if id is not an
+// IBAN, by another id.
+func camtAccountID(id string) camtAcctID {
+	if len(id) > 4 && id[0] >= 'A' && id[0] <= 'Z' && id[1] >= 'A' && id[1] <= 'Z' && id[2] >= '0' && id[2] <= '9' {
+		return camtAcctID{IBAN: id}
+	}
+	return camtAcctID{Other: &camtOther{id}}
+}
+
+func camtBalance(typ string, cents int64, currency string, date time.Time) camtBal {
+	b := camtBal{Type: typ, Amount: camtAmount{currency, formatCents(cents, ".")}, CdtDbt: "CRDT", Date: date.Format("2006-01-02")}
+	if cents < 0 {
+		b.CdtDbt = "DBIT"
+	}
+	return b
+}
+
+// writeMT940Statements writes the statements as SWIFT MT940 messages.
+func writeMT940Statements(w io.Writer, stmts []*statement) error {
+	var buf bytes.Buffer
+	for i, stmt := range stmts {
+		currency := stmt.account.Currency
+		fmt.Fprintf(&buf, ":20:BOSH%s%d\r\n", stmt.created.Format("060102"), i+1)
+		fmt.Fprintf(&buf, ":25:%s\r\n", accountID(stmt.account))
+		fmt.Fprintf(&buf, ":28C:%05d/001\r\n", i+1)
+		fmt.Fprintf(&buf, ":60F:%s%s%s%s\r\n", mt940Mark(stmt.opening), stmt.from.Format("060102"), currency, formatCents(stmt.opening, ","))
+		for _, tx := range stmt.txs {
+			amount, _ := parseCents(tx.Value.Value)
+			fmt.Fprintf(&buf, ":61:%s%s%s%sNTRF%s\r\n", valueDate(tx).Format("060102"), tx.EntryDate.Format("0102"),
+				mt940Mark(amount), formatCents(amount, ","), truncate(strconv.FormatInt(tx.ID, 10), 16))
+			info := strings.TrimSpace(strings.Join([]string{tx.Counterparty.Name, tx.Counterparty.Account.IBAN, tx.Usage}, " "))
+			for j, line := range wrapMT940(info, 65, 6) {
+				if j == 0 {
+					fmt.Fprintf(&buf, ":86:%s\r\n", line)
+				} else {
+					fmt.Fprintf(&buf, "%s\r\n", line)
+				}
+			}
+		}
+		fmt.Fprintf(&buf, ":62F:%s%s%s%s\r\n", mt940Mark(stmt.closing), stmt.to.Format("060102"), currency, formatCents(stmt.closing, ","))
+		buf.WriteString("-\r\n")
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func mt940Mark(cents int64) string {
+	if cents < 0 {
+		return "D"
+	}
+	return "C"
+}
+
+// wrapMT940 splits s into at most n lines of at most width characters.
+func wrapMT940(s string, width, n int) []string {
+	r := []rune(singleLine(s))
+	var lines []string
+	for len(r) > 0 && len(lines) < n {
+		l := width
+		if len(r) < l {
+			l = len(r)
+		}
+		lines = append(lines, string(r[:l]))
+		r = r[l:]
+	}
+	return lines
+}
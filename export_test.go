@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"code.bankrs.com/bosgo"
+)
+
+func TestParseCents(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"12", 1200},
+		{"-12.3", -1230},
+		{"+0.05", 5},
+		{".5", 50},
+		{"7.", 700},
+		{" 1024.50 ", 102450},
+		{"1.004", 100},
+		{"1.006", 101},
+		{"1.005", 100},
+		{"1.015", 102},
+		{"1.0051", 101},
+		{"1.00500", 100},
+		{"-2.675", -268},
+		{"-2.665", -266},
+		{"0.999", 100},
+		{"99.995", 10000},
+	}
+	for _, tt := range tests {
+		got, err := parseCents(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseCents(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "-", ".", "ten", "1.2x", "1.234x", "1,50", "1.2.3"} {
+		if got, err := parseCents(bad); err == nil {
+			t.Errorf("parseCents(%q) = %d, want an error", bad, got)
+		}
+	}
+}
+
+func TestExportTransactions(t *testing.T) {
+	h := newHarness(t)
+	h.loginUser()
+
+	h.mustRun("", "accounts")
+	giro := strconv.FormatInt(lastResult.(*bosgo.AccountPage).Accounts[0].ID, 10)
+
+	// The statements cover the rent, card payment and subscription of the
+	// Girokonto, its balance is 2500.00 before and 1577.66 after them.
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{"csv", []string{"Account,IBAN,Booking Date,Value Date,Amount,Currency,Counterparty,Counterparty IBAN,Purpose\n",
+			"Girokonto,DE89370400440532013000,2018-11-02,2018-11-02,-850.00,EUR,Hausverwaltung Berlin,DE02500105170137075030,Rent November\n"}},
+		{"ofx", []string{`<?OFX OFXHEADER="200"`, "<ACCTID>DE89370400440532013000</ACCTID>", "<TRNTYPE>DEBIT</TRNTYPE>",
+			"<TRNAMT>-62.35</TRNAMT>", "<MEMO>Subscription</MEMO>", "<BALAMT>1577.66</BALAMT>"}},
+		{"qif", []string{"!Type:Bank\n", "D11/02/2018\nT-850.00\nPHausverwaltung Berlin\nMRent November\n^\n"}},
+		{"camt053", []string{`<Document xmlns="urn:iso:std:iso:20022:tech:xsd:camt.053.001.02">`, "<IBAN>DE89370400440532013000</IBAN>",
+			`<Cd>OPBD</Cd>`, `<Amt Ccy="EUR">2500.00</Amt>`, `<Amt Ccy="EUR">1577.66</Amt>`, "<CdtDbtInd>DBIT</CdtDbtInd>",
+			"<Nm>Hausverwaltung Berlin</Nm>", "<Ustrd>Rent November</Ustrd>"}},
+		{"mt940", []string{":25:DE89370400440532013000\r\n", ":60F:C181102EUR2500,00\r\n", ":61:1811021102D850,00NTRF",
+			":86:Hausverwaltung Berlin DE02500105170137075030 Rent November\r\n", ":62F:C181112EUR1577,66\r\n-\r\n"}},
+	}
+	for _, tt := range tests {
+		out := h.mustRun("", "exporttransactions", "--format", tt.format, "--account", giro, "--from", "2018-11-02", "--to", "2018-11-12")
+		h.contains(out, tt.want...)
+		if strings.Contains(out, "Salary") || strings.Contains(out, "savings") {
+			t.Errorf("%s statement contains transactions outside of the date range:\n%s", tt.format, out)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "bosh-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "statement.csv")
+	h.contains(h.mustRun("", "exporttransactions", "--out", file), "Exported 6 transactions to "+file)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 7 {
+		t.Errorf("got %d lines in exported file, want 7:\n%s", n, data)
+	}
+
+	h.fails(exitValidation, "unknown export format", "", "exporttransactions", "--format", "xls")
+	h.fails(exitValidation, "yyyy-mm-dd", "", "exporttransactions", "--to", "yesterday")
+	h.fails(exitAPI, "not_found", "", "exporttransactions", "--account", "999")
+}
@@ -3,7 +3,7 @@ module code.bankrs.com/bosh
 require (
 	code.bankrs.com/bosgo v0.6.6
 	github.com/abiosoft/ishell v2.0.0+incompatible
-	github.com/abiosoft/readline v0.0.0-20180607040430-155bce2042db // indirect
+	github.com/abiosoft/readline v0.0.0-20180607040430-155bce2042db
 	github.com/chzyer/logex v1.1.10 // indirect
 	github.com/chzyer/test v0.0.0-20180213035817-a1ea475d72b1 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -11,7 +11,9 @@ require (
 	github.com/flynn-archive/go-shlex v0.0.0-20150515145356-3f9db97f8568 // indirect
 	github.com/mattn/go-colorable v0.0.9 // indirect
 	github.com/mattn/go-isatty v0.0.4
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/testify v1.2.2 // indirect
 	golang.org/x/sys v0.0.0-20181128092732-4ed8d59d0b35 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
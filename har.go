@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+var harFile = flag.String("har", "", "write all http traffic with the api to a file in HTTP Archive (HAR) format")
+
+// The types below are the parts of the HAR 1.2 format written by bosh, see
+// http://www.softwareishard.com/blog/har-12-spec/.
+
+type harFileContent struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+
+	// Error describes why no response was received, as in the HAR files
+	// of Chrome.
+	Error string `json:"_error,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harTransport captures the traffic passing through it in a HAR file. The
+// file is rewritten after every request so it is complete even when bosh is
+// interrupted.
+type harTransport struct {
+	next http.RoundTripper
+	file string
+
+	mu      sync.Mutex
+	content harFileContent
+}
+
+// archiver is the HAR transport in use, if any.
+var archiver *harTransport
+
+// startHAR sets up capturing traffic as requested by the -har flag.
+func startHAR() error {
+	if *harFile == "" {
+		return nil
+	}
+	archiver = &harTransport{
+		file: *harFile,
+		content: harFileContent{Log: harLog{
+			Version: "1.2",
+			Creator: harCreator{Name: "bosh", Version: "1.0"},
+			Entries: []harEntry{},
+		}},
+	}
+	return archiver.save()
+}
+
+func (t *harTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	waited := time.Since(start)
+	entry := harEntry{
+		StartedDateTime: start.Format("2006-01-02T15:04:05.000Z07:00"),
+		Request:         harRequestOf(req, body),
+	}
+	if err != nil {
+		// Requests that fail without a response, e.g. on DNS, TLS or pin
+		// errors, are captured with the error and status 0.
+		entry.Time = milliseconds(waited)
+		entry.Response = harResponse{
+			Cookies:     []harNameValue{},
+			Headers:     []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+			Error:       err.Error(),
+		}
+		entry.Timings = harTimings{Wait: milliseconds(waited)}
+		if saveErr := t.add(entry); saveErr != nil {
+			return nil, saveErr
+		}
+		return nil, err
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	received := time.Since(start) - waited
+
+	entry.Time = milliseconds(waited + received)
+	// The size describes the text as stored, which has its secrets
+	// redacted; the body size is what was received.
+	text := redactBody(resp.Header.Get("Content-Type"), respBody)
+	entry.Response = harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(resp.Header),
+		Content: harContent{
+			Size:     len(text),
+			MimeType: resp.Header.Get("Content-Type"),
+			Text:     text,
+		},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    len(respBody),
+	}
+	entry.Timings = harTimings{
+		Wait:    milliseconds(waited),
+		Receive: milliseconds(received),
+	}
+	if err := t.add(entry); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// harRequestOf describes a request with its body in a HAR entry.
+func harRequestOf(req *http.Request, body []byte) harRequest {
+	r := harRequest{
+		Method:      req.Method,
+		URL:         redactURL(req.URL),
+		HTTPVersion: req.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(req.Header),
+		QueryString: harQuery(req.URL),
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+	if r.HTTPVersion == "" {
+		r.HTTPVersion = "HTTP/1.1"
+	}
+	if len(body) > 0 {
+		r.PostData = &harPostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     redactBody(req.Header.Get("Content-Type"), body),
+		}
+	}
+	return r
+}
+
+// add appends an entry and writes the file.
+func (t *harTransport) add(entry harEntry) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.content.Log.Entries = append(t.content.Log.Entries, entry)
+	return t.write()
+}
+
+func (t *harTransport) save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.write()
+}
+
+// write writes the file. The lock is held so that a write with fewer
+// entries cannot replace a later one.
+func (t *harTransport) write() error {
+	data, err := json.MarshalIndent(&t.content, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(t.file, data, 0600)
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	h = redactHeader(h)
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	headers := []harNameValue{}
+	for _, name := range names {
+		for _, v := range h[name] {
+			headers = append(headers, harNameValue{Name: name, Value: v})
+		}
+	}
+	return headers
+}
+
+func harQuery(u *url.URL) []harNameValue {
+	q := redactValues(u.Query())
+	names := make([]string, 0, len(q))
+	for name := range q {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	query := []harNameValue{}
+	for _, name := range names {
+		for _, v := range q[name] {
+			query = append(query, harNameValue{Name: name, Value: v})
+		}
+	}
+	return query
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// useHAR captures traffic in file, or stops capturing if file is empty.
+func useHAR(t *testing.T, file string) {
+	t.Helper()
+	flag.Set("har", file)
+	archiver = nil
+	if err := startHAR(); err != nil {
+		t.Fatal(err)
+	}
+	if err := connect(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// keys returns the sorted keys of a JSON object.
+func keys(v interface{}) []string {
+	var ks []string
+	for k := range v.(map[string]interface{}) {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+func TestHAR(t *testing.T) {
+	h := newHarness(t)
+	file := filepath.Join(tempDir(t), "traffic.har")
+	useHAR(t, file)
+	defer useHAR(t, "")
+
+	h.loginDev()
+	h.loginUser()
+	h.mustRun("", "transactions", "--limit", "2")
+	flag.Set("a", "127.0.0.1:1")
+	if err := connect(); err != nil {
+		t.Fatal(err)
+	}
+	h.fails(exitNetwork, "connection refused", "", "createdev", "new@example.com", "secret")
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("HAR file contains the password:\n%s", data)
+	}
+	var har map[string]interface{}
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatal(err)
+	}
+
+	log := har["log"].(map[string]interface{})
+	if got := keys(log); !reflect.DeepEqual(got, []string{"creator", "entries", "version"}) {
+		t.Errorf("got log fields %v", got)
+	}
+	if log["version"] != "1.2" || log["creator"].(map[string]interface{})["name"] != "bosh" {
+		t.Errorf("got version %v by %v", log["version"], log["creator"])
+	}
+	entries := log["entries"].([]interface{})
+	if len(entries) != 4 {
+		t.Fatalf("got %d entries, want 4:\n%s", len(entries), data)
+	}
+
+	entryKeys := []string{"cache", "request", "response", "startedDateTime", "time", "timings"}
+	requestKeys := []string{"bodySize", "cookies", "headers", "headersSize", "httpVersion", "method", "queryString", "url"}
+	responseKeys := []string{"bodySize", "content", "cookies", "headers", "headersSize", "httpVersion", "redirectURL", "status", "statusText"}
+	for i, e := range entries {
+		entry := e.(map[string]interface{})
+		if got := keys(entry); !reflect.DeepEqual(got, entryKeys) {
+			t.Errorf("entry %d: got fields %v, want %v", i, got, entryKeys)
+		}
+		req := entry["request"].(map[string]interface{})
+		got := keys(req)
+		if req["method"] == "POST" {
+			got = without(got, "postData")
+		}
+		if !reflect.DeepEqual(got, requestKeys) {
+			t.Errorf("entry %d: got request fields %v, want %v", i, got, requestKeys)
+		}
+		resp := entry["response"].(map[string]interface{})
+		if got := without(keys(resp), "_error"); !reflect.DeepEqual(got, responseKeys) {
+			t.Errorf("entry %d: got response fields %v, want %v", i, got, responseKeys)
+		}
+		if got := keys(entry["timings"]); !reflect.DeepEqual(got, []string{"receive", "send", "wait"}) {
+			t.Errorf("entry %d: got timings %v", i, got)
+		}
+	}
+
+	login := entries[0].(map[string]interface{})
+	req := login["request"].(map[string]interface{})
+	post := req["postData"].(map[string]interface{})
+	if req["method"] != "POST" || !strings.Contains(post["text"].(string), `"password": "***"`) {
+		t.Errorf("got login request %v", req)
+	}
+	if status := login["response"].(map[string]interface{})["status"]; status != 200.0 {
+		t.Errorf("got login status %v, want 200", status)
+	}
+
+	txs := entries[2].(map[string]interface{})
+	query := txs["request"].(map[string]interface{})["queryString"].([]interface{})
+	if len(query) == 0 || query[0].(map[string]interface{})["name"] == "" {
+		t.Errorf("got query string %v", query)
+	}
+	content := txs["response"].(map[string]interface{})["content"].(map[string]interface{})
+	if !strings.HasPrefix(content["mimeType"].(string), "application/json") || !strings.Contains(content["text"].(string), "transactions") {
+		t.Errorf("got content %v", content)
+	}
+	if content["size"] != float64(len(content["text"].(string))) {
+		t.Errorf("got content size %v for %d bytes of text", content["size"], len(content["text"].(string)))
+	}
+
+	failed := entries[3].(map[string]interface{})["response"].(map[string]interface{})
+	if failed["status"] != 0.0 || !strings.Contains(failed["_error"].(string), "connection refused") {
+		t.Errorf("got response %v, want status 0 and the error", failed)
+	}
+}
+
+// roundTripFunc answers requests with a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestHARConcurrentRequests(t *testing.T) {
+	file := filepath.Join(tempDir(t), "traffic.har")
+	har := &harTransport{
+		next: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 200,
+				Header:     http.Header{"Content-Type": {"application/json"}},
+				Body:       ioutil.NopCloser(strings.NewReader(`{"token":"secret"}`)),
+			}, nil
+		}),
+		file: file,
+	}
+
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "https://api.example.com/v1/accounts", nil)
+			if _, err := har.RoundTrip(req); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content harFileContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		t.Fatal(err)
+	}
+	if len(content.Log.Entries) != n {
+		t.Errorf("got %d entries in the file, want %d", len(content.Log.Entries), n)
+	}
+}
+
+// without returns ks without k.
+func without(ks []string, k string) []string {
+	var r []string
+	for _, x := range ks {
+		if x != k {
+			r = append(r, x)
+		}
+	}
+	return r
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.bankrs.com/bosh/mockserver"
+	"github.com/abiosoft/ishell"
+	"github.com/abiosoft/readline"
+)
+
+// harness drives the commands of bosh against a mock api. Commands read the
+// answers to their prompts from a pipe the harness writes scripted input to.
+type harness struct {
+	t     *testing.T
+	log   *syncBuffer
+	shell *ishell.Shell
+	in    *io.PipeWriter
+	out   *syncBuffer
+}
+
+// syncBuffer collects output written by the shell and the readline
+// goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (b *syncBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+// newHarness starts a mock api with the built in fixtures and resets the
+// state of bosh to connect to it.
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+	srv, err := mockserver.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &harness{t: t, log: &syncBuffer{}}
+	srv.Log = h.log
+	ts := httptest.NewTLSServer(srv)
+	t.Cleanup(ts.Close)
+
+	dir, err := ioutil.TempDir("", "bosh-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	os.Setenv("XDG_CONFIG_HOME", dir)
+
+	flag.Set("a", strings.TrimPrefix(ts.URL, "https://"))
+	flag.Set("insecure", "true")
+	if err := connect(); err != nil {
+		t.Fatal(err)
+	}
+	resetContexts()
+	profileEmail, profileAppKey = "", ""
+	lastResult = nil
+	setOutputFormat("")
+	setTemplate("")
+
+	// The shell is not closed since readline does not stop reading its
+	// input safely; closing the pipe ends its goroutines.
+	stdin, w := io.Pipe()
+	h.in, h.out = w, &syncBuffer{}
+	h.shell = addCommands(ishell.NewWithConfig(&readline.Config{
+		Stdin:          stdin,
+		Stdout:         h.out,
+		Stderr:         h.out,
+		FuncIsTerminal: func() bool { return false },
+		FuncMakeRaw:    func() error { return nil },
+		FuncExitRaw:    func() error { return nil },
+	}))
+	h.shell.SetOut(h.out)
+	t.Cleanup(func() { w.Close() })
+	return h
+}
+
+// run runs a command with input as the text typed at its prompts and
+// returns what it printed.
+func (h *harness) run(input string, args ...string) (string, error) {
+	h.t.Helper()
+	start := h.out.Len()
+	output := func() string { return h.out.String()[start:] }
+
+	if input != "" {
+		go io.WriteString(h.in, input)
+	}
+	done := make(chan error, 1)
+	go func() { done <- h.shell.Process(args...) }()
+	select {
+	case err := <-done:
+		return output(), err
+	case <-time.After(10 * time.Second):
+		h.t.Fatalf("%s did not finish, waiting for input? output so far:\n%s", strings.Join(args, " "), output())
+		return "", nil
+	}
+}
+
+// mustRun runs a command and fails the test if it reports an error.
+func (h *harness) mustRun(input string, args ...string) string {
+	h.t.Helper()
+	out, err := h.run(input, args...)
+	if err != nil {
+		h.t.Fatalf("%s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return out
+}
+
+// contains fails the test unless out contains all of the strings.
+func (h *harness) contains(out string, want ...string) {
+	h.t.Helper()
+	for _, s := range want {
+		if !strings.Contains(out, s) {
+			h.t.Errorf("output does not contain %q:\n%s", s, out)
+		}
+	}
+}
+
+// fails runs a command that must fail and checks its exit code and that the
+// error contains msg.
+func (h *harness) fails(code int, msg string, input string, args ...string) {
+	h.t.Helper()
+	out, err := h.run(input, args...)
+	if err == nil {
+		h.t.Errorf("%s succeeded, want error %q:\n%s", strings.Join(args, " "), msg, out)
+		return
+	}
+	if !strings.Contains(err.Error(), msg) {
+		h.t.Errorf("%s: got error %q, want %q", strings.Join(args, " "), err, msg)
+	}
+	if got := exitCode(err); got != code {
+		h.t.Errorf("%s: got exit code %d, want %d for %v", strings.Join(args, " "), got, code, err)
+	}
+}
+
+// loginDev logs in as the developer of the built in fixtures.
+func (h *harness) loginDev() {
+	h.t.Helper()
+	h.mustRun("", "login", "dev@example.com", "secret")
+}
+
+// loginUser logs in as alice, a user of the demo application.
+func (h *harness) loginUser() {
+	h.t.Helper()
+	h.mustRun("", "useapp", "demo-key")
+	h.mustRun("", "loginuser", "alice", "secret")
+}
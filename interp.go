@@ -0,0 +1,765 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/abiosoft/ishell"
+)
+
+// maxCallDepth limits the nesting of function calls in scripts.
+const maxCallDepth = 100
+
+// control is returned by break, continue and return statements to unwind
+// the interpreter to the enclosing loop or function.
+type control int
+
+const (
+	ctlBreak control = iota
+	ctlContinue
+	ctlReturn
+)
+
+func (c control) Error() string {
+	switch c {
+	case ctlBreak:
+		return "break outside of loop"
+	case ctlContinue:
+		return "continue outside of loop"
+	default:
+		return "return outside of function"
+	}
+}
+
+// interpreter runs bosh scripts. Lines that are not interpreter statements
+// are passed to the shell as commands.
+type interpreter struct {
+	shell *ishell.Shell
+	file  string
+	vars  map[string]interface{}
+	funcs map[string]*defStmt
+	args  []string // arguments of the function being called
+	depth int
+
+	// ctlLine is the line of the last break, continue or return, to report
+	// it if the statement is not inside a loop or function.
+	ctlLine int
+
+	// errexit stops the script at the first failing statement. When it is
+	// off, failures are reported to errOut and collected in failures.
+	errexit  bool
+	errOut   io.Writer
+	failures failureList
+}
+
+func newInterpreter(shell *ishell.Shell, file string) *interpreter {
+	return &interpreter{
+		shell:   shell,
+		file:    file,
+		vars:    map[string]interface{}{},
+		funcs:   map[string]*defStmt{},
+		errexit: true,
+		errOut:  os.Stderr,
+	}
+}
+
+// run reads and executes a script until it ends or a statement fails.
+// If errexit is off, run continues after failing statements and returns
+// a failureList describing them once the script has ended.
+func (in *interpreter) run(r io.Reader) error {
+	sr := newScriptReader(r, in.file)
+	for {
+		s, err := sr.readStmt()
+		if err == io.EOF {
+			if len(in.failures) > 0 {
+				return in.failures
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := in.execTop(s); err != nil {
+			return err
+		}
+	}
+}
+
+// execTop executes a statement read from the top level of a script.
+func (in *interpreter) execTop(s stmt) error {
+	err := in.execStmt(s)
+	if _, ok := err.(control); ok {
+		return in.at(in.ctlLine, fmt.Errorf("%v", err))
+	}
+	return err
+}
+
+// execBlock executes a list of statements.
+func (in *interpreter) execBlock(stmts []stmt) error {
+	for _, s := range stmts {
+		if err := in.execStmt(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (in *interpreter) execStmt(s stmt) error {
+	switch s := s.(type) {
+	case *cmdStmt:
+		err := in.exec(s.words)
+		if _, ok := err.(control); ok {
+			in.ctlLine = s.line
+		}
+		return in.at(s.line, err)
+
+	case *ifStmt:
+		ok, err := in.cond(s.cond)
+		if err != nil {
+			return in.at(s.line, err)
+		}
+		if ok {
+			return in.execBlock(s.then)
+		}
+		return in.execBlock(s.els)
+
+	case *forStmt:
+		args, err := in.expandAll(s.items)
+		if err != nil {
+			return in.at(s.line, err)
+		}
+		items := make([]interface{}, len(args))
+		for i := range args {
+			items[i] = args[i]
+		}
+		return in.loop(s.name, items, s.body)
+
+	case *foreachStmt:
+		args, src, err := in.expandCmd(s.cmd)
+		if err != nil {
+			return in.at(s.line, err)
+		}
+		v, err := in.capture(args, src)
+		if err != nil {
+			return in.at(s.line, err)
+		}
+		items, err := listOf(v)
+		if err != nil {
+			return in.at(s.line, fmt.Errorf("cannot iterate over result of %s: %v", args[0], err))
+		}
+		return in.loop(s.name, items, s.body)
+
+	case *defStmt:
+		in.funcs[s.name] = s
+		return nil
+	}
+	return fmt.Errorf("unknown statement %T", s)
+}
+
+// loop binds name to each of the items in turn and executes body.
+func (in *interpreter) loop(name string, items []interface{}, body []stmt) error {
+	for _, item := range items {
+		in.vars[name] = item
+		err := in.execBlock(body)
+		if err == ctlBreak {
+			break
+		}
+		if err != nil && err != ctlContinue {
+			return err
+		}
+	}
+	return nil
+}
+
+// at annotates err with the script position of the statement that caused
+// it. If errexit is off the error is recorded and execution continues.
+func (in *interpreter) at(line int, err error) error {
+	switch err.(type) {
+	case nil, control:
+		return err
+	case *scriptError:
+	default:
+		err = &scriptError{file: in.file, line: line, err: err}
+	}
+	if in.errexit {
+		return err
+	}
+	fmt.Fprintln(in.errOut, err)
+	in.failures = append(in.failures, err)
+	return nil
+}
+
+// exec expands and executes a single statement.
+func (in *interpreter) exec(words []word) error {
+	switch words[0].literal() {
+	case "set":
+		if len(words) < 2 {
+			return fmt.Errorf("usage: set NAME value")
+		}
+		switch words[1].literal() {
+		case "-e":
+			in.errexit = true
+			return nil
+		case "+e":
+			in.errexit = false
+			return nil
+		case "-o":
+			// Settings are kept apart from variables so that a variable
+			// named like a setting cannot change the output.
+			if len(words) < 3 {
+				return validationErrorf("usage: set -o NAME value")
+			}
+			args, err := in.expandAll(words[3:])
+			if err != nil {
+				return err
+			}
+			ok, err := applySetting(words[2].literal(), strings.Join(args, " "))
+			if !ok {
+				return validationErrorf("unknown setting %q", words[2].literal())
+			}
+			return err
+		}
+		name := words[1].literal()
+		args, err := in.expandAll(words[2:])
+		if err != nil {
+			return err
+		}
+		value := strings.Join(args, " ")
+		if _, ok := settings[name]; ok {
+			return validationErrorf("%s is a setting, use set -o %s value to change it", name, name)
+		}
+		if !validName(name) {
+			return fmt.Errorf("invalid variable name %q", name)
+		}
+		in.vars[name] = value
+		return nil
+
+	case "unset":
+		for _, w := range words[1:] {
+			delete(in.vars, w.literal())
+		}
+		return nil
+
+	case "let":
+		if len(words) < 4 || words[2].literal() != "=" {
+			return fmt.Errorf("usage: let NAME = command [args...]")
+		}
+		name := words[1].literal()
+		if !validName(name) {
+			return fmt.Errorf("invalid variable name %q", name)
+		}
+		args, src, err := in.expandCmd(words[3:])
+		if err != nil {
+			return err
+		}
+		v, err := in.capture(args, src)
+		if err != nil {
+			return err
+		}
+		in.vars[name] = v
+		return nil
+
+	case "echo":
+		args, err := in.expandAll(words[1:])
+		if err != nil {
+			return err
+		}
+		in.shell.Println(strings.Join(args, " "))
+		return nil
+
+	case "assert":
+		ok, err := in.cond(words[1:])
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("assertion failed: %s", literals(words[1:]))
+		}
+		return nil
+
+	case "expect":
+		args, err := in.expandAll(words[1:])
+		if err != nil {
+			return err
+		}
+		return expect(args)
+
+	case "break":
+		return ctlBreak
+	case "continue":
+		return ctlContinue
+	case "return":
+		return ctlReturn
+	}
+
+	args, src, err := in.expandCmd(words)
+	if err != nil {
+		return err
+	}
+	return in.command(args, src)
+}
+
+// command runs a script function or a shell command. src holds the source of
+// the arguments, see sourceArgs.
+func (in *interpreter) command(args, src []string) error {
+	if fn, ok := in.funcs[args[0]]; ok {
+		return in.call(fn, args[1:])
+	}
+	lastResult = nil
+	in.shell.Set(sourceKey, src)
+	defer in.shell.Del(sourceKey)
+	return in.shell.Process(args...)
+}
+
+// capture runs a command and returns its normalized result.
+func (in *interpreter) capture(args, src []string) (interface{}, error) {
+	if err := in.command(args, src); err != nil {
+		return nil, err
+	}
+	if lastResult == nil {
+		return nil, fmt.Errorf("%s did not produce a result", args[0])
+	}
+	return normalize(lastResult)
+}
+
+// call runs the body of a function. The arguments are available to the
+// function as $1, $2 and so on.
+func (in *interpreter) call(fn *defStmt, args []string) error {
+	if in.depth >= maxCallDepth {
+		return fmt.Errorf("maximum call depth exceeded in %s", fn.name)
+	}
+	saved := in.args
+	in.args = args
+	in.depth++
+	defer func() {
+		in.args = saved
+		in.depth--
+	}()
+
+	err := in.execBlock(fn.body)
+	switch err {
+	case ctlReturn:
+		return nil
+	case ctlBreak, ctlContinue:
+		return fmt.Errorf("%v in function %s", err, fn.name)
+	}
+	return err
+}
+
+// cond evaluates the condition of an if statement. A condition is one of
+//
+//	not COND          negates a condition
+//	ok CMD [ARGS...]  true if the command succeeds
+//	defined NAME      true if the variable is set
+//	A OP B            compares two values, OP is one of == != =~ !~ < <= > >=
+//	A                 true unless A is empty, false or 0
+func (in *interpreter) cond(words []word) (bool, error) {
+	if len(words) == 0 {
+		return false, fmt.Errorf("missing condition")
+	}
+
+	switch words[0].literal() {
+	case "not":
+		ok, err := in.cond(words[1:])
+		return !ok, err
+
+	case "ok":
+		if len(words) < 2 {
+			return false, fmt.Errorf("usage: ok command [args...]")
+		}
+		args, src, err := in.expandCmd(words[1:])
+		if err != nil {
+			return false, err
+		}
+		return in.command(args, src) == nil, nil
+
+	case "defined":
+		if len(words) != 2 {
+			return false, fmt.Errorf("usage: defined NAME")
+		}
+		_, ok := in.lookup(words[1].literal())
+		return ok, nil
+	}
+
+	args, err := in.expandAll(words)
+	if err != nil {
+		return false, err
+	}
+	switch len(args) {
+	case 1:
+		switch strings.ToLower(args[0]) {
+		case "", "false", "0":
+			return false, nil
+		}
+		return true, nil
+	case 3:
+		return compare(args[0], args[1], args[2])
+	}
+	return false, fmt.Errorf("invalid condition")
+}
+
+func compare(a, op, b string) (bool, error) {
+	switch op {
+	case "==":
+		return a == b, nil
+	case "!=":
+		return a != b, nil
+	case "=~", "!~":
+		re, err := regexp.Compile(b)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(a) == (op == "=~"), nil
+	case "<", "<=", ">", ">=":
+		x, err := strconv.ParseFloat(a, 64)
+		if err != nil {
+			return false, fmt.Errorf("%s: expected a number, got %q", op, a)
+		}
+		y, err := strconv.ParseFloat(b, 64)
+		if err != nil {
+			return false, fmt.Errorf("%s: expected a number, got %q", op, b)
+		}
+		switch op {
+		case "<":
+			return x < y, nil
+		case "<=":
+			return x <= y, nil
+		case ">":
+			return x > y, nil
+		default:
+			return x >= y, nil
+		}
+	}
+	return false, fmt.Errorf("unknown operator %q", op)
+}
+
+// listOf returns the elements of a list. Objects with a single list field,
+// such as the result of the accounts command, yield the elements of that
+// field.
+func listOf(v interface{}) ([]interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		return v, nil
+	case map[string]interface{}:
+		var list []interface{}
+		found := false
+		for _, f := range v {
+			if a, ok := f.([]interface{}); ok {
+				if found {
+					return nil, fmt.Errorf("object has more than one list")
+				}
+				list, found = a, true
+			}
+		}
+		if found {
+			return list, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not a list", typeName(v))
+}
+
+// lookup returns the value of a script variable, falling back to the
+// environment if no such variable is set.
+func (in *interpreter) lookup(name string) (interface{}, bool) {
+	if n, err := strconv.Atoi(name); err == nil {
+		if n < 1 || n > len(in.args) {
+			return nil, false
+		}
+		return in.args[n-1], true
+	}
+	if v, ok := in.vars[name]; ok {
+		return v, true
+	}
+	if v, ok := os.LookupEnv(name); ok {
+		return v, true
+	}
+	return nil, false
+}
+
+func (in *interpreter) expandAll(words []word) ([]string, error) {
+	args := make([]string, 0, len(words))
+	for _, w := range words {
+		arg, err := in.expand(w)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// expandCmd expands the words of a command. Besides the arguments it returns
+// the source of each word with its variables expanded but its quotes kept.
+func (in *interpreter) expandCmd(words []word) (args, src []string, err error) {
+	args, err = in.expandAll(words)
+	if err != nil {
+		return nil, nil, err
+	}
+	src = make([]string, 0, len(words))
+	for _, w := range words {
+		s, err := in.source(w)
+		if err != nil {
+			return nil, nil, err
+		}
+		src = append(src, s)
+	}
+	return args, src, nil
+}
+
+// expand replaces references to variables in a word with their values.
+// Variables may be referenced as $NAME or ${NAME}. The braced form also
+// accepts a path into structured values such as ${acc.accounts[0].id}.
+func (in *interpreter) expand(w word) (string, error) {
+	var buf bytes.Buffer
+	for _, p := range w.parts {
+		if !p.expand {
+			buf.WriteString(p.text)
+			continue
+		}
+
+		s := p.text
+		for {
+			i := strings.IndexByte(s, '$')
+			if i < 0 {
+				buf.WriteString(s)
+				break
+			}
+			buf.WriteString(s[:i])
+			v, n, err := in.ref(s[i+1:])
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(v)
+			s = s[i+1+n:]
+		}
+	}
+	return buf.String(), nil
+}
+
+// source expands the variables in the source of a word like expand does but
+// keeps its quotes and backslashes, so commands with a language of their own
+// such as query and sql see string literals as they were written. See
+// joinSource.
+func (in *interpreter) source(w word) (string, error) {
+	var buf bytes.Buffer
+	s := w.src
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case quote == '\'':
+			if ch == '\'' {
+				quote = 0
+			}
+			buf.WriteByte(ch)
+		case ch == '\\' && i+1 < len(s):
+			i++
+			if s[i] != '\n' {
+				buf.WriteByte(ch)
+				buf.WriteByte(s[i])
+			}
+		case ch == '$':
+			v, n, err := in.ref(s[i+1:])
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(v)
+			i += n
+		case quote == 0 && (ch == '"' || ch == '\''):
+			quote = ch
+			buf.WriteByte(ch)
+		case quote == '"' && ch == '"':
+			quote = 0
+			buf.WriteByte(ch)
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	return buf.String(), nil
+}
+
+// ref expands the variable reference at the start of s, which follows a $.
+// It returns the value and the length of the reference. A $ that does not
+// start a reference stands for itself.
+func (in *interpreter) ref(s string) (string, int, error) {
+	var ref string
+	var n int
+	if s != "" && s[0] == '{' {
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return "", 0, fmt.Errorf("missing closing brace in variable reference")
+		}
+		ref, n = s[1:end], end+1
+	} else {
+		for n < len(s) && isNameChar(s[n]) {
+			n++
+		}
+		if n == 0 {
+			return "$", 0, nil
+		}
+		ref = s[:n]
+	}
+	v, err := in.resolve(ref)
+	if err != nil {
+		return "", 0, err
+	}
+	return formatValue(v), n, nil
+}
+
+// resolve looks up a variable reference which may include a path.
+func (in *interpreter) resolve(ref string) (interface{}, error) {
+	n := 0
+	for n < len(ref) && isNameChar(ref[n]) {
+		n++
+	}
+	name, path := ref[:n], ref[n:]
+	if name == "" {
+		return nil, fmt.Errorf("invalid variable reference ${%s}", ref)
+	}
+	v, ok := in.lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("undefined variable %s", name)
+	}
+	if path == "" {
+		return v, nil
+	}
+	v, err := lookupPath(v, path)
+	if err != nil {
+		return nil, fmt.Errorf("${%s}: %v", ref, err)
+	}
+	return v, nil
+}
+
+// lookupPath follows a path of field names and array indices such as
+// ".accounts[0].id" into a normalized value.
+func lookupPath(v interface{}, path string) (interface{}, error) {
+	for path != "" {
+		switch path[0] {
+		case '.':
+			n := 1
+			for n < len(path) && path[n] != '.' && path[n] != '[' {
+				n++
+			}
+			key := path[1:n]
+			path = path[n:]
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("cannot get field %q of %s", key, typeName(v))
+			}
+			if v, ok = m[key]; !ok {
+				return nil, fmt.Errorf("no field %q", key)
+			}
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("missing closing bracket")
+			}
+			idx, err := strconv.Atoi(path[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %q", path[1:end])
+			}
+			path = path[end+1:]
+			a, ok := v.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("cannot index %s", typeName(v))
+			}
+			if idx < 0 {
+				idx += len(a)
+			}
+			if idx < 0 || idx >= len(a) {
+				return nil, fmt.Errorf("index %d out of range", idx)
+			}
+			v = a[idx]
+		default:
+			return nil, fmt.Errorf("unexpected %q in path", path[0])
+		}
+	}
+	return v, nil
+}
+
+// normalize converts a value into its generic JSON representation made of
+// maps, slices, strings, numbers and booleans.
+func normalize(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var n interface{}
+	if err := dec.Decode(&n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// formatValue formats a normalized value for use as a command argument.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// literals returns the words as written in the script, without expanding
+// variables.
+func literals(words []word) string {
+	s := make([]string, len(words))
+	for i, w := range words {
+		s[i] = w.literal()
+	}
+	return strings.Join(s, " ")
+}
+
+func validName(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isNameChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
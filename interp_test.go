@@ -0,0 +1,288 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// runScript runs a script with the shell of h and returns what it printed.
+func (h *harness) runScript(script string, errexit bool) (string, error) {
+	h.t.Helper()
+	start := h.out.Len()
+	in := newInterpreter(h.shell, "test.bosh")
+	in.errexit = errexit
+	in.errOut = h.out
+	err := in.run(strings.NewReader(script))
+	return h.out.String()[start:], err
+}
+
+func TestVariables(t *testing.T) {
+	h := newHarness(t)
+	os.Setenv("BOSH_TEST_APP", "env app")
+	defer os.Unsetenv("BOSH_TEST_APP")
+
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"set", "set label my test app\necho $label!", "my test app!\n"},
+		{"braces", "set n 4\necho ${n}2 $n", "42 4\n"},
+		{"overwrite", "set a 1\nset a 2\necho $a", "2\n"},
+		{"quotes", "set a x\necho \"[$a]\" '[$a]' \\$a", "[x] [$a] $a\n"},
+		{"environment", "echo $BOSH_TEST_APP", "env app\n"},
+		{"variables shadow the environment", "set BOSH_TEST_APP script\necho $BOSH_TEST_APP", "script\n"},
+		{"unset", "set a 1\nset b 2\nunset a b\nif not defined a\nif not defined b\necho unset\nend\nend", "unset\n"},
+	}
+	for _, tt := range tests {
+		out, err := h.runScript(tt.script, true)
+		if err != nil || out != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.name, out, err, tt.want)
+		}
+	}
+
+	// let captures the primary result of a command, which may be a plain
+	// value or structured data.
+	h.loginDev()
+	out, err := h.runScript(`let app = createapp "my app"
+echo app=$app
+let keys = listappkeys $app
+echo ${keys[0]}
+let key = createappkey $app
+let keys = listappkeys $app
+echo ${keys[1]} == $key
+`, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) < 3 || !strings.HasPrefix(lines[0], "application id ") {
+		t.Fatalf("got %q", out)
+	}
+	id := strings.TrimPrefix(lines[0], "application id ")
+	if !strings.Contains(out, "app="+id+"\n") {
+		t.Errorf("let did not capture the application id %s:\n%s", id, out)
+	}
+	if last := lines[len(lines)-1]; !strings.HasSuffix(last, " == "+strings.Fields(last)[0]) {
+		t.Errorf("got %q, want the new key listed", last)
+	}
+
+	// A failing command leaves the variable alone.
+	out, err = h.runScript("set acc old\nset +e\nlet acc = accounts\necho $acc", true)
+	h.contains(out, "login as a user first", "old\n")
+	if err == nil {
+		t.Error("got no error for the failing command")
+	}
+}
+
+func TestInterpreterControlFlow(t *testing.T) {
+	h := newHarness(t)
+
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"if", "set a 1\nif $a == 1\necho one\nelse\necho other\nend", "one\n"},
+		{"elif", "set a 2\nif $a == 1\necho one\nelif $a == 2\necho two\nelse\necho other\nend", "two\n"},
+		{"else", "set a 3\nif $a == 1\necho one\nelif $a == 2\necho two\nelse\necho other\nend", "other\n"},
+		{"truthiness", "for v in 0 false '' x\nif $v\necho $v\nend\nend", "x\n"},
+		{"not", "if not defined nothing\necho undefined\nend", "undefined\n"},
+		{"for", "for x in a 'b c' d\necho [$x]\nend", "[a]\n[b c]\n[d]\n"},
+		{"continue and break", "for x in a b c d\nif $x == b\ncontinue\nend\nif $x == d\nbreak\nend\necho $x\nend", "a\nc\n"},
+		{"nested break", "for x in 1 2\nfor y in a b\nbreak\nend\necho $x\nend", "1\n2\n"},
+		{"function", "def greet\nif not defined 1\nreturn\nend\necho hello $1\nend\ngreet world\ngreet\ngreet again", "hello world\nhello again\n"},
+		{"function arguments restored", "def inner\necho inner $1\nend\ndef outer\ninner b\necho outer $1\nend\nouter a", "inner b\nouter a\n"},
+		{"recursion", "def count\necho $1\nif $1 < 3\ncount 3\nend\nend\ncount 1", "1\n3\n"},
+		{"set and unset", "set a x y\necho $a\nunset a\nif not defined a\necho gone\nend", "x y\ngone\n"},
+		{"ok", "if ok getaccess 1\necho yes\nelse\necho no\nend", "no\n"},
+	}
+	for _, tt := range tests {
+		out, err := h.runScript(tt.script, true)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if out != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, out, tt.want)
+		}
+	}
+}
+
+// TestScoping checks how nested blocks see variables and functions and
+// where break, continue and return go. Variables are global, only the
+// arguments of a function are restored when it returns.
+func TestScoping(t *testing.T) {
+	h := newHarness(t)
+
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"loop variable stays set", "for x in a b\nend\necho $x", "b\n"},
+		{"loop variable overwrites", "set x old\nfor x in new\nend\necho $x", "new\n"},
+		{"nested loops", "for x in 1 2\nfor y in a b\necho $x$y\nend\nend", "1a\n1b\n2a\n2b\n"},
+		{"break leaves the inner loop", "for x in 1 2\nfor y in a b c\nif $y == b\nbreak\nend\necho $x$y\nend\nend", "1a\n2a\n"},
+		{"continue in the inner loop", "for x in 1 2\nfor y in a b\nif $y == a\ncontinue\nend\necho $x$y\nend\necho $x\nend", "1b\n1\n2b\n2\n"},
+		{"break inside if inside loop", "for x in 1 2 3\nif $x == 2\nif true\nbreak\nend\nend\necho $x\nend", "1\n"},
+		{"return from a loop", "def first\nfor x in a b\nfor y in 1 2\necho $x$y\nreturn\nend\nend\necho not reached\nend\nfirst\necho after", "a1\nafter\n"},
+		{"return from if", "def f\nif $1 == stop\nreturn\nend\necho $1\nend\nf go\nf stop\nf again", "go\nagain\n"},
+		{"functions see globals", "set who world\ndef greet\necho hello $who\nend\ngreet", "hello world\n"},
+		{"functions set globals", "def f\nset result $1\nend\nf done\necho $result", "done\n"},
+		{"loop in function", "def each\nfor x in a b\necho $1$x\nend\nend\neach 1\neach 2", "1a\n1b\n2a\n2b\n"},
+		{"arguments restored", "def inner\necho $1\nend\ndef outer\nfor x in a b\ninner $x\necho outer $1\nend\nend\nouter o", "a\nouter o\nb\nouter o\n"},
+		{"no arguments outside functions", "def f\necho $1\nend\nf x\nif not defined 1\necho none\nend", "x\nnone\n"},
+		{"def in if", "if false\ndef f\necho one\nend\nelse\ndef f\necho two\nend\nend\nf", "two\n"},
+		{"def in function", "def outer\ndef inner\necho inner\nend\nend\nouter\ninner", "inner\n"},
+		{"redefinition", "def f\necho one\nend\nf\ndef f\necho two\nend\nf", "one\ntwo\n"},
+		{"function in loop", "def f\nif $1 == b\nreturn\nend\necho $1\nend\nfor x in a b c\nf $x\nend", "a\nc\n"},
+	}
+	for _, tt := range tests {
+		out, err := h.runScript(tt.script, true)
+		if err != nil || out != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.name, out, err, tt.want)
+		}
+	}
+
+	for script, want := range map[string]string{
+		"def f\nfor x in a\nend\ncontinue\nend\nfor y in b\nf\nend": "test.bosh:7: continue outside of loop in function f",
+		"if true\nfor x in a\nend\nbreak\nend":                      "test.bosh:4: break outside of loop",
+		"for x in a\nif true\nreturn\nend\nend":                     "test.bosh:3: return outside of function",
+	} {
+		if _, err := h.runScript(script, true); err == nil || err.Error() != want {
+			t.Errorf("%q: got error %v, want %q", script, err, want)
+		}
+	}
+}
+
+func TestInterpreterErrors(t *testing.T) {
+	h := newHarness(t)
+
+	tests := []struct {
+		script string
+		err    string
+		code   int
+	}{
+		{"echo a\nbreak", "test.bosh:2: break outside of loop", exitGeneral},
+		{"continue", "test.bosh:1: continue outside of loop", exitGeneral},
+		{"return", "test.bosh:1: return outside of function", exitGeneral},
+		{"def f\nbreak\nend\nfor x in a\nf\nend", "test.bosh:5: break outside of loop in function f", exitGeneral},
+		{"def f\nf\nend\nf", "maximum call depth exceeded in f", exitGeneral},
+		{"echo $nothing", "test.bosh:1: undefined variable nothing", exitGeneral},
+		{"if a <\nend", "test.bosh:1: invalid condition", exitGeneral},
+		{"if a < b\nend", `test.bosh:1: <: expected a number, got "a"`, exitGeneral},
+		{"if a =~ (\nend", "test.bosh:1: error parsing regexp", exitGeneral},
+		{"set 1a x", `test.bosh:1: invalid variable name "1a"`, exitGeneral},
+		{"let a accounts", "test.bosh:1: usage: let NAME = command", exitGeneral},
+		{"let a = useapp demo-key", "test.bosh:1: useapp did not produce a result", exitGeneral},
+		{"foreach x in useapp demo-key\nend", "test.bosh:1: useapp did not produce a result", exitGeneral},
+		{"let a = echo x", "test.bosh:1: incorrect input", exitGeneral},
+		{"echo before\ngetaccess 1\necho after", "test.bosh:2: login as a user first", exitAuth},
+	}
+	for _, tt := range tests {
+		out, err := h.runScript(tt.script, true)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: got error %v, want %q", tt.script, err, tt.err)
+			continue
+		}
+		if code := exitCode(err); code != tt.code {
+			t.Errorf("%q: got exit code %d, want %d", tt.script, code, tt.code)
+		}
+		if strings.Contains(out, "after") {
+			t.Errorf("%q: script continued after the error:\n%s", tt.script, out)
+		}
+	}
+}
+
+func TestInterpreterContinueOnError(t *testing.T) {
+	h := newHarness(t)
+
+	out, err := h.runScript("getaccess 1\necho after\nset -e\necho $nothing\necho not reached", false)
+	h.contains(out, "test.bosh:1: login as a user first\nafter\n")
+	if strings.Contains(out, "not reached") {
+		t.Errorf("set -e did not stop the script:\n%s", out)
+	}
+	if err == nil || !strings.Contains(err.Error(), "undefined variable nothing") {
+		t.Errorf("got %v, want the error of the statement after set -e", err)
+	}
+
+	out, err = h.runScript("set +e\ngetaccess 1\nwait\necho done", true)
+	h.contains(out, "done")
+	failures, ok := err.(failureList)
+	if !ok || len(failures) != 2 {
+		t.Fatalf("got %#v, want 2 failures", err)
+	}
+	want := "2 commands failed:\n  test.bosh:2: login as a user first\n  test.bosh:3: login as a user first"
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+	if code := exitCode(err); code != exitAuth {
+		t.Errorf("got exit code %d, want the code of the first failure %d", code, exitAuth)
+	}
+}
+
+func TestInterpreterResults(t *testing.T) {
+	h := newHarness(t)
+	h.loginUser()
+
+	out, err := h.runScript(`let acc = accounts
+echo ${acc.accounts[0].name} has ${acc.accounts[0].balance}
+foreach a in accounts
+	if ${a.type} == savings
+		continue
+	end
+	echo $a.name: ${a.name}
+end
+foreach n in listusers
+end`, true)
+	if err == nil || !strings.Contains(err.Error(), "test.bosh:9: ") {
+		t.Errorf("got %v, want an error for listusers on line 9", err)
+	}
+	h.contains(out, "Girokonto has 1024.50\n", `"type":"current"}.name: Girokonto`+"\n")
+	if strings.Contains(out, ".name: Tagesgeld") {
+		t.Errorf("continue did not skip the savings account:\n%s", out)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, op, b string
+		want     bool
+		err      string
+	}{
+		{a: "x", op: "==", b: "x", want: true},
+		{a: "1.0", op: "==", b: "1", want: false},
+		{a: "x", op: "!=", b: "y", want: true},
+		{a: "x", op: "!=", b: "x", want: false},
+		{a: "DE89370400440532013000", op: "=~", b: "^DE[0-9]{20}$", want: true},
+		{a: "GB29", op: "=~", b: "^DE", want: false},
+		{a: "GB29", op: "!~", b: "^DE", want: true},
+		{a: "DE89", op: "!~", b: "^DE", want: false},
+		{a: "9", op: "<", b: "10", want: true},
+		{a: "10", op: "<", b: "10", want: false},
+		{a: "10", op: "<=", b: "10", want: true},
+		{a: "-850.00", op: "<=", b: "-851", want: false},
+		{a: "10.5", op: ">", b: "10", want: true},
+		{a: "10", op: ">", b: "10", want: false},
+		{a: "10", op: ">=", b: "10.0", want: true},
+		{a: "9.99", op: ">=", b: "10", want: false},
+		{a: "ten", op: "<", b: "10", err: `<: expected a number, got "ten"`},
+		{a: "10", op: ">=", b: "", err: `>=: expected a number, got ""`},
+		{a: "x", op: "=~", b: "(", err: "error parsing regexp"},
+		{a: "x", op: "<>", b: "y", err: `unknown operator "<>"`},
+	}
+	for _, tt := range tests {
+		got, err := compare(tt.a, tt.op, tt.b)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("compare(%q, %q, %q): got %v, %v, want error %q", tt.a, tt.op, tt.b, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("compare(%q, %q, %q) = %v, %v, want %v", tt.a, tt.op, tt.b, got, err, tt.want)
+		}
+	}
+}
@@ -2,9 +2,6 @@
 package main
 
 import (
-	"bufio"
-	"crypto/tls"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -31,34 +28,231 @@ type state struct {
 	userClient *bosgo.UserClient
 }
 
-var session state
+// session is the context in use, see contexts.
+var session = &state{}
+
+// httpClient and clientOptions are used to create all api clients.
+var (
+	httpClient    = &http.Client{Transport: tracer}
+	clientOptions []bosgo.ClientOption
+)
+
+// lastResult holds the primary result of the last command that was run, such
+// as the id of a newly created application. Scripts use it to capture the
+// output of a command in a variable.
+var lastResult interface{}
+
 var addr = flag.String("a", "api.sandbox.bankrs.com", "address of api to connect to")
+var environment = flag.String("environment", "", "environment of the api, defaults to sandbox for addresses other than api.bankrs.com and api.sandbox.bankrs.com")
 var input = flag.String("i", "", "filename of document to read commands from")
 var insecure = flag.Bool("insecure", false, "set to disable TLS verification, e.g. for development systems with self signed certificates")
+var devEmail = flag.String("email", "", "email of developer account to login with, defaults to $BOSH_EMAIL")
+var devPassword = flag.String("password", "", "password of developer account to login with, defaults to $BOSH_PASSWORD")
+var appKey = flag.String("app", "", "application key to use, defaults to $BOSH_APP_KEY")
+var userName = flag.String("user", "", "name of user to login as, defaults to $BOSH_USER")
+var userPassword = flag.String("user-password", "", "password of user to login as, defaults to $BOSH_USER_PASSWORD")
+var continueOnError = flag.Bool("continue-on-error", false, "keep running a script after a command fails and report all failures at the end")
 
 func main() {
 	flag.Parse()
 
-	var httpClient = http.DefaultClient
+	if flag.Arg(0) == "mock-server" {
+		os.Exit(runMockServer(flag.Args()[1:]))
+	}
+
+	if err := startProfile(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCode(err))
+	}
+	if err := startCassette(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCode(err))
+	}
+	if err := startHAR(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCode(err))
+	}
+	if err := connect(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCode(err))
+	}
+	tracer.setEnabled(*traceFlag)
+
+	if err := setOutputFormat(*outputFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCode(err))
+	}
+	if err := setTemplate(*templateFlag); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCode(err))
+	}
 
-	if *insecure {
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	shell := newShell()
+	shell.SetOut(os.Stdout)
+
+	if flag.Arg(0) == "test" {
+		os.Exit(runTests(shell, flag.Args()[1:]))
+	}
+
+	if err := startSession(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCode(err))
+	}
+
+	// Run a single command given on the command line
+	if flag.NArg() > 0 {
+		if err := shell.Process(flag.Args()...); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitCode(err))
+		}
+		return
+	}
+
+	// Read commands from the file given with -i, or check for commands
+	// piped from stdin
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(exitGeneral)
 		}
-		httpClient = &http.Client{Transport: tr}
+		defer f.Close()
+		readCommands(f, *input, shell)
+		return
+	} else if !isatty.IsTerminal(os.Stdin.Fd()) {
+		readCommands(os.Stdin, "<stdin>", shell)
+		return
+	}
+	shell.SetPrompt(prompt())
+
+	shell.Run()
+	saveOnExit()
+}
 
+// connect creates the api client for the address, environment and TLS
+// settings given by flags or the profile.
+func connect() error {
+	tlsCfg, err := tlsConfig()
+	if err != nil {
+		return err
+	}
+	tracer.next = http.DefaultTransport
+	if tlsCfg != nil {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = tlsCfg
+		tracer.next = tr
+	}
+	if recorder != nil {
+		recorder.next = tracer.next
+		tracer.next = recorder
+	}
+	if archiver != nil {
+		archiver.next = tracer.next
+		tracer.next = archiver
 	}
+	httpClient = &http.Client{Transport: tracer}
 
-	opts := []bosgo.ClientOption{
+	clientOptions = []bosgo.ClientOption{
 		bosgo.UserAgent("bosh"),
 	}
-	if *addr != "api.bankrs.com" && *addr != "api.sandbox.bankrs.com" {
-		opts = append(opts, bosgo.Environment("sandbox"))
+	env := *environment
+	if env == "" && *addr != "api.bankrs.com" && *addr != "api.sandbox.bankrs.com" {
+		env = "sandbox"
+	}
+	if env != "" {
+		clientOptions = append(clientOptions, bosgo.Environment(env))
 	}
 
-	session.client = bosgo.New(httpClient, *addr, opts...)
+	session.client = bosgo.New(httpClient, *addr, clientOptions...)
+	return nil
+}
 
-	shell := ishell.New()
+// startSession logs in using the credentials given by flags or environment
+// variables so that commands can be run without logging in first. Without
+// any credentials the session that was saved last is restored. The
+// application key of the profile is used unless a flag or the environment
+// gives one.
+func startSession() error {
+	email := flagOrEnv(*devEmail, "BOSH_EMAIL")
+	key := flagOrEnv(*appKey, "BOSH_APP_KEY")
+	name := flagOrEnv(*userName, "BOSH_USER")
+	if email == "" && key == "" && name == "" {
+		if !*noSession {
+			restoreLastSession()
+		}
+		if session.empty() && profileAppKey != "" {
+			session.appClient = session.client.WithApplicationKey(profileAppKey)
+			session.applicationKey = profileAppKey
+		}
+		return nil
+	}
+	if key == "" {
+		key = profileAppKey
+	}
+
+	if email != "" {
+		password := flagOrEnv(*devPassword, "BOSH_PASSWORD")
+		devClient, err := session.client.Login(email, password).Send()
+		if err != nil {
+			return &cmdError{kind: classify(err), err: fmt.Errorf("login %s: %v", email, err)}
+		}
+		session.devEmail = email
+		session.devClient = devClient
+	}
+
+	if key != "" {
+		session.appClient = session.client.WithApplicationKey(key)
+		session.applicationKey = key
+	}
+
+	if name != "" {
+		if session.appClient == nil {
+			return errNoApplication
+		}
+		password := flagOrEnv(*userPassword, "BOSH_USER_PASSWORD")
+		userClient, err := session.appClient.Users.Login(name, password).Send()
+		if err != nil {
+			return &cmdError{kind: classify(err), err: fmt.Errorf("login user %s: %v", name, err)}
+		}
+		session.userClient = userClient
+		session.userName = name
+	}
+
+	return nil
+}
+
+func flagOrEnv(value string, env string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(env)
+}
+
+// prompt returns the shell prompt for the session.
+func (s *state) prompt() string {
+	switch {
+	case s.userName != "":
+		return s.applicationKey + "/" + s.userName + "> "
+	case s.applicationKey != "":
+		return s.applicationKey + "> "
+	case s.devEmail != "":
+		return s.devEmail + "> "
+	}
+	return "> "
+}
+
+// newShell creates a shell with all bosh commands registered.
+func newShell() *ishell.Shell {
+	return addCommands(ishell.New())
+}
+
+// addCommands registers the commands of bosh with a shell.
+func addCommands(shell *ishell.Shell) *ishell.Shell {
+	shell.AddCmd(&ishell.Cmd{
+		Name: "set",
+		Help: "change a setting, e.g. set output table",
+		Func: setSetting,
+	})
 
 	shell.AddCmd(&ishell.Cmd{
 		Name: "createdev",
@@ -102,11 +296,22 @@ func main() {
 		Func: resetPassword,
 	})
 
-	shell.AddCmd(&ishell.Cmd{
+	profileCmd := &ishell.Cmd{
 		Name: "profile",
 		Help: "show the developer's profile",
 		Func: profileDeveloper,
+	}
+	profileCmd.AddCmd(&ishell.Cmd{
+		Name: "use",
+		Help: "switch to a profile of the config file",
+		Func: useProfile,
 	})
+	profileCmd.AddCmd(&ishell.Cmd{
+		Name: "list",
+		Help: "list the profiles of the config file",
+		Func: listProfiles,
+	})
+	shell.AddCmd(profileCmd)
 
 	shell.AddCmd(&ishell.Cmd{
 		Name: "setprofile",
@@ -240,6 +445,12 @@ func main() {
 		Func: job,
 	})
 
+	shell.AddCmd(&ishell.Cmd{
+		Name: "wait",
+		Help: "wait for jobs to finish, showing their progress",
+		Func: waitForJobs,
+	})
+
 	shell.AddCmd(&ishell.Cmd{
 		Name: "answer",
 		Help: "provide a challenge answer for a job",
@@ -258,6 +469,12 @@ func main() {
 		Func: accounts,
 	})
 
+	shell.AddCmd(&ishell.Cmd{
+		Name: "overview",
+		Help: "show the balances of all accounts with totals per currency",
+		Func: overview,
+	})
+
 	shell.AddCmd(&ishell.Cmd{
 		Name: "getaccount",
 		Help: "get details of a single account",
@@ -270,6 +487,30 @@ func main() {
 		Func: transactions,
 	})
 
+	shell.AddCmd(&ishell.Cmd{
+		Name: "exporttransactions",
+		Help: "export transactions as a csv, ofx, qif, camt053 or mt940 statement",
+		Func: exportTransactions,
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "analyze",
+		Help: "sum transactions by month, counterparty, category or account",
+		Func: analyze,
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "sync",
+		Help: "store the accounts and transactions of the user in a local SQLite database",
+		Func: syncCache,
+	})
+
+	shell.AddCmd(&ishell.Cmd{
+		Name: "sql",
+		Help: "query the local database filled by sync",
+		Func: sqlQuery,
+	})
+
 	shell.AddCmd(&ishell.Cmd{
 		Name: "gettransaction",
 		Help: "get details of a single transaction",
@@ -384,42 +625,82 @@ func main() {
 		Func: listCredentialProviders,
 	})
 
-	// Check for commands piped from stdin
-	if !isatty.IsTerminal(os.Stdin.Fd()) {
-		readCommands(os.Stdin, shell)
-		return
-	} else if *input != "" {
-		f, err := os.Open(*input)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
-		}
-		defer f.Close()
-		readCommands(f, shell)
-		return
+	sessionCmd := &ishell.Cmd{
+		Name: "session",
+		Help: "save and restore sessions, see help session",
+		Func: listSessions,
 	}
-	shell.SetPrompt("> ")
+	sessionCmd.AddCmd(&ishell.Cmd{
+		Name: "save",
+		Help: "save the current session under a name, the current name by default",
+		Func: saveSessionCmd,
+	})
+	sessionCmd.AddCmd(&ishell.Cmd{
+		Name: "load",
+		Help: "restore a saved session, the last saved one by default",
+		Func: loadSessionCmd,
+	})
+	sessionCmd.AddCmd(&ishell.Cmd{
+		Name: "list",
+		Help: "list saved sessions",
+		Func: listSessions,
+	})
+	sessionCmd.AddCmd(&ishell.Cmd{
+		Name: "drop",
+		Help: "delete a saved session",
+		Func: dropSession,
+	})
+	shell.AddCmd(sessionCmd)
 
-	shell.Run()
+	shell.AddCmd(&ishell.Cmd{
+		Name: "trace",
+		Help: "print every http request and response: trace on|off",
+		Func: setTrace,
+	})
+
+	ctxCmd := &ishell.Cmd{
+		Name: "ctx",
+		Help: "work with several sessions side by side, see help ctx",
+		Func: listContexts,
+	}
+	ctxCmd.AddCmd(&ishell.Cmd{
+		Name: "new",
+		Help: "create a new empty context and switch to it",
+		Func: createContext,
+	})
+	ctxCmd.AddCmd(&ishell.Cmd{
+		Name: "use",
+		Help: "switch to another context",
+		Func: useContext,
+	})
+	ctxCmd.AddCmd(&ishell.Cmd{
+		Name: "list",
+		Help: "list contexts, the current one is marked with *",
+		Func: listContexts,
+	})
+	ctxCmd.AddCmd(&ishell.Cmd{
+		Name: "run",
+		Help: "run a command in another context without switching, e.g. ctx run bob accounts",
+		Func: runInContext,
+	})
+	ctxCmd.AddCmd(&ishell.Cmd{
+		Name: "drop",
+		Help: "delete a context",
+		Func: dropContext,
+	})
+	shell.AddCmd(ctxCmd)
+
+	withPipelines(shell.Cmds())
+	return shell
 }
 
-func readCommands(r io.Reader, shell *ishell.Shell) {
+func readCommands(r io.Reader, name string, shell *ishell.Shell) {
 	shell.SetOut(os.Stdout)
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		text := scanner.Text()
-		if strings.HasPrefix(text, "#") {
-			continue
-		}
-		args := strings.Split(text, " ")
-		if err := shell.Process(args...); err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
-		}
-	}
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintln(os.Stderr, "reading standard input:", err)
-		os.Exit(1)
+	in := newInterpreter(shell, name)
+	in.errexit = !*continueOnError
+	if err := in.run(r); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCode(err))
 	}
 }
 
@@ -440,6 +721,9 @@ func createDeveloper(c *ishell.Context) {
 }
 
 func loginDeveloper(c *ishell.Context) {
+	if len(c.Args) == 0 && profileEmail != "" {
+		c.Args = []string{profileEmail}
+	}
 	email, password, err := readCredentials("Email", c)
 	if err != nil {
 		c.Err(err)
@@ -453,7 +737,7 @@ func loginDeveloper(c *ishell.Context) {
 	}
 	session.devEmail = email
 	session.devClient = devClient
-	c.SetPrompt(email + "> ")
+	c.SetPrompt(prompt())
 }
 
 func lostPassword(c *ishell.Context) {
@@ -479,7 +763,7 @@ func resetPassword(c *ishell.Context) {
 
 func logoutDeveloper(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 
@@ -490,12 +774,12 @@ func logoutDeveloper(c *ishell.Context) {
 	}
 	session.devEmail = ""
 	session.devClient = nil
-	c.SetPrompt("> ")
+	c.SetPrompt(prompt())
 }
 
 func deleteDeveloper(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 
@@ -506,12 +790,12 @@ func deleteDeveloper(c *ishell.Context) {
 	}
 	session.devEmail = ""
 	session.devClient = nil
-	c.SetPrompt("> ")
+	c.SetPrompt(prompt())
 }
 
 func profileDeveloper(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 
@@ -520,13 +804,18 @@ func profileDeveloper(c *ishell.Context) {
 		c.Err(err)
 		return
 	}
+	if structuredOutput(c) {
+		printResult(c, profile)
+		return
+	}
+	lastResult = profile
 	c.Printf("Company: %s\n", profile.Company)
 	c.Printf("Has production access: %v\n", profile.HasProductionAccess)
 }
 
 func setProfileDeveloper(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 
@@ -543,7 +832,7 @@ func setProfileDeveloper(c *ishell.Context) {
 
 func changePasswordDeveloper(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 
@@ -576,7 +865,7 @@ func changePasswordDeveloper(c *ishell.Context) {
 
 func createApplication(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 
@@ -591,12 +880,13 @@ func createApplication(c *ishell.Context) {
 		c.Err(err)
 		return
 	}
+	lastResult = appID
 	c.Println("application id", appID)
 }
 
 func listApplications(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 
@@ -606,6 +896,11 @@ func listApplications(c *ishell.Context) {
 		return
 	}
 
+	if structuredOutput(c) {
+		printResult(c, list.Applications)
+		return
+	}
+	lastResult = list.Applications
 	for _, app := range list.Applications {
 		c.Printf("%s (%s)\n", app.Label, app.ApplicationID)
 	}
@@ -613,7 +908,7 @@ func listApplications(c *ishell.Context) {
 
 func updateApplication(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 
@@ -629,7 +924,7 @@ func updateApplication(c *ishell.Context) {
 
 func deleteApplication(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 
@@ -651,12 +946,12 @@ func useApplication(c *ishell.Context) {
 
 	session.appClient = session.client.WithApplicationKey(appKey)
 	session.applicationKey = appKey
-	c.SetPrompt(appKey + "> ")
+	c.SetPrompt(prompt())
 }
 
 func listUsers(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 
@@ -667,6 +962,11 @@ func listUsers(c *ishell.Context) {
 		return
 	}
 
+	if structuredOutput(c) {
+		printResult(c, list.Users)
+		return
+	}
+	lastResult = list.Users
 	for _, user := range list.Users {
 		c.Printf("* %s\n", user)
 	}
@@ -674,7 +974,7 @@ func listUsers(c *ishell.Context) {
 
 func stats(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 
@@ -683,12 +983,12 @@ func stats(c *ishell.Context) {
 	if len(c.Args) > 2 {
 		var err error
 		if fromDate, err = time.Parse("2006-01-02", c.Args[1]); err != nil {
-			c.Err(fmt.Errorf("expected a date in yyyy-mm-dd format: %v", err))
+			c.Err(validationErrorf("expected a date in yyyy-mm-dd format: %v", err))
 			return
 		}
 
 		if toDate, err = time.Parse("2006-01-02", c.Args[2]); err != nil {
-			c.Err(fmt.Errorf("expected a date in yyyy-mm-dd format: %v", err))
+			c.Err(validationErrorf("expected a date in yyyy-mm-dd format: %v", err))
 			return
 		}
 	}
@@ -705,7 +1005,7 @@ func stats(c *ishell.Context) {
 			c.Err(err)
 			return
 		}
-		dumpJSON(c, stats)
+		printResult(c, stats)
 	case "providers":
 		req := session.devClient.Stats.Providers()
 		if !fromDate.IsZero() && !toDate.IsZero() {
@@ -717,7 +1017,7 @@ func stats(c *ishell.Context) {
 			c.Err(err)
 			return
 		}
-		dumpJSON(c, stats)
+		printResult(c, stats)
 	case "transfers":
 		req := session.devClient.Stats.Transfers()
 		if !fromDate.IsZero() && !toDate.IsZero() {
@@ -729,7 +1029,7 @@ func stats(c *ishell.Context) {
 			c.Err(err)
 			return
 		}
-		dumpJSON(c, stats)
+		printResult(c, stats)
 	case "users":
 		req := session.devClient.Stats.Users()
 		if !fromDate.IsZero() && !toDate.IsZero() {
@@ -741,7 +1041,7 @@ func stats(c *ishell.Context) {
 			c.Err(err)
 			return
 		}
-		dumpJSON(c, stats)
+		printResult(c, stats)
 	case "requests":
 		req := session.devClient.Stats.Requests()
 		if !fromDate.IsZero() && !toDate.IsZero() {
@@ -753,15 +1053,15 @@ func stats(c *ishell.Context) {
 			c.Err(err)
 			return
 		}
-		dumpJSON(c, stats)
+		printResult(c, stats)
 	default:
-		c.Err(fmt.Errorf("unknown stat type"))
+		c.Err(validationErrorf("unknown stat type"))
 	}
 }
 
 func createUser(c *ishell.Context) {
 	if session.appClient == nil {
-		c.Err(fmt.Errorf("use an application id first"))
+		c.Err(errNoApplication)
 		return
 	}
 
@@ -776,12 +1076,12 @@ func createUser(c *ishell.Context) {
 
 	session.userClient = userClient
 	session.userName = userName
-	c.SetPrompt(session.applicationKey + "/" + session.userName + "> ")
+	c.SetPrompt(prompt())
 }
 
 func loginUser(c *ishell.Context) {
 	if session.appClient == nil {
-		c.Err(fmt.Errorf("use an application id first"))
+		c.Err(errNoApplication)
 		return
 	}
 
@@ -796,12 +1096,12 @@ func loginUser(c *ishell.Context) {
 
 	session.userClient = userClient
 	session.userName = userName
-	c.SetPrompt(session.applicationKey + "/" + session.userName + "> ")
+	c.SetPrompt(prompt())
 }
 
 func logoutUser(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("not logged in as a user"))
+		c.Err(errNotLoggedIn)
 		return
 	}
 	err := session.userClient.Logout().Send()
@@ -812,12 +1112,12 @@ func logoutUser(c *ishell.Context) {
 
 	session.userClient = nil
 	session.userName = ""
-	c.SetPrompt(session.applicationKey + "> ")
+	c.SetPrompt(prompt())
 }
 
 func deleteUser(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("not logged in as a user"))
+		c.Err(errNotLoggedIn)
 		return
 	}
 	password := readArgPassword(0, "Password", c)
@@ -827,15 +1127,16 @@ func deleteUser(c *ishell.Context) {
 		return
 	}
 
+	lastResult = delUser.DeletedUserID
 	c.Printf("Deleted user id %s\n", delUser.DeletedUserID)
 	session.userClient = nil
 	session.userName = ""
-	c.SetPrompt(session.applicationKey + "> ")
+	c.SetPrompt(prompt())
 }
 
 func searchProviders(c *ishell.Context) {
 	if session.appClient == nil {
-		c.Err(fmt.Errorf("use an application id first"))
+		c.Err(errNoApplication)
 		return
 	}
 
@@ -847,12 +1148,12 @@ func searchProviders(c *ishell.Context) {
 		return
 	}
 
-	dumpJSON(c, list)
+	printResult(c, list)
 }
 
 func provider(c *ishell.Context) {
 	if session.appClient == nil {
-		c.Err(fmt.Errorf("use an application id first"))
+		c.Err(errNoApplication)
 		return
 	}
 
@@ -864,12 +1165,12 @@ func provider(c *ishell.Context) {
 		return
 	}
 
-	dumpJSON(c, list)
+	printResult(c, list)
 }
 
 func accesses(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
 		return
 	}
 
@@ -879,17 +1180,34 @@ func accesses(c *ishell.Context) {
 		return
 	}
 
-	dumpJSON(c, list)
+	printResult(c, list)
 }
 
 func addAccess(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
+		return
+	}
+	if session.appClient == nil {
+		c.Err(errNoApplication)
+		return
+	}
+
+	var wait waitOptions
+	fs := newCmdFlags("addaccess")
+	wait.addFlags(fs, true)
+	if err := parseCmdFlags(c, fs); err != nil {
+		c.Err(err)
 		return
 	}
 
 	providerID := readArg(0, "Provider ID", c)
-	answers := promptChallengeAnswers(c)
+	provider, err := session.appClient.Providers.Get(providerID).Send()
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	answers := promptProviderChallenges(c, provider)
 
 	req := session.userClient.Accesses.Add(providerID)
 	for _, answer := range answers {
@@ -902,19 +1220,21 @@ func addAccess(c *ishell.Context) {
 		return
 	}
 
+	lastResult = job.URI
 	c.Println("Job URI:", job.URI)
+	waitForStartedJobs(c, []string{job.URI}, wait)
 }
 
 func deleteAccess(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
 		return
 	}
 
 	idstr := readArg(0, "Access ID", c)
 	id, err := strconv.ParseInt(idstr, 10, 64)
 	if err != nil {
-		c.Err(err)
+		c.Err(validationError(err))
 		return
 	}
 
@@ -924,19 +1244,20 @@ func deleteAccess(c *ishell.Context) {
 		return
 	}
 
+	lastResult = deleted
 	c.Println("Deleted ID:", deleted)
 }
 
 func getAccess(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
 		return
 	}
 
 	idstr := readArg(0, "Access ID", c)
 	id, err := strconv.ParseInt(idstr, 10, 64)
 	if err != nil {
-		c.Err(err)
+		c.Err(validationError(err))
 		return
 	}
 
@@ -946,47 +1267,69 @@ func getAccess(c *ishell.Context) {
 		return
 	}
 
-	dumpJSON(c, access)
+	printResult(c, access)
 }
 
 func updateAccess(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
+		return
+	}
+	if session.appClient == nil {
+		c.Err(errNoApplication)
 		return
 	}
 
 	idstr := readArg(0, "Access ID", c)
 	id, err := strconv.ParseInt(idstr, 10, 64)
+	if err != nil {
+		c.Err(validationError(err))
+		return
+	}
+	access, err := session.userClient.Accesses.Get(id).Send()
 	if err != nil {
 		c.Err(err)
 		return
 	}
-	answers := promptChallengeAnswers(c)
+	provider, err := session.appClient.Providers.Get(access.ProviderID).Send()
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	answers := promptProviderChallenges(c, provider)
 
 	req := session.userClient.Accesses.Update(id)
 	for _, answer := range answers {
 		req.ChallengeAnswer(answer)
 	}
 
-	access, err := req.Send()
+	access, err = req.Send()
 	if err != nil {
 		c.Err(err)
 		return
 	}
 
-	dumpJSON(c, access)
+	printResult(c, access)
 }
 
 func refreshAccess(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
+		return
+	}
+
+	var wait waitOptions
+	fs := newCmdFlags("refreshaccess")
+	wait.addFlags(fs, true)
+	if err := parseCmdFlags(c, fs); err != nil {
+		c.Err(err)
 		return
 	}
 
 	idstr := readArg(0, "Access ID", c)
 	id, err := strconv.ParseInt(idstr, 10, 64)
 	if err != nil {
-		c.Err(err)
+		c.Err(validationError(err))
 		return
 	}
 
@@ -998,12 +1341,22 @@ func refreshAccess(c *ishell.Context) {
 		return
 	}
 
+	lastResult = job.URI
 	c.Println("Job URI:", job.URI)
+	waitForStartedJobs(c, []string{job.URI}, wait)
 }
 
 func refreshAllAccesses(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
+		return
+	}
+
+	var wait waitOptions
+	fs := newCmdFlags("refreshall")
+	wait.addFlags(fs, true)
+	if err := parseCmdFlags(c, fs); err != nil {
+		c.Err(err)
 		return
 	}
 
@@ -1013,15 +1366,19 @@ func refreshAllAccesses(c *ishell.Context) {
 		return
 	}
 
+	uris := make([]string, 0, len(jobs))
 	c.Println("Job URIs:")
 	for _, job := range jobs {
+		uris = append(uris, job.URI)
 		c.Println(" * ", job.URI)
 	}
+	lastResult = uris
+	waitForStartedJobs(c, uris, wait)
 }
 
 func job(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
 		return
 	}
 	uri := readArg(0, "Job URI", c)
@@ -1032,32 +1389,12 @@ func job(c *ishell.Context) {
 		return
 	}
 
-	dumpJSON(c, status)
-}
-
-func answer(c *ishell.Context) {
-	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
-		return
-	}
-	uri := readArg(0, "Job URI", c)
-	answers := promptChallengeAnswers(c)
-
-	req := session.userClient.Jobs.Answer(uri)
-	for _, answer := range answers {
-		req.ChallengeAnswer(answer)
-	}
-
-	err := req.Send()
-	if err != nil {
-		c.Err(err)
-		return
-	}
+	printResult(c, status)
 }
 
 func cancelJob(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
 		return
 	}
 	uri := readArg(0, "Job URI", c)
@@ -1071,7 +1408,7 @@ func cancelJob(c *ishell.Context) {
 
 func accounts(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
 		return
 	}
 
@@ -1081,12 +1418,12 @@ func accounts(c *ishell.Context) {
 		return
 	}
 
-	dumpJSON(c, list)
+	printResult(c, list)
 }
 
 func getAccount(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
 		return
 	}
 
@@ -1098,27 +1435,12 @@ func getAccount(c *ishell.Context) {
 		return
 	}
 
-	dumpJSON(c, account)
-}
-
-func transactions(c *ishell.Context) {
-	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
-		return
-	}
-
-	list, err := session.userClient.Transactions.List().Send()
-	if err != nil {
-		c.Err(err)
-		return
-	}
-
-	dumpJSON(c, list)
+	printResult(c, account)
 }
 
 func getTransaction(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
 		return
 	}
 
@@ -1130,12 +1452,12 @@ func getTransaction(c *ishell.Context) {
 		return
 	}
 
-	dumpJSON(c, tx)
+	printResult(c, tx)
 }
 
 func scheduledTransactions(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
 		return
 	}
 
@@ -1145,12 +1467,12 @@ func scheduledTransactions(c *ishell.Context) {
 		return
 	}
 
-	dumpJSON(c, list)
+	printResult(c, list)
 }
 
 func getScheduledTransaction(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
 		return
 	}
 
@@ -1162,12 +1484,12 @@ func getScheduledTransaction(c *ishell.Context) {
 		return
 	}
 
-	dumpJSON(c, tx)
+	printResult(c, tx)
 }
 
 func repeatedTransactions(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
 		return
 	}
 
@@ -1177,12 +1499,12 @@ func repeatedTransactions(c *ishell.Context) {
 		return
 	}
 
-	dumpJSON(c, list)
+	printResult(c, list)
 }
 
 func getRepeatedTransaction(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
 		return
 	}
 
@@ -1194,12 +1516,12 @@ func getRepeatedTransaction(c *ishell.Context) {
 		return
 	}
 
-	dumpJSON(c, tx)
+	printResult(c, tx)
 }
 
 func deleteRecurringTransfer(c *ishell.Context) {
 	if session.userClient == nil {
-		c.Err(fmt.Errorf("login as a user first"))
+		c.Err(errNoUser)
 		return
 	}
 
@@ -1217,16 +1539,7 @@ func deleteRecurringTransfer(c *ishell.Context) {
 		return
 	}
 
-	dumpJSON(c, tx)
-}
-
-func dumpJSON(c *ishell.Context, v interface{}) {
-	data, err := json.MarshalIndent(v, "", "  ")
-	if err != nil {
-		c.Err(err)
-		return
-	}
-	c.Println(string(data))
+	printResult(c, tx)
 }
 
 func readCredentials(userPrompt string, c *ishell.Context) (string, string, error) {
@@ -1349,28 +1662,6 @@ func promptBool(c *ishell.Context, prompt string) bool {
 	}
 }
 
-func promptChallengeAnswers(c *ishell.Context) bosgo.ChallengeAnswerList {
-	c.ShowPrompt(false)
-	defer c.ShowPrompt(true)
-
-	var answers bosgo.ChallengeAnswerList
-	for {
-		var answer bosgo.ChallengeAnswer
-
-		c.Print("Challenge ID (q to quit): ")
-		answer.ID = c.ReadLine()
-		if strings.ToLower(answer.ID) == "q" {
-			return answers
-		}
-
-		c.Print("Value: ")
-		answer.Value = c.ReadLine()
-		answer.Store = promptBool(c, "Store (y/n)")
-
-		answers = append(answers, answer)
-	}
-}
-
 func promptKeyValueList(c *ishell.Context, keyPrompt string) map[string]string {
 	c.ShowPrompt(false)
 	defer c.ShowPrompt(true)
@@ -1392,7 +1683,7 @@ func promptKeyValueList(c *ishell.Context, keyPrompt string) map[string]string {
 
 func validateIBAN(c *ishell.Context) {
 	if session.appClient == nil {
-		c.Err(fmt.Errorf("use an application id first"))
+		c.Err(errNoApplication)
 		return
 	}
 
@@ -1404,12 +1695,12 @@ func validateIBAN(c *ishell.Context) {
 		return
 	}
 
-	dumpJSON(c, ibanInfo)
+	printResult(c, ibanInfo)
 }
 
 func resetUser(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 	applicationID := readArg(0, "Application ID", c)
@@ -1439,7 +1730,7 @@ func resetUser(c *ishell.Context) {
 
 func userInfo(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 	applicationID := readArg(0, "Application ID", c)
@@ -1450,12 +1741,17 @@ func userInfo(c *ishell.Context) {
 		return
 	}
 
+	if structuredOutput(c) {
+		printResult(c, resp)
+		return
+	}
+	lastResult = resp
 	c.Printf("Username: %s\n", resp.Username)
 }
 
 func appSettings(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 	applicationID := readArg(0, "Application ID", c)
@@ -1465,12 +1761,17 @@ func appSettings(c *ishell.Context) {
 		return
 	}
 
+	if structuredOutput(c) {
+		printResult(c, resp)
+		return
+	}
+	lastResult = resp
 	c.Printf("Background refresh enabled: %v\n", resp.BackgroundRefresh)
 }
 
 func updateAppSettings(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 	applicationID := readArg(0, "Application ID", c)
@@ -1485,12 +1786,17 @@ func updateAppSettings(c *ishell.Context) {
 		return
 	}
 
+	if structuredOutput(c) {
+		printResult(c, resp)
+		return
+	}
+	lastResult = resp
 	c.Printf("Background refresh enabled: %v\n", resp.BackgroundRefresh)
 }
 
 func listAppKeys(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 
@@ -1501,14 +1807,23 @@ func listAppKeys(c *ishell.Context) {
 		return
 	}
 
+	keys := make([]string, 0, len(list.Keys))
 	for _, key := range list.Keys {
-		c.Printf("* %s\n", key.Key)
+		keys = append(keys, key.Key)
+	}
+	if structuredOutput(c) {
+		printResult(c, keys)
+		return
+	}
+	lastResult = keys
+	for _, key := range keys {
+		c.Printf("* %s\n", key)
 	}
 }
 
 func createAppKey(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 
@@ -1519,12 +1834,17 @@ func createAppKey(c *ishell.Context) {
 		return
 	}
 
+	if structuredOutput(c) {
+		printResult(c, key.Key)
+		return
+	}
+	lastResult = key.Key
 	c.Printf("* %s\n", key.Key)
 }
 
 func addCredentials(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 	applicationID := readArg(0, "Application ID", c)
@@ -1537,13 +1857,14 @@ func addCredentials(c *ishell.Context) {
 		c.Err(err)
 		return
 	}
+	lastResult = credentialID
 	c.Printf("Credential added. Credential ID: %s\n", credentialID)
 
 }
 
 func listCredentials(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 	applicationID := readArg(0, "Application ID", c)
@@ -1553,12 +1874,12 @@ func listCredentials(c *ishell.Context) {
 		c.Err(err)
 		return
 	}
-	dumpJSON(c, creds)
+	printResult(c, creds)
 }
 
 func getCredentials(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 	credentialID := readArg(0, "Credential ID", c)
@@ -1568,13 +1889,13 @@ func getCredentials(c *ishell.Context) {
 		c.Err(err)
 		return
 	}
-	dumpJSON(c, creds)
+	printResult(c, creds)
 
 }
 
 func deleteCredentials(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 	credentialID := readArg(0, "Credential ID", c)
@@ -1589,7 +1910,7 @@ func deleteCredentials(c *ishell.Context) {
 
 func updateCredentials(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 	credentialID := readArg(0, "Credential ID", c)
@@ -1605,7 +1926,7 @@ func updateCredentials(c *ishell.Context) {
 
 func listCredentialProviders(c *ishell.Context) {
 	if session.devClient == nil {
-		c.Err(fmt.Errorf("login to a developer account first"))
+		c.Err(errNoDeveloper)
 		return
 	}
 
@@ -1614,5 +1935,5 @@ func listCredentialProviders(c *ishell.Context) {
 		c.Err(err)
 		return
 	}
-	dumpJSON(c, providers)
+	printResult(c, providers)
 }
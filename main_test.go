@@ -0,0 +1,327 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"code.bankrs.com/bosgo"
+)
+
+// TestMain runs bosh itself instead of the tests if BOSH_RUN_MAIN is set, so
+// tests can check how the process exits.
+func TestMain(m *testing.M) {
+	if os.Getenv("BOSH_RUN_MAIN") != "" {
+		main()
+		os.Exit(exitOK)
+	}
+	os.Exit(m.Run())
+}
+
+func TestDeveloperAccount(t *testing.T) {
+	h := newHarness(t)
+
+	h.mustRun("", "createdev", "new@example.com", "first")
+	h.fails(exitAPI, "email_taken", "", "createdev", "new@example.com", "other")
+
+	// login prompts for the email and reads the password without echo.
+	out := h.mustRun("new@example.com\nfirst\n", "login")
+	h.contains(out, "Email: ", "Password: ")
+	if session.devEmail != "new@example.com" {
+		t.Errorf("got developer %q, want new@example.com", session.devEmail)
+	}
+
+	h.mustRun("Example Corp\nmaybe\nn\n", "setprofile")
+	out = h.mustRun("", "profile")
+	h.contains(out, "Company: Example Corp", "Has production access: false")
+
+	out = h.mustRun("first\nsecond\n", "changepassword")
+	h.contains(out, "Old password: ", "New password: ")
+	h.fails(exitAuth, "invalid_credentials", "", "changepassword", "first", "third")
+
+	h.mustRun("", "logout")
+	if session.devClient != nil {
+		t.Error("still logged in after logout")
+	}
+	h.fails(exitAuth, "invalid_credentials", "", "login", "new@example.com", "first")
+	h.mustRun("", "login", "new@example.com", "second")
+
+	h.mustRun("", "deletedeveloper")
+	h.fails(exitAuth, "invalid_credentials", "", "login", "new@example.com", "second")
+}
+
+func TestLostPassword(t *testing.T) {
+	h := newHarness(t)
+
+	out := h.mustRun("dev@example.com\n", "lostpassword")
+	h.contains(out, "Email: ")
+	m := regexp.MustCompile(`reset token for dev@example.com: (\w+)`).FindStringSubmatch(h.log.String())
+	if m == nil {
+		t.Fatalf("no reset token in server log:\n%s", h.log)
+	}
+
+	h.fails(exitAPI, "invalid_token", "", "resetpassword", "changed", "nonsense")
+	out = h.mustRun("changed\n"+m[1]+"\n", "resetpassword")
+	h.contains(out, "Password: ", "Token: ")
+	h.mustRun("", "login", "dev@example.com", "changed")
+}
+
+func TestApplications(t *testing.T) {
+	h := newHarness(t)
+	h.loginDev()
+
+	out := h.mustRun("Tests\n", "createapp")
+	h.contains(out, "Label: ", "application id ")
+	id, _ := lastResult.(string)
+	if id == "" {
+		t.Fatalf("createapp did not set the result: %#v", lastResult)
+	}
+
+	out = h.mustRun("", "listapps")
+	h.contains(out, "Demo (demo)", "Tests ("+id+")")
+
+	h.mustRun(id+"\nRenamed\n", "updateapp")
+	h.contains(h.mustRun("", "listapps"), "Renamed ("+id+")")
+
+	out = h.mustRun("", "listappkeys", id)
+	keys, _ := lastResult.([]string)
+	if len(keys) != 1 {
+		t.Fatalf("got keys %v, want one key", lastResult)
+	}
+	h.contains(out, "* "+keys[0])
+	out = h.mustRun("", "createappkey", id)
+	h.contains(out, "* ")
+	if got := h.mustRun("", "listappkeys", id); strings.Count(got, "* ") != 2 {
+		t.Errorf("got keys\n%s\nwant two keys", got)
+	}
+
+	h.contains(h.mustRun(id+"\n", "appsettings"), "Background refresh enabled: false")
+	h.contains(h.mustRun(id+"\nyes\n", "updateappsettings"), "Background refresh enabled: true")
+	h.contains(h.mustRun("", "appsettings", id), "Background refresh enabled: true")
+
+	h.mustRun("", "useapp", keys[0])
+	h.mustRun("", "createuser", "bob", "secret")
+	if session.userName != "bob" {
+		t.Errorf("got user %q after createuser, want bob", session.userName)
+	}
+
+	h.mustRun("", "listusers", id)
+	users, _ := lastResult.([]string)
+	if len(users) != 1 {
+		t.Fatalf("got users %v, want one user", lastResult)
+	}
+	h.contains(h.mustRun(id+"\n"+users[0]+"\n", "userinfo"), "Username: bob")
+	h.fails(exitAPI, "not_found", "", "userinfo", id, "unknown")
+
+	h.contains(h.mustRun(id+"\nbob\n", "resetuser"), "Reset user bob")
+	h.fails(exitGeneral, "reset failed: user_not_found", "", "resetuser", id, "nobody")
+
+	h.mustRun("", "deleteapp", id)
+	if out := h.mustRun("", "listapps"); strings.Contains(out, id) {
+		t.Errorf("deleted application still listed:\n%s", out)
+	}
+	h.fails(exitAPI, "not_found", "", "appsettings", id)
+}
+
+func TestStats(t *testing.T) {
+	h := newHarness(t)
+	h.loginDev()
+
+	for _, kind := range []string{"merchants", "providers", "transfers", "users", "requests"} {
+		h.mustRun("", "stats", kind)
+	}
+	h.contains(h.mustRun("providers\n", "stats"), "Type: ", `"key": "DE-TEST"`)
+	h.contains(h.mustRun("", "stats", "users", "2018-11-01", "2018-11-30"), `"from_date": "2018-11-01"`, `"to_date": "2018-11-30"`)
+
+	h.fails(exitValidation, "unknown stat type", "", "stats", "weather")
+	h.fails(exitValidation, "yyyy-mm-dd", "", "stats", "users", "2018-11-01", "tomorrow")
+}
+
+func TestCredentials(t *testing.T) {
+	h := newHarness(t)
+	h.loginDev()
+
+	h.contains(h.mustRun("", "listcredentialproviders"), `"figo"`)
+
+	out := h.mustRun("client_id\nabc\nq\n", "addcredentials", "demo", "figo")
+	h.contains(out, "Credential Name (q to quit): ", "Value: ", "Credential added. Credential ID: ")
+	id, _ := lastResult.(string)
+	if id == "" {
+		t.Fatalf("addcredentials did not set the result: %#v", lastResult)
+	}
+	h.fails(exitAPI, "invalid_provider", "q\n", "addcredentials", "demo", "nobody")
+
+	h.contains(h.mustRun("demo\n", "listcredentials"), `"provider": "figo"`)
+	h.contains(h.mustRun(id+"\n", "getcredentials"), `"client_id": "abc"`)
+
+	h.mustRun("client_secret\nxyz\nq\n", "updatecredentials", id)
+	h.contains(h.mustRun("", "getcredentials", id), `"client_secret": "xyz"`)
+
+	h.mustRun("", "deletecredentials", id)
+	h.fails(exitAPI, "not_found", "", "getcredentials", id)
+}
+
+func TestProviders(t *testing.T) {
+	h := newHarness(t)
+	h.mustRun("demo-key\n", "useapp")
+
+	h.contains(h.mustRun("TAN\n", "searchproviders"), "Query: ", `"id": "DE-TAN"`)
+	h.contains(h.mustRun("", "provider", "DE-TEST"), `"name": "Testbank"`, `"secure": true`)
+	h.fails(exitAPI, "not_found", "", "provider", "XX-NONE")
+
+	h.contains(h.mustRun("", "validateiban", "DE89370400440532013000"), `"valid": true`)
+	h.contains(h.mustRun("DE00370400440532013000\n", "validateiban"), `"valid": false`)
+}
+
+func TestUser(t *testing.T) {
+	h := newHarness(t)
+	h.mustRun("", "useapp", "demo-key")
+
+	out := h.mustRun("alice\nsecret\n", "loginuser")
+	h.contains(out, "Name: ", "Password: ")
+	h.mustRun("", "logoutuser")
+	h.fails(exitAuth, "not logged in", "", "logoutuser")
+
+	h.fails(exitAuth, "invalid_credentials", "", "loginuser", "alice", "wrong")
+	h.mustRun("carol\nsecret\n", "createuser")
+	h.fails(exitAuth, "invalid_credentials", "", "deleteuser", "wrong")
+	out = h.mustRun("secret\n", "deleteuser")
+	h.contains(out, "Deleted user id ")
+	if session.userClient != nil {
+		t.Error("still logged in after deleteuser")
+	}
+	h.fails(exitAuth, "invalid_credentials", "", "loginuser", "carol", "secret")
+}
+
+func TestAccesses(t *testing.T) {
+	h := newHarness(t)
+	h.loginUser()
+
+	h.mustRun("", "accesses")
+	page := lastResult.(*bosgo.AccessPage)
+	if len(page.Accesses) != 1 {
+		t.Fatalf("got %d accesses, want 1", len(page.Accesses))
+	}
+	id := strconv.FormatInt(page.Accesses[0].ID, 10)
+
+	// The challenges of the provider are asked for in turn; invalid answers
+	// to the store question are asked again.
+	out := h.mustRun("DE-TEST\nalice\nn\n1234\nmaybe\nyes\n", "addaccess")
+	h.contains(out, "Provider ID: ", "Login name (alphanumeric): ", "PIN (numeric): ", "Store (y/n): ", "Job URI: /v1/jobs/")
+	uri := lastResult.(string)
+	var status *bosgo.JobStatus
+	for i := 0; i < 5; i++ {
+		h.mustRun(uri+"\n", "job")
+		if status = lastResult.(*bosgo.JobStatus); status.Finished {
+			break
+		}
+	}
+	if !status.Finished || len(status.Errors) != 0 {
+		t.Fatalf("got job status %+v, want finished without errors", status)
+	}
+	h.mustRun("", "accesses")
+	if n := len(lastResult.(*bosgo.AccessPage).Accesses); n != 2 {
+		t.Fatalf("got %d accesses after addaccess, want 2", n)
+	}
+
+	h.fails(exitAPI, "missing_challenge_answer", "\n\n", "addaccess", "DE-TEST")
+	h.fails(exitAPI, "not_found", "", "addaccess", "XX-NONE")
+	h.contains(h.mustRun("alice\nn\nabc\n1234\nn\n", "addaccess", "DE-TEST"), "PIN must be numeric")
+
+	h.contains(h.mustRun(id+"\n", "getaccess"), `"provider_id": "DE-TEST"`)
+	h.contains(h.mustRun("\n4321\ny\n", "updateaccess", id), `"id": `+id)
+	h.fails(exitValidation, "invalid syntax", "", "getaccess", "first")
+	h.fails(exitAPI, "not_found", "", "getaccess", "999")
+
+	h.contains(h.mustRun(id+"\n", "refreshaccess"), "Job URI: /v1/jobs/")
+	uri = lastResult.(string)
+	h.fails(exitAPI, "no_challenge", "pin\n1\nn\nq\n", "answer", uri)
+	h.mustRun(uri+"\n", "canceljob")
+	h.mustRun("", "job", uri)
+	if status := lastResult.(*bosgo.JobStatus); !status.Finished || len(status.Errors) == 0 || status.Errors[0].Code != "canceled" {
+		t.Errorf("got job status %+v after canceljob, want canceled", status)
+	}
+
+	out = h.mustRun("", "refreshall")
+	h.contains(out, "Job URIs:")
+	if uris := lastResult.([]string); len(uris) != 2 {
+		t.Errorf("got job uris %v, want 2", uris)
+	}
+
+	h.contains(h.mustRun(id+"\n", "deleteaccess"), "Deleted ID: "+id)
+	h.fails(exitAPI, "not_found", "", "deleteaccess", id)
+}
+
+func TestAccountsAndTransactions(t *testing.T) {
+	h := newHarness(t)
+	h.loginUser()
+
+	h.contains(h.mustRun("", "accounts"), `"iban": "DE89370400440532013000"`, `"name": "Tagesgeld"`)
+	account := lastResult.(*bosgo.AccountPage).Accounts[0]
+	id := strconv.FormatInt(account.ID, 10)
+	h.contains(h.mustRun(id+"\n", "getaccount"), `"balance": "1024.50"`)
+	h.fails(exitAPI, "not_found", "", "getaccount", "999")
+
+	h.contains(h.mustRun("", "transactions"), `"usage": "Salary November"`)
+	txs := lastResult.(*bosgo.TransactionPage).Transactions
+	if len(txs) != 6 {
+		t.Fatalf("got %d transactions, want 6", len(txs))
+	}
+	h.contains(h.mustRun(fmt.Sprint(txs[0].ID)+"\n", "gettransaction"), fmt.Sprintf(`"id": %d`, txs[0].ID))
+	h.fails(exitAPI, "not_found", "", "gettransaction", "999")
+
+	h.contains(h.mustRun("", "scheduledtransactions"), `"usage": "Rent December"`)
+	scheduled := lastResult.(*bosgo.ScheduledTransactionPage).ScheduledTransactions
+	h.contains(h.mustRun("", "getscheduledtransaction", fmt.Sprint(scheduled[0].ID)), `"booking_date": "2018-12-02`)
+
+	h.contains(h.mustRun("", "repeatedtransactions"), `"usage": "Subscription"`)
+	repeated := lastResult.(*bosgo.RepeatedTransactionPage).RepeatedTransactions
+	if len(repeated) != 2 {
+		t.Fatalf("got %d repeated transactions, want 2", len(repeated))
+	}
+	rid := fmt.Sprint(repeated[0].ID)
+	h.contains(h.mustRun(rid+"\n", "getrepeatedtransaction"), `"usage": "Rent"`)
+	h.contains(h.mustRun(rid+"\nq\n", "deleterecurringtransfer"), `"uri": "/v1/jobs/`)
+	h.fails(exitAPI, "not_found", "", "getrepeatedtransaction", rid)
+}
+
+// TestSessionRequired checks that every command needing a login says so
+// instead of calling the api.
+func TestSessionRequired(t *testing.T) {
+	h := newHarness(t)
+
+	developer := []string{
+		"logout", "deletedeveloper", "profile", "setprofile", "changepassword", "createapp", "listapps",
+		"updateapp", "deleteapp", "listusers", "stats", "resetuser", "userinfo", "appsettings",
+		"updateappsettings", "listappkeys", "createappkey", "addcredentials", "listcredentials",
+		"getcredentials", "deletecredentials", "updatecredentials", "listcredentialproviders",
+	}
+	application := []string{"createuser", "loginuser", "searchproviders", "provider", "validateiban"}
+	user := []string{
+		"accesses", "addaccess", "deleteaccess", "getaccess", "updateaccess", "refreshaccess", "refreshall",
+		"job", "wait", "answer", "canceljob", "accounts", "getaccount", "transactions", "gettransaction",
+		"exporttransactions", "analyze", "sync", "overview", "scheduledtransactions", "getscheduledtransaction", "repeatedtransactions", "getrepeatedtransaction",
+		"deleterecurringtransfer",
+	}
+
+	for _, cmd := range developer {
+		h.fails(exitAuth, errNoDeveloper.Error(), "", cmd)
+	}
+	for _, cmd := range application {
+		h.fails(exitAuth, errNoApplication.Error(), "", cmd)
+	}
+	for _, cmd := range user {
+		h.fails(exitAuth, errNoUser.Error(), "", cmd)
+	}
+	h.fails(exitAuth, errNotLoggedIn.Error(), "", "logoutuser")
+	h.fails(exitAuth, errNotLoggedIn.Error(), "", "deleteuser")
+
+	// An application key alone does not allow user commands.
+	h.mustRun("", "useapp", "demo-key")
+	h.fails(exitAuth, errNoUser.Error(), "", "accounts")
+	h.mustRun("", "useapp", "not-a-key")
+	h.fails(exitAuth, "unauthorized", "", "searchproviders", "bank")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+
+	"code.bankrs.com/bosh/mockserver"
+)
+
+// runMockServer implements the mock-server subcommand. It serves a local
+// api with fake data until the process is stopped and returns the exit code
+// for the process.
+func runMockServer(args []string) int {
+	fs := flag.NewFlagSet("mock-server", flag.ContinueOnError)
+	addr := fs.String("addr", "localhost:8443", "address to listen on")
+	fixtures := fs.String("fixtures", "", "JSON file with the developers, users and providers to start with instead of the built in ones")
+	plain := fs.Bool("plain", false, "serve plain http instead of https")
+	caOut := fs.String("ca-out", "", "write the self-signed certificate to a file, for use with -ca")
+	quiet := fs.Bool("q", false, "do not log requests")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: bosh mock-server [-addr host:port] [-fixtures file] [-plain] [-ca-out file] [-q]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var f *mockserver.Fixtures
+	if *fixtures != "" {
+		var err error
+		if f, err = mockserver.LoadFixtures(*fixtures); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	srv, err := mockserver.New(f)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !*quiet {
+		srv.Log = os.Stderr
+	}
+
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *plain {
+		fmt.Fprintf(os.Stderr, "mock server listening on http://%s\n", l.Addr())
+		fmt.Fprintln(os.Stderr, http.Serve(l, srv))
+		return 1
+	}
+
+	host, _, _ := net.SplitHostPort(l.Addr().String())
+	certPEM, keyPEM, err := mockserver.SelfSignedCertificate("localhost", "127.0.0.1", "::1", host)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *caOut != "" {
+		if err := ioutil.WriteFile(*caOut, certPEM, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "mock server listening on https://%s, connect with: bosh -a %s -insecure\n", l.Addr(), l.Addr())
+	hs := &http.Server{Handler: srv}
+	err = hs.Serve(tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{cert}}))
+	fmt.Fprintln(os.Stderr, err)
+	return 1
+}
@@ -0,0 +1,507 @@
+package mockserver
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type sessionToken struct {
+	Token string `json:"token"`
+}
+
+func (s *Server) createDeveloper(r *request) (interface{}, error) {
+	var body credentials
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	if !strings.Contains(body.Email, "@") {
+		return nil, badRequest("invalid_email", "%q is not a valid email address", body.Email)
+	}
+	if body.Password == "" {
+		return nil, badRequest("invalid_password", "password must not be empty")
+	}
+	if _, exists := s.store.developers[body.Email]; exists {
+		return nil, &apiError{status: http.StatusConflict, code: "email_taken", message: "a developer with this email already exists"}
+	}
+	dev := &developer{email: body.Email, password: body.Password}
+	s.store.developers[dev.email] = dev
+	return s.newDevSession(dev), nil
+}
+
+func (s *Server) deleteDeveloper(r *request) (interface{}, error) {
+	for id, app := range s.store.apps {
+		if app.owner == r.dev {
+			s.removeApplication(app)
+			delete(s.store.apps, id)
+		}
+	}
+	for token, dev := range s.store.devSessions {
+		if dev == r.dev {
+			delete(s.store.devSessions, token)
+		}
+	}
+	delete(s.store.developers, r.dev.email)
+	return nil, nil
+}
+
+func (s *Server) loginDeveloper(r *request) (interface{}, error) {
+	var body credentials
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	dev := s.store.developers[body.Email]
+	if dev == nil || dev.password != body.Password {
+		return nil, errLogin
+	}
+	return s.newDevSession(dev), nil
+}
+
+func (s *Server) newDevSession(dev *developer) sessionToken {
+	token := randomHex(16)
+	s.store.devSessions[token] = dev
+	return sessionToken{Token: token}
+}
+
+func (s *Server) logoutDeveloper(r *request) (interface{}, error) {
+	delete(s.store.devSessions, r.Header.Get("X-Token"))
+	return nil, nil
+}
+
+// lostPassword creates a reset token. There is no mail to send it with, so
+// it is written to the log.
+func (s *Server) lostPassword(r *request) (interface{}, error) {
+	var body credentials
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	// Like the real api, unknown addresses are not revealed.
+	if dev := s.store.developers[body.Email]; dev != nil {
+		token := randomHex(16)
+		s.store.resetTokens[token] = dev
+		s.logf("password reset token for %s: %s", dev.email, token)
+	}
+	return nil, nil
+}
+
+func (s *Server) resetPassword(r *request) (interface{}, error) {
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	dev := s.store.resetTokens[body.Token]
+	if dev == nil {
+		return nil, badRequest("invalid_token", "password reset token is invalid or has been used")
+	}
+	if body.Password == "" {
+		return nil, badRequest("invalid_password", "password must not be empty")
+	}
+	delete(s.store.resetTokens, body.Token)
+	dev.password = body.Password
+	return nil, nil
+}
+
+type developerProfile struct {
+	Company             string `json:"company"`
+	HasProductionAccess bool   `json:"has_production_access"`
+}
+
+func (s *Server) developerProfile(r *request) (interface{}, error) {
+	return developerProfile{Company: r.dev.company, HasProductionAccess: r.dev.productionKey}, nil
+}
+
+func (s *Server) setDeveloperProfile(r *request) (interface{}, error) {
+	var body developerProfile
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	r.dev.company = body.Company
+	return nil, nil
+}
+
+func (s *Server) changePassword(r *request) (interface{}, error) {
+	var body struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	if body.OldPassword != r.dev.password {
+		return nil, errLogin
+	}
+	if body.NewPassword == "" {
+		return nil, badRequest("invalid_password", "password must not be empty")
+	}
+	r.dev.password = body.NewPassword
+	return nil, nil
+}
+
+type applicationMetadata struct {
+	ApplicationID string `json:"application_id"`
+	Label         string `json:"label"`
+}
+
+func (s *Server) listApplications(r *request) (interface{}, error) {
+	apps := []applicationMetadata{}
+	for _, app := range s.store.apps {
+		if app.owner == r.dev {
+			apps = append(apps, applicationMetadata{ApplicationID: app.id, Label: app.label})
+		}
+	}
+	sort.Slice(apps, func(i, j int) bool {
+		return s.store.apps[apps[i].ApplicationID].created.Before(s.store.apps[apps[j].ApplicationID].created)
+	})
+	return map[string]interface{}{"applications": apps}, nil
+}
+
+func (s *Server) createApplication(r *request) (interface{}, error) {
+	var body struct {
+		Label string `json:"label"`
+	}
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	app := s.store.newApplication(r.dev, body.Label)
+	key := randomHex(16)
+	app.keys = append(app.keys, key)
+	s.store.keys[key] = app
+	return applicationMetadata{ApplicationID: app.id, Label: app.label}, nil
+}
+
+// application returns the application named by the request path if it is
+// owned by the developer making the request.
+func (s *Server) application(r *request) (*application, error) {
+	app := s.store.apps[r.params["app"]]
+	if app == nil || app.owner != r.dev {
+		return nil, notFound("application")
+	}
+	return app, nil
+}
+
+func (s *Server) updateApplication(r *request) (interface{}, error) {
+	app, err := s.application(r)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		Label string `json:"label"`
+	}
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	app.label = body.Label
+	return nil, nil
+}
+
+func (s *Server) deleteApplication(r *request) (interface{}, error) {
+	app, err := s.application(r)
+	if err != nil {
+		return nil, err
+	}
+	s.removeApplication(app)
+	delete(s.store.apps, app.id)
+	return nil, nil
+}
+
+// removeApplication invalidates the keys, users and credentials of an
+// application.
+func (s *Server) removeApplication(app *application) {
+	for _, key := range app.keys {
+		delete(s.store.keys, key)
+	}
+	for token, u := range s.store.userSessions {
+		if u.app == app {
+			delete(s.store.userSessions, token)
+		}
+	}
+	for id, c := range s.store.credentials {
+		if c.Application == app.id {
+			delete(s.store.credentials, id)
+		}
+	}
+}
+
+type applicationKey struct {
+	Key string `json:"key"`
+}
+
+func (s *Server) listKeys(r *request) (interface{}, error) {
+	app, err := s.application(r)
+	if err != nil {
+		return nil, err
+	}
+	keys := []applicationKey{}
+	for _, k := range app.keys {
+		keys = append(keys, applicationKey{Key: k})
+	}
+	return map[string]interface{}{"keys": keys}, nil
+}
+
+func (s *Server) createKey(r *request) (interface{}, error) {
+	app, err := s.application(r)
+	if err != nil {
+		return nil, err
+	}
+	key := randomHex(16)
+	app.keys = append(app.keys, key)
+	s.store.keys[key] = app
+	return applicationKey{Key: key}, nil
+}
+
+func (s *Server) listUsers(r *request) (interface{}, error) {
+	app, err := s.application(r)
+	if err != nil {
+		return nil, err
+	}
+	users := []string{}
+	for _, u := range app.users {
+		users = append(users, u.id)
+	}
+	sort.Strings(users)
+	return map[string]interface{}{"users": users}, nil
+}
+
+type resetUserResult struct {
+	Username string    `json:"username"`
+	Problems []Problem `json:"problems"`
+}
+
+// resetUsers removes all accesses of the given users, as if they had just
+// signed up.
+func (s *Server) resetUsers(r *request) (interface{}, error) {
+	app, err := s.application(r)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		Usernames []string `json:"usernames"`
+	}
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	results := []resetUserResult{}
+	for _, name := range body.Usernames {
+		res := resetUserResult{Username: name, Problems: []Problem{}}
+		if u := app.users[name]; u != nil {
+			u.accesses = nil
+		} else {
+			res.Problems = append(res.Problems, Problem{Code: "user_not_found"})
+		}
+		results = append(results, res)
+	}
+	return map[string]interface{}{"users": results}, nil
+}
+
+func (s *Server) userInfo(r *request) (interface{}, error) {
+	app, err := s.application(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range app.users {
+		if u.id == r.params["user"] {
+			return map[string]interface{}{"username": u.username}, nil
+		}
+	}
+	return nil, notFound("user")
+}
+
+type applicationSettings struct {
+	BackgroundRefresh bool `json:"background_refresh"`
+}
+
+func (s *Server) applicationSettings(r *request) (interface{}, error) {
+	app, err := s.application(r)
+	if err != nil {
+		return nil, err
+	}
+	return applicationSettings{BackgroundRefresh: app.backgroundRefresh}, nil
+}
+
+func (s *Server) updateApplicationSettings(r *request) (interface{}, error) {
+	app, err := s.application(r)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		BackgroundRefresh *bool `json:"background_refresh"`
+	}
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	if body.BackgroundRefresh != nil {
+		app.backgroundRefresh = *body.BackgroundRefresh
+	}
+	return applicationSettings{BackgroundRefresh: app.backgroundRefresh}, nil
+}
+
+func (s *Server) listCredentials(r *request) (interface{}, error) {
+	app, err := s.application(r)
+	if err != nil {
+		return nil, err
+	}
+	creds := []*credential{}
+	for _, c := range s.store.credentials {
+		if c.Application == app.id {
+			creds = append(creds, c)
+		}
+	}
+	sort.Slice(creds, func(i, j int) bool { return creds[i].ID < creds[j].ID })
+	return map[string]interface{}{"credentials": creds}, nil
+}
+
+func (s *Server) createCredential(r *request) (interface{}, error) {
+	app, err := s.application(r)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		Provider    string            `json:"provider"`
+		Credentials map[string]string `json:"credentials"`
+	}
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	if !credentialProvider(body.Provider) {
+		return nil, badRequest("invalid_provider", "credentials for %q are not supported", body.Provider)
+	}
+	c := &credential{
+		ID:          randomHex(8),
+		Application: app.id,
+		Provider:    body.Provider,
+		Values:      body.Credentials,
+	}
+	s.store.credentials[c.ID] = c
+	return map[string]interface{}{"id": c.ID}, nil
+}
+
+// credentialProviders are the third party services whose credentials an
+// application can store.
+var credentialProviders = []string{"figo", "finapi", "openbank"}
+
+func credentialProvider(name string) bool {
+	for _, p := range credentialProviders {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) credentialProviders(r *request) (interface{}, error) {
+	return credentialProviders, nil
+}
+
+// credential returns the credential named by the request path if it
+// belongs to one of the applications of the developer making the request.
+func (s *Server) credential(r *request) (*credential, error) {
+	c := s.store.credentials[r.params["credential"]]
+	if c == nil {
+		return nil, notFound("credential")
+	}
+	if app := s.store.apps[c.Application]; app == nil || app.owner != r.dev {
+		return nil, notFound("credential")
+	}
+	return c, nil
+}
+
+func (s *Server) getCredential(r *request) (interface{}, error) {
+	return s.credential(r)
+}
+
+func (s *Server) updateCredential(r *request) (interface{}, error) {
+	c, err := s.credential(r)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		Credentials map[string]string `json:"credentials"`
+	}
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	c.Values = body.Credentials
+	return nil, nil
+}
+
+func (s *Server) deleteCredential(r *request) (interface{}, error) {
+	c, err := s.credential(r)
+	if err != nil {
+		return nil, err
+	}
+	delete(s.store.credentials, c.ID)
+	return nil, nil
+}
+
+// stats reports the usage of the applications of a developer. The mock
+// counts what is in the store, so only users and providers are non-zero.
+func (s *Server) stats(r *request) (interface{}, error) {
+	from, to, err := statsPeriod(r)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	kind := r.params["kind"]
+	for _, app := range s.store.apps {
+		if app.owner != r.dev {
+			continue
+		}
+		for _, u := range app.users {
+			switch kind {
+			case "users":
+				counts[app.id]++
+			case "providers":
+				for _, a := range u.accesses {
+					counts[a.ProviderID]++
+				}
+			}
+		}
+	}
+	switch kind {
+	case "users", "providers", "merchants", "transfers", "requests":
+	default:
+		return nil, notFound("statistics " + kind)
+	}
+
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	entries := []map[string]interface{}{}
+	for _, k := range keys {
+		entries = append(entries, map[string]interface{}{"key": k, "count": counts[k]})
+	}
+	return map[string]interface{}{
+		"from_date": from.Format("2006-01-02"),
+		"to_date":   to.Format("2006-01-02"),
+		"stats":     entries,
+	}, nil
+}
+
+func statsPeriod(r *request) (from, to time.Time, err error) {
+	to = today()
+	from = to.AddDate(0, -1, 0)
+	q := r.URL.Query()
+	if v := q.Get("from_date"); v != "" {
+		if from, err = time.Parse("2006-01-02", v); err != nil {
+			return from, to, badRequest("invalid_date", "invalid from_date %q", v)
+		}
+	}
+	if v := q.Get("to_date"); v != "" {
+		if to, err = time.Parse("2006-01-02", v); err != nil {
+			return from, to, badRequest("invalid_date", "invalid to_date %q", v)
+		}
+	}
+	return from, to, nil
+}
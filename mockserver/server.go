@@ -0,0 +1,290 @@
+// Package mockserver implements a local Bankrs OS api for offline development
+// and tests. Its state is held in memory and seeded from fixtures; bank
+// accesses are simulated and never touch a real bank.
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Server is an http.Handler serving the api.
+type Server struct {
+	// Log receives one line per request if it is not nil.
+	Log io.Writer
+
+	store    *Store
+	routes   []route
+	requests int64
+}
+
+// New returns a server with the data of fixtures. DefaultFixtures are used
+// if fixtures is nil.
+func New(fixtures *Fixtures) (*Server, error) {
+	if fixtures == nil {
+		fixtures = DefaultFixtures()
+	}
+	store, err := NewStore(fixtures)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{store: store}
+	s.routes = s.routeTable()
+	return s, nil
+}
+
+// auth is the kind of credentials a route requires.
+type auth int
+
+const (
+	public   auth = iota // no credentials
+	devAuth              // developer session token
+	appAuth              // application key
+	userAuth             // application key and user session token
+)
+
+// handlerFunc serves a request. The returned value is sent as JSON.
+type handlerFunc func(r *request) (interface{}, error)
+
+type route struct {
+	method  string
+	pattern []string
+	auth    auth
+	handle  handlerFunc
+}
+
+// request is an incoming request with its path parameters and the
+// principals it was authenticated as.
+type request struct {
+	*http.Request
+	params map[string]string
+
+	dev  *developer
+	app  *application
+	user *user
+}
+
+func (r *request) decode(v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && err != io.EOF {
+		return badRequest("invalid_json", "request body is not valid json: %v", err)
+	}
+	return nil
+}
+
+// apiError is an error response.
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func badRequest(code, format string, args ...interface{}) error {
+	return &apiError{status: http.StatusBadRequest, code: code, message: fmt.Sprintf(format, args...)}
+}
+
+func notFound(what string) error {
+	return &apiError{status: http.StatusNotFound, code: "not_found", message: what + " not found"}
+}
+
+var (
+	errUnauthorized = &apiError{status: http.StatusUnauthorized, code: "unauthorized", message: "session token is missing or invalid"}
+	errNoAppKey     = &apiError{status: http.StatusUnauthorized, code: "unauthorized", message: "application key is missing or invalid"}
+	errLogin        = &apiError{status: http.StatusUnauthorized, code: "invalid_credentials", message: "invalid login or password"}
+)
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	id := fmt.Sprintf("mock-%d", atomic.AddInt64(&s.requests, 1))
+	w.Header().Set("X-Request-Id", id)
+
+	status, v := s.serve(req)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+
+	s.logf("%s %s %s %d %s", id, req.Method, req.URL.RequestURI(), status, time.Since(start).Round(time.Microsecond))
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.Log != nil {
+		fmt.Fprintf(s.Log, format+"\n", args...)
+	}
+}
+
+func (s *Server) serve(req *http.Request) (int, interface{}) {
+	r, h, err := s.match(req)
+	if err == nil {
+		s.store.mu.Lock()
+		defer s.store.mu.Unlock()
+		if err = s.authenticate(r, h.auth); err == nil {
+			var v interface{}
+			if v, err = h.handle(r); err == nil {
+				if v == nil {
+					v = struct{}{}
+				}
+				return http.StatusOK, v
+			}
+		}
+	}
+
+	e, ok := err.(*apiError)
+	if !ok {
+		e = &apiError{status: http.StatusInternalServerError, code: "internal_error", message: err.Error()}
+	}
+	return e.status, map[string]interface{}{
+		"errors": []Problem{{Code: e.code, Message: e.message}},
+	}
+}
+
+// match finds the route of a request.
+func (s *Server) match(req *http.Request) (*request, *route, error) {
+	segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	methodMismatch := false
+	for i := range s.routes {
+		rt := &s.routes[i]
+		params, ok := rt.match(segments)
+		if !ok {
+			continue
+		}
+		if rt.method != req.Method {
+			methodMismatch = true
+			continue
+		}
+		return &request{Request: req, params: params}, rt, nil
+	}
+	if methodMismatch {
+		return nil, nil, &apiError{status: http.StatusMethodNotAllowed, code: "method_not_allowed", message: req.Method + " is not supported by " + req.URL.Path}
+	}
+	return nil, nil, notFound(req.URL.Path)
+}
+
+func (rt *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rt.pattern) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, p := range rt.pattern {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			params[p[1:len(p)-1]] = segments[i]
+			continue
+		}
+		if p != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// authenticate checks the credentials sent with a request. The store must
+// be locked.
+func (s *Server) authenticate(r *request, a auth) error {
+	switch a {
+	case devAuth:
+		if r.dev = s.store.devSessions[r.Header.Get("X-Token")]; r.dev == nil {
+			return errUnauthorized
+		}
+	case appAuth, userAuth:
+		if r.app = s.store.keys[r.Header.Get("X-Application-Id")]; r.app == nil {
+			return errNoAppKey
+		}
+		if a == userAuth {
+			r.user = s.store.userSessions[r.Header.Get("X-Token")]
+			if r.user == nil || r.user.app != r.app {
+				return errUnauthorized
+			}
+		}
+	}
+	return nil
+}
+
+// routeTable lists the endpoints of the api.
+func (s *Server) routeTable() []route {
+	routes := []struct {
+		method string
+		path   string
+		auth   auth
+		handle handlerFunc
+	}{
+		{"POST", "/v1/developers", public, s.createDeveloper},
+		{"DELETE", "/v1/developers", devAuth, s.deleteDeveloper},
+		{"POST", "/v1/developers/login", public, s.loginDeveloper},
+		{"POST", "/v1/developers/logout", devAuth, s.logoutDeveloper},
+		{"POST", "/v1/developers/lost_password", public, s.lostPassword},
+		{"POST", "/v1/developers/reset_password", public, s.resetPassword},
+		{"GET", "/v1/developers/profile", devAuth, s.developerProfile},
+		{"PUT", "/v1/developers/profile", devAuth, s.setDeveloperProfile},
+		{"PUT", "/v1/developers/password", devAuth, s.changePassword},
+
+		{"GET", "/v1/developers/applications", devAuth, s.listApplications},
+		{"POST", "/v1/developers/applications", devAuth, s.createApplication},
+		{"PUT", "/v1/developers/applications/{app}", devAuth, s.updateApplication},
+		{"DELETE", "/v1/developers/applications/{app}", devAuth, s.deleteApplication},
+		{"GET", "/v1/developers/applications/{app}/keys", devAuth, s.listKeys},
+		{"POST", "/v1/developers/applications/{app}/keys", devAuth, s.createKey},
+		{"GET", "/v1/developers/applications/{app}/users", devAuth, s.listUsers},
+		{"POST", "/v1/developers/applications/{app}/users/reset", devAuth, s.resetUsers},
+		{"GET", "/v1/developers/applications/{app}/users/{user}", devAuth, s.userInfo},
+		{"GET", "/v1/developers/applications/{app}/settings", devAuth, s.applicationSettings},
+		{"PUT", "/v1/developers/applications/{app}/settings", devAuth, s.updateApplicationSettings},
+		{"GET", "/v1/developers/applications/{app}/credentials", devAuth, s.listCredentials},
+		{"POST", "/v1/developers/applications/{app}/credentials", devAuth, s.createCredential},
+
+		{"GET", "/v1/developers/credentials/providers", devAuth, s.credentialProviders},
+		{"GET", "/v1/developers/credentials/{credential}", devAuth, s.getCredential},
+		{"PUT", "/v1/developers/credentials/{credential}", devAuth, s.updateCredential},
+		{"DELETE", "/v1/developers/credentials/{credential}", devAuth, s.deleteCredential},
+
+		{"GET", "/v1/developers/stats/{kind}", devAuth, s.stats},
+
+		{"POST", "/v1/users", appAuth, s.createUser},
+		{"DELETE", "/v1/users", userAuth, s.deleteUser},
+		{"POST", "/v1/users/login", appAuth, s.loginUser},
+		{"POST", "/v1/users/logout", userAuth, s.logoutUser},
+
+		{"GET", "/v1/providers", appAuth, s.searchProviders},
+		{"GET", "/v1/providers/{provider}", appAuth, s.getProvider},
+		{"GET", "/v1/iban/{iban}", appAuth, s.validateIBAN},
+
+		{"GET", "/v1/accesses", userAuth, s.listAccesses},
+		{"POST", "/v1/accesses", userAuth, s.addAccess},
+		{"POST", "/v1/accesses/refresh", userAuth, s.refreshAllAccesses},
+		{"GET", "/v1/accesses/{access}", userAuth, s.getAccess},
+		{"PUT", "/v1/accesses/{access}", userAuth, s.updateAccess},
+		{"DELETE", "/v1/accesses/{access}", userAuth, s.deleteAccess},
+		{"POST", "/v1/accesses/{access}/refresh", userAuth, s.refreshAccess},
+
+		{"GET", "/v1/jobs/{job}", userAuth, s.getJob},
+		{"PUT", "/v1/jobs/{job}", userAuth, s.answerJob},
+		{"DELETE", "/v1/jobs/{job}", userAuth, s.cancelJob},
+
+		{"GET", "/v1/accounts", userAuth, s.listAccounts},
+		{"GET", "/v1/accounts/{account}", userAuth, s.getAccount},
+		{"GET", "/v1/transactions", userAuth, s.listTransactions},
+		{"GET", "/v1/transactions/{transaction}", userAuth, s.getTransaction},
+		{"GET", "/v1/scheduled_transactions", userAuth, s.listScheduledTransactions},
+		{"GET", "/v1/scheduled_transactions/{transaction}", userAuth, s.getScheduledTransaction},
+		{"GET", "/v1/repeated_transactions", userAuth, s.listRepeatedTransactions},
+		{"GET", "/v1/repeated_transactions/{transaction}", userAuth, s.getRepeatedTransaction},
+		{"DELETE", "/v1/repeated_transactions/{transaction}", userAuth, s.deleteRepeatedTransaction},
+	}
+
+	table := make([]route, 0, len(routes))
+	for _, r := range routes {
+		table = append(table, route{
+			method:  r.method,
+			pattern: strings.Split(strings.Trim(r.path, "/"), "/"),
+			auth:    r.auth,
+			handle:  r.handle,
+		})
+	}
+	return table
+}
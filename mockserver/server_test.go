@@ -0,0 +1,159 @@
+package mockserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// call sends a request to s and decodes the response.
+func call(t *testing.T, s *Server, method, path string, header map[string]string, body interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	var v map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil {
+		t.Fatalf("%s %s: %v in %q", method, path, err, w.Body)
+	}
+	return w, v
+}
+
+// errorCode returns the code of the first problem in an error response.
+func errorCode(v map[string]interface{}) string {
+	errs, _ := v["errors"].([]interface{})
+	if len(errs) == 0 {
+		return ""
+	}
+	code, _ := errs[0].(map[string]interface{})["code"].(string)
+	return code
+}
+
+func newServer(t *testing.T) (*Server, *bytes.Buffer) {
+	t.Helper()
+	s, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := &bytes.Buffer{}
+	s.Log = log
+	return s, log
+}
+
+func TestRouting(t *testing.T) {
+	s, log := newServer(t)
+
+	w, v := call(t, s, "GET", "/v1/nowhere", nil, nil)
+	if w.Code != http.StatusNotFound || errorCode(v) != "not_found" {
+		t.Errorf("unknown path: got %d %v", w.Code, v)
+	}
+	w, v = call(t, s, "PATCH", "/v1/developers/profile", nil, nil)
+	if w.Code != http.StatusMethodNotAllowed || errorCode(v) != "method_not_allowed" {
+		t.Errorf("wrong method: got %d %v", w.Code, v)
+	}
+	w, v = call(t, s, "GET", "/v1/developers/profile", nil, nil)
+	if w.Code != http.StatusUnauthorized || errorCode(v) != "unauthorized" {
+		t.Errorf("no token: got %d %v", w.Code, v)
+	}
+	w, v = call(t, s, "GET", "/v1/providers", nil, nil)
+	if w.Code != http.StatusUnauthorized || v["errors"].([]interface{})[0].(map[string]interface{})["message"] != errNoAppKey.message {
+		t.Errorf("no application key: got %d %v", w.Code, v)
+	}
+
+	if id := w.Header().Get("X-Request-Id"); id != "mock-4" {
+		t.Errorf("got request id %q, want mock-4", id)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got content type %q", ct)
+	}
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "mock-1 GET /v1/nowhere 404 ") {
+		t.Errorf("got log:\n%s", log)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	s, _ := newServer(t)
+
+	w, v := call(t, s, "POST", "/v1/developers/login", nil, map[string]string{"email": "dev@example.com", "password": "wrong"})
+	if w.Code != http.StatusUnauthorized || errorCode(v) != "invalid_credentials" {
+		t.Errorf("wrong password: got %d %v", w.Code, v)
+	}
+	_, v = call(t, s, "POST", "/v1/developers/login", nil, map[string]string{"email": "dev@example.com", "password": "secret"})
+	dev := map[string]string{"X-Token": v["token"].(string)}
+	if w, v := call(t, s, "GET", "/v1/developers/profile", dev, nil); w.Code != http.StatusOK {
+		t.Errorf("profile: got %d %v", w.Code, v)
+	}
+	call(t, s, "POST", "/v1/developers/logout", dev, nil)
+	if w, v := call(t, s, "GET", "/v1/developers/profile", dev, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("profile after logout: got %d %v", w.Code, v)
+	}
+
+	app := map[string]string{"X-Application-Id": "demo-key"}
+	_, v = call(t, s, "POST", "/v1/users/login", app, map[string]string{"username": "alice", "password": "secret"})
+	user := map[string]string{"X-Application-Id": "demo-key", "X-Token": v["token"].(string)}
+	if w, v := call(t, s, "GET", "/v1/accesses", user, nil); w.Code != http.StatusOK {
+		t.Errorf("accesses: got %d %v", w.Code, v)
+	}
+	// A user session is only valid with the key of its application.
+	if w, _ := call(t, s, "GET", "/v1/accesses", map[string]string{"X-Token": user["X-Token"]}, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("accesses without application key: got %d", w.Code)
+	}
+}
+
+func TestListTransactions(t *testing.T) {
+	s, _ := newServer(t)
+	app := map[string]string{"X-Application-Id": "demo-key"}
+	_, v := call(t, s, "POST", "/v1/users/login", app, map[string]string{"username": "alice", "password": "secret"})
+	user := map[string]string{"X-Application-Id": "demo-key", "X-Token": v["token"].(string)}
+
+	dates := func(path string) []time.Time {
+		t.Helper()
+		w, v := call(t, s, "GET", path, user, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got %d %v", path, w.Code, v)
+		}
+		var ds []time.Time
+		for _, tx := range v["transactions"].([]interface{}) {
+			d, err := time.Parse(time.RFC3339, tx.(map[string]interface{})["entry_date"].(string))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ds = append(ds, d)
+		}
+		return ds
+	}
+
+	all := dates("/v1/transactions")
+	if len(all) != 6 {
+		t.Fatalf("got %d transactions, want 6", len(all))
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].After(all[i-1]) {
+			t.Errorf("transaction %d of %v is newer than the one before", i, all)
+		}
+	}
+	if page := dates("/v1/transactions?limit=2&offset=4"); len(page) != 2 || !page[0].Equal(all[4]) {
+		t.Errorf("got page %v, want the last 2 of %v", page, all)
+	}
+	if page := dates("/v1/transactions?offset=10"); len(page) != 0 {
+		t.Errorf("got %v past the end", page)
+	}
+	if w, v := call(t, s, "GET", "/v1/transactions?limit=x", user, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid limit: got %d %v", w.Code, v)
+	}
+}
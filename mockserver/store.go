@@ -0,0 +1,646 @@
+package mockserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fixtures describe the data a server starts with. They can be loaded from a
+// JSON file, see LoadFixtures.
+type Fixtures struct {
+	Developers []DeveloperFixture `json:"developers"`
+	Providers  []Provider         `json:"providers"`
+}
+
+// DeveloperFixture is a developer account with its applications.
+type DeveloperFixture struct {
+	Email        string               `json:"email"`
+	Password     string               `json:"password"`
+	Company      string               `json:"company"`
+	Applications []ApplicationFixture `json:"applications"`
+}
+
+// ApplicationFixture is an application with its keys and users.
+type ApplicationFixture struct {
+	ID    string        `json:"id"`
+	Label string        `json:"label"`
+	Keys  []string      `json:"keys"`
+	Users []UserFixture `json:"users"`
+}
+
+// UserFixture is a user of an application with its bank accesses.
+type UserFixture struct {
+	Username string          `json:"username"`
+	Password string          `json:"password"`
+	Accesses []AccessFixture `json:"accesses"`
+}
+
+// AccessFixture is a bank access of a user.
+type AccessFixture struct {
+	ProviderID string           `json:"provider_id"`
+	Name       string           `json:"name"`
+	Accounts   []AccountFixture `json:"accounts"`
+}
+
+// AccountFixture is a bank account with its transactions.
+type AccountFixture struct {
+	Name         string               `json:"name"`
+	Type         string               `json:"type"`
+	IBAN         string               `json:"iban"`
+	Currency     string               `json:"currency"`
+	Balance      string               `json:"balance"`
+	Transactions []TransactionFixture `json:"transactions"`
+	Scheduled    []TransactionFixture `json:"scheduled"`
+	Repeated     []TransactionFixture `json:"repeated"`
+}
+
+// TransactionFixture is a transaction of an account. Dates are given as
+// 2006-01-02. Scheduled transactions are booked on their entry date,
+// repeated transactions have no dates.
+type TransactionFixture struct {
+	EntryDate      string `json:"entry_date"`
+	SettlementDate string `json:"settlement_date"`
+	Amount         string `json:"amount"`
+	Counterparty   string `json:"counterparty"`
+	IBAN           string `json:"iban"`
+	Usage          string `json:"usage"`
+	Type           string `json:"type"`
+}
+
+// LoadFixtures reads fixtures from a JSON file.
+func LoadFixtures(filename string) (*Fixtures, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var f Fixtures
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("reading fixtures %s: %v", filename, err)
+	}
+	return &f, nil
+}
+
+// DefaultFixtures returns a developer dev@example.com with the password
+// "secret" owning the application "demo" with the key "demo-key". Its user
+// alice, password "secret", has an access to the test bank DE-TEST with a
+// checking and a savings account.
+func DefaultFixtures() *Fixtures {
+	return &Fixtures{
+		Providers: []Provider{
+			{
+				ID:          "DE-TEST",
+				Name:        "Testbank",
+				Description: "Bank for testing with login and PIN",
+				Country:     "DE",
+				URL:         "https://testbank.example.com",
+				Address:     "Teststraße 1, Berlin",
+				PostalCode:  "10115",
+				Challenges: []ChallengeSpec{
+					{ID: "login", Description: "Login name", Type: "alphanumeric"},
+					{ID: "pin", Description: "PIN", Type: "numeric", Secure: true},
+				},
+			},
+			{
+				ID:          "DE-TAN",
+				Name:        "TAN Bank",
+				Description: "Bank for testing that never stores the TAN",
+				Country:     "DE",
+				URL:         "https://tanbank.example.com",
+				Address:     "Hauptstraße 5, München",
+				PostalCode:  "80331",
+				Challenges: []ChallengeSpec{
+					{ID: "login", Description: "Login name", Type: "alphanumeric"},
+					{ID: "pin", Description: "PIN", Type: "numeric", Secure: true},
+					{ID: "tan", Description: "TAN", Type: "numeric", Secure: true, UnStoreable: true},
+				},
+			},
+			{
+				ID:          "GB-TEST",
+				Name:        "Test Bank UK",
+				Description: "Bank for testing with a password",
+				Country:     "GB",
+				URL:         "https://testbank.example.co.uk",
+				Address:     "1 Test Street, London",
+				PostalCode:  "EC1A 1BB",
+				Challenges: []ChallengeSpec{
+					{ID: "login", Description: "Customer number", Type: "numeric"},
+					{ID: "password", Description: "Password", Type: "alphanumeric", Secure: true},
+				},
+			},
+		},
+		Developers: []DeveloperFixture{
+			{
+				Email:    "dev@example.com",
+				Password: "secret",
+				Company:  "Example Ltd",
+				Applications: []ApplicationFixture{
+					{
+						ID:    "demo",
+						Label: "Demo",
+						Keys:  []string{"demo-key"},
+						Users: []UserFixture{
+							{
+								Username: "alice",
+								Password: "secret",
+								Accesses: []AccessFixture{
+									{
+										ProviderID: "DE-TEST",
+										Name:       "Testbank",
+										Accounts: []AccountFixture{
+											{
+												Name:     "Girokonto",
+												Type:     "current",
+												IBAN:     "DE89370400440532013000",
+												Currency: "EUR",
+												Balance:  "1024.50",
+												Transactions: []TransactionFixture{
+													{EntryDate: "2018-11-01", Amount: "2500.00", Counterparty: "Example Ltd", IBAN: "DE02120300000000202051", Usage: "Salary November", Type: "credit"},
+													{EntryDate: "2018-11-02", Amount: "-850.00", Counterparty: "Hausverwaltung Berlin", IBAN: "DE02500105170137075030", Usage: "Rent November", Type: "debit"},
+													{EntryDate: "2018-11-05", Amount: "-62.35", Counterparty: "Supermarkt", Usage: "Card payment", Type: "debit"},
+													{EntryDate: "2018-11-12", Amount: "-9.99", Counterparty: "Streaming Service", Usage: "Subscription", Type: "debit"},
+													{EntryDate: "2018-11-20", Amount: "-553.16", Counterparty: "Savings", IBAN: "DE75512108001245126199", Usage: "Transfer to savings", Type: "transfer"},
+												},
+												Scheduled: []TransactionFixture{
+													{EntryDate: "2018-12-02", Amount: "-850.00", Counterparty: "Hausverwaltung Berlin", IBAN: "DE02500105170137075030", Usage: "Rent December"},
+												},
+												Repeated: []TransactionFixture{
+													{Amount: "-850.00", Counterparty: "Hausverwaltung Berlin", IBAN: "DE02500105170137075030", Usage: "Rent"},
+													{Amount: "-9.99", Counterparty: "Streaming Service", Usage: "Subscription"},
+												},
+											},
+											{
+												Name:     "Tagesgeld",
+												Type:     "savings",
+												IBAN:     "DE75512108001245126199",
+												Currency: "EUR",
+												Balance:  "5553.16",
+												Transactions: []TransactionFixture{
+													{EntryDate: "2018-11-20", Amount: "553.16", Counterparty: "Girokonto", IBAN: "DE89370400440532013000", Usage: "Transfer to savings", Type: "transfer"},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// Store is the in-memory state of a mock server.
+type Store struct {
+	mu sync.Mutex
+
+	nextID       int64
+	providers    []Provider
+	developers   map[string]*developer
+	devSessions  map[string]*developer
+	resetTokens  map[string]*developer
+	apps         map[string]*application
+	keys         map[string]*application
+	userSessions map[string]*user
+	jobs         map[string]*job
+	credentials  map[string]*credential
+}
+
+type developer struct {
+	email         string
+	password      string
+	company       string
+	productionKey bool
+}
+
+type application struct {
+	id                string
+	label             string
+	owner             *developer
+	keys              []string
+	backgroundRefresh bool
+	users             map[string]*user
+	created           time.Time
+}
+
+type user struct {
+	id       string
+	username string
+	password string
+	app      *application
+	accesses []*access
+}
+
+type access struct {
+	Access
+	accounts []*account
+	answers  map[string]string
+}
+
+type account struct {
+	Account
+	transactions []Transaction
+	scheduled    []ScheduledTransaction
+	repeated     []RepeatedTransaction
+}
+
+type job struct {
+	id        string
+	user      *user
+	access    *access
+	polls     int
+	finished  bool
+	stage     string
+	errors    []Problem
+	challenge *ChallengeSpec
+	onFinish  func()
+}
+
+type credential struct {
+	ID          string            `json:"id"`
+	Application string            `json:"application_id"`
+	Provider    string            `json:"provider"`
+	Values      map[string]string `json:"credentials"`
+}
+
+// NewStore creates a store with the data of fixtures.
+func NewStore(f *Fixtures) (*Store, error) {
+	s := &Store{
+		nextID:       1,
+		providers:    f.Providers,
+		developers:   map[string]*developer{},
+		devSessions:  map[string]*developer{},
+		resetTokens:  map[string]*developer{},
+		apps:         map[string]*application{},
+		keys:         map[string]*application{},
+		userSessions: map[string]*user{},
+		jobs:         map[string]*job{},
+		credentials:  map[string]*credential{},
+	}
+
+	for _, df := range f.Developers {
+		dev := &developer{email: df.Email, password: df.Password, company: df.Company}
+		s.developers[dev.email] = dev
+		for _, af := range df.Applications {
+			app := s.newApplication(dev, af.Label)
+			if af.ID != "" {
+				delete(s.apps, app.id)
+				app.id = af.ID
+				s.apps[app.id] = app
+			}
+			for _, key := range af.Keys {
+				app.keys = append(app.keys, key)
+				s.keys[key] = app
+			}
+			for _, uf := range af.Users {
+				u := s.newUser(app, uf.Username, uf.Password)
+				for _, acf := range uf.Accesses {
+					if _, err := s.newAccessFromFixture(u, acf); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) id() int64 {
+	id := s.nextID
+	s.nextID++
+	return id
+}
+
+func (s *Store) provider(id string) (Provider, bool) {
+	for _, p := range s.providers {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return Provider{}, false
+}
+
+func (s *Store) newApplication(dev *developer, label string) *application {
+	app := &application{
+		id:      randomHex(8),
+		label:   label,
+		owner:   dev,
+		users:   map[string]*user{},
+		created: time.Now().UTC(),
+	}
+	s.apps[app.id] = app
+	return app
+}
+
+func (s *Store) newUser(app *application, username, password string) *user {
+	u := &user{
+		id:       randomUUID(),
+		username: username,
+		password: password,
+		app:      app,
+	}
+	app.users[username] = u
+	return u
+}
+
+func (s *Store) newAccessFromFixture(u *user, f AccessFixture) (*access, error) {
+	if _, ok := s.provider(f.ProviderID); !ok {
+		return nil, fmt.Errorf("access of %s: unknown provider %q", u.username, f.ProviderID)
+	}
+	a := &access{Access: Access{
+		ID:         s.id(),
+		Name:       f.Name,
+		ProviderID: f.ProviderID,
+		Enabled:    true,
+	}}
+	for _, af := range f.Accounts {
+		acc := &account{Account: Account{
+			ID:           s.id(),
+			ProviderID:   f.ProviderID,
+			BankAccessID: a.ID,
+			Name:         af.Name,
+			Type:         af.Type,
+			Number:       accountNumber(af.IBAN),
+			Balance:      af.Balance,
+			BalanceDate:  today(),
+			Enabled:      true,
+			Currency:     af.Currency,
+			IBAN:         af.IBAN,
+			Supported:    true,
+		}}
+		for _, tf := range af.Transactions {
+			tx, err := s.transactionFromFixture(acc, tf)
+			if err != nil {
+				return nil, fmt.Errorf("account %s of %s: %v", af.Name, u.username, err)
+			}
+			acc.transactions = append(acc.transactions, tx)
+		}
+		for _, tf := range af.Scheduled {
+			tx, err := s.transactionFromFixture(acc, tf)
+			if err != nil {
+				return nil, fmt.Errorf("account %s of %s: %v", af.Name, u.username, err)
+			}
+			acc.scheduled = append(acc.scheduled, ScheduledTransaction{
+				ID:           tx.ID,
+				UserAccount:  tx.UserAccount,
+				Counterparty: tx.Counterparty,
+				BookingDate:  tx.EntryDate,
+				Amount:       tx.Value,
+				Usage:        tx.Usage,
+			})
+		}
+		for _, tf := range af.Repeated {
+			if tf.EntryDate == "" {
+				tf.EntryDate = "2006-01-02"
+			}
+			tx, err := s.transactionFromFixture(acc, tf)
+			if err != nil {
+				return nil, fmt.Errorf("account %s of %s: %v", af.Name, u.username, err)
+			}
+			acc.repeated = append(acc.repeated, RepeatedTransaction{
+				ID:           tx.ID,
+				UserAccount:  tx.UserAccount,
+				Counterparty: tx.Counterparty,
+				Amount:       tx.Value,
+				Usage:        tx.Usage,
+			})
+		}
+		a.accounts = append(a.accounts, acc)
+	}
+	a.Accounts = a.accountRefs()
+	u.accesses = append(u.accesses, a)
+	return a, nil
+}
+
+func (s *Store) transactionFromFixture(acc *account, f TransactionFixture) (Transaction, error) {
+	entry, err := time.Parse("2006-01-02", f.EntryDate)
+	if err != nil {
+		return Transaction{}, fmt.Errorf("invalid entry date %q", f.EntryDate)
+	}
+	settlement := entry
+	if f.SettlementDate != "" {
+		if settlement, err = time.Parse("2006-01-02", f.SettlementDate); err != nil {
+			return Transaction{}, fmt.Errorf("invalid settlement date %q", f.SettlementDate)
+		}
+	}
+	if _, err := strconv.ParseFloat(f.Amount, 64); err != nil {
+		return Transaction{}, fmt.Errorf("invalid amount %q", f.Amount)
+	}
+	return Transaction{
+		ID:          s.id(),
+		UserAccount: acc.ref(),
+		Counterparty: Counterparty{
+			Name:    f.Counterparty,
+			Account: AccountRef{IBAN: f.IBAN, Number: accountNumber(f.IBAN)},
+		},
+		EntryDate:       entry,
+		SettlementDate:  settlement,
+		Value:           MoneyAmount{Currency: acc.Currency, Value: f.Amount},
+		Usage:           f.Usage,
+		TransactionType: f.Type,
+	}, nil
+}
+
+// newAccess creates an access with a checking account and a few
+// transactions, as a bank would return for a new login.
+func (s *Store) newAccess(u *user, p Provider, answers map[string]string) *access {
+	iban := testIBAN(p.Country, s.nextID)
+	a, _ := s.newAccessFromFixture(u, AccessFixture{
+		ProviderID: p.ID,
+		Name:       p.Name,
+		Accounts: []AccountFixture{{
+			Name:     "Checking",
+			Type:     "current",
+			IBAN:     iban,
+			Currency: currencyOf(p.Country),
+			Balance:  "100.00",
+			Transactions: []TransactionFixture{
+				{EntryDate: today().AddDate(0, 0, -2).Format("2006-01-02"), Amount: "150.00", Counterparty: "Opening deposit", Usage: "Deposit", Type: "credit"},
+				{EntryDate: today().AddDate(0, 0, -1).Format("2006-01-02"), Amount: "-50.00", Counterparty: "Shop", Usage: "Card payment", Type: "debit"},
+			},
+		}},
+	})
+	a.answers = answers
+	return a
+}
+
+func (a *access) accountRefs() []AccountRef {
+	refs := make([]AccountRef, 0, len(a.accounts))
+	for _, acc := range a.accounts {
+		refs = append(refs, acc.ref())
+	}
+	return refs
+}
+
+func (acc *account) ref() AccountRef {
+	return AccountRef{
+		ProviderID: acc.ProviderID,
+		IBAN:       acc.IBAN,
+		Label:      acc.Name,
+		Number:     acc.Number,
+		ID:         acc.ID,
+	}
+}
+
+func (u *user) account(id int64) *account {
+	for _, a := range u.accesses {
+		for _, acc := range a.accounts {
+			if acc.ID == id {
+				return acc
+			}
+		}
+	}
+	return nil
+}
+
+func (u *user) access(id int64) (*access, int) {
+	for i, a := range u.accesses {
+		if a.ID == id {
+			return a, i
+		}
+	}
+	return nil, -1
+}
+
+func (s *Store) newJob(u *user, a *access) *job {
+	j := &job{id: randomHex(8), user: u, access: a, stage: "authenticating"}
+	s.jobs[j.id] = j
+	return j
+}
+
+func (j *job) uri() string {
+	return "/v1/jobs/" + j.id
+}
+
+// advance moves a job one stage further each time it is polled. A job
+// waiting for a challenge answer stays where it is.
+func (j *job) advance() {
+	if j.finished {
+		return
+	}
+	if j.challenge != nil {
+		j.stage = "challenge"
+		return
+	}
+	j.polls++
+	switch j.polls {
+	case 1:
+		j.stage = "authenticating"
+	case 2:
+		j.stage = "downloading"
+	default:
+		j.stage = "finished"
+		j.finished = true
+		if j.onFinish != nil {
+			j.onFinish()
+		}
+	}
+}
+
+// fail finishes a job with an error.
+func (j *job) fail(code string) {
+	j.finished = true
+	j.stage = "failed"
+	j.challenge = nil
+	j.errors = append(j.errors, Problem{Code: code})
+}
+
+func (j *job) status() JobStatus {
+	st := JobStatus{
+		Finished: j.finished,
+		Stage:    j.stage,
+		Errors:   j.errors,
+		URI:      j.uri(),
+	}
+	if st.Errors == nil {
+		st.Errors = []Problem{}
+	}
+	if j.challenge != nil {
+		st.Challenge = j.challenge
+	}
+	return st
+}
+
+func today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func randomUUID() string {
+	h := randomHex(16)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// accountNumber returns the account number contained in a German IBAN, or
+// the IBAN without its country code and check digits.
+func accountNumber(iban string) string {
+	if len(iban) <= 4 {
+		return ""
+	}
+	if strings.HasPrefix(iban, "DE") && len(iban) == 22 {
+		return strings.TrimLeft(iban[12:], "0")
+	}
+	return iban[4:]
+}
+
+func currencyOf(country string) string {
+	if country == "GB" {
+		return "GBP"
+	}
+	return "EUR"
+}
+
+// testIBAN returns a valid IBAN for the country using n as account number.
+func testIBAN(country string, n int64) string {
+	var bban string
+	if country == "GB" {
+		bban = fmt.Sprintf("TEST000000%08d", n)
+	} else {
+		country = "DE"
+		bban = fmt.Sprintf("10000000%010d", n)
+	}
+	check := 98 - ibanMod97(bban+country+"00")
+	return fmt.Sprintf("%s%02d%s", country, check, bban)
+}
+
+// validIBAN checks the length and check digits of an IBAN.
+func validIBAN(iban string) bool {
+	iban = strings.ToUpper(strings.Replace(iban, " ", "", -1))
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+	for _, c := range iban {
+		if !(c >= '0' && c <= '9' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return ibanMod97(iban[4:]+iban[:4]) == 1
+}
+
+func ibanMod97(s string) int {
+	var digits strings.Builder
+	for _, c := range s {
+		if c >= 'A' && c <= 'Z' {
+			digits.WriteString(strconv.Itoa(int(c-'A') + 10))
+		} else {
+			digits.WriteRune(c)
+		}
+	}
+	n, _ := new(big.Int).SetString(digits.String(), 10)
+	return int(new(big.Int).Mod(n, big.NewInt(97)).Int64())
+}
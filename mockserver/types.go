@@ -0,0 +1,130 @@
+package mockserver
+
+import "time"
+
+// The types below are the JSON documents exchanged with the api.
+
+// Provider is a financial provider such as a bank.
+type Provider struct {
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Country     string          `json:"country"`
+	URL         string          `json:"url"`
+	Address     string          `json:"address"`
+	PostalCode  string          `json:"postal_code"`
+	Challenges  []ChallengeSpec `json:"challenges"`
+}
+
+// ChallengeSpec describes a field a user has to provide to log in at a
+// provider.
+type ChallengeSpec struct {
+	ID          string `json:"id"`
+	Description string `json:"desc"`
+	Type        string `json:"type"`
+	Secure      bool   `json:"secure"`
+	UnStoreable bool   `json:"unstoreable"`
+}
+
+type ProviderSearchResult struct {
+	Score    float64  `json:"score"`
+	Provider Provider `json:"provider"`
+}
+
+type ChallengeAnswer struct {
+	ID    string `json:"id"`
+	Value string `json:"value"`
+	Store bool   `json:"store"`
+}
+
+type Access struct {
+	ID         int64        `json:"id"`
+	Name       string       `json:"name"`
+	ProviderID string       `json:"provider_id"`
+	Enabled    bool         `json:"enabled"`
+	Accounts   []AccountRef `json:"accounts"`
+}
+
+type AccountRef struct {
+	ProviderID string `json:"provider_id"`
+	IBAN       string `json:"iban"`
+	Label      string `json:"label"`
+	Number     string `json:"number"`
+	ID         int64  `json:"id"`
+}
+
+type Account struct {
+	ID           int64     `json:"id"`
+	ProviderID   string    `json:"provider_id"`
+	BankAccessID int64     `json:"bank_access_id"`
+	Name         string    `json:"name"`
+	Type         string    `json:"type"`
+	Number       string    `json:"number"`
+	Balance      string    `json:"balance"`
+	BalanceDate  time.Time `json:"balance_date"`
+	Enabled      bool      `json:"enabled"`
+	Currency     string    `json:"currency"`
+	IBAN         string    `json:"iban"`
+	Supported    bool      `json:"supported"`
+	Alias        string    `json:"alias"`
+}
+
+type Transaction struct {
+	ID                    int64        `json:"id"`
+	UserAccount           AccountRef   `json:"user_account"`
+	CategoryID            int64        `json:"category_id"`
+	Repeated              bool         `json:"repeated"`
+	Transfer              bool         `json:"transfer"`
+	RepeatedTransactionID int64        `json:"repeated_transaction_id"`
+	Counterparty          Counterparty `json:"counterparty"`
+	EntryDate             time.Time    `json:"entry_date"`
+	SettlementDate        time.Time    `json:"settlement_date"`
+	Value                 MoneyAmount  `json:"value"`
+	Usage                 string       `json:"usage"`
+	TransactionType       string       `json:"transaction_type"`
+}
+
+type ScheduledTransaction struct {
+	ID           int64        `json:"id"`
+	UserAccount  AccountRef   `json:"user_account"`
+	Counterparty Counterparty `json:"counterparty"`
+	BookingDate  time.Time    `json:"booking_date"`
+	Amount       MoneyAmount  `json:"amount"`
+	Usage        string       `json:"usage"`
+}
+
+type RepeatedTransaction struct {
+	ID           int64        `json:"id"`
+	UserAccount  AccountRef   `json:"user_account"`
+	Counterparty Counterparty `json:"counterparty"`
+	Amount       MoneyAmount  `json:"amount"`
+	Usage        string       `json:"usage"`
+}
+
+type Counterparty struct {
+	Name    string     `json:"name"`
+	Account AccountRef `json:"account"`
+}
+
+type MoneyAmount struct {
+	Currency string `json:"currency"`
+	Value    string `json:"value"`
+}
+
+type Job struct {
+	URI string `json:"uri"`
+}
+
+type JobStatus struct {
+	Finished  bool        `json:"finished"`
+	Stage     string      `json:"stage"`
+	Challenge interface{} `json:"challenge,omitempty"`
+	Errors    []Problem   `json:"errors"`
+	URI       string      `json:"uri"`
+}
+
+// Problem is an error reported by the api.
+type Problem struct {
+	Code    string `json:"code"`
+	Message string `json:"message,omitempty"`
+}
@@ -0,0 +1,504 @@
+package mockserver
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type userCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (s *Server) createUser(r *request) (interface{}, error) {
+	var body userCredentials
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	if body.Username == "" || body.Password == "" {
+		return nil, badRequest("invalid_credentials", "username and password must not be empty")
+	}
+	if r.app.users[body.Username] != nil {
+		return nil, &apiError{status: http.StatusConflict, code: "username_taken", message: "a user with this name already exists"}
+	}
+	return s.newUserSession(s.store.newUser(r.app, body.Username, body.Password)), nil
+}
+
+func (s *Server) loginUser(r *request) (interface{}, error) {
+	var body userCredentials
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	u := r.app.users[body.Username]
+	if u == nil || u.password != body.Password {
+		return nil, errLogin
+	}
+	return s.newUserSession(u), nil
+}
+
+func (s *Server) newUserSession(u *user) sessionToken {
+	token := randomHex(16)
+	s.store.userSessions[token] = u
+	return sessionToken{Token: token}
+}
+
+func (s *Server) logoutUser(r *request) (interface{}, error) {
+	delete(s.store.userSessions, r.Header.Get("X-Token"))
+	return nil, nil
+}
+
+func (s *Server) deleteUser(r *request) (interface{}, error) {
+	var body struct {
+		Password string `json:"password"`
+	}
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	if body.Password != r.user.password {
+		return nil, errLogin
+	}
+	for token, u := range s.store.userSessions {
+		if u == r.user {
+			delete(s.store.userSessions, token)
+		}
+	}
+	delete(r.app.users, r.user.username)
+	return map[string]interface{}{"deleted_user_id": r.user.id}, nil
+}
+
+// searchProviders scores providers by how well their name, id or
+// description match the query.
+func (s *Server) searchProviders(r *request) (interface{}, error) {
+	q := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	results := []ProviderSearchResult{}
+	for _, p := range s.store.providers {
+		var score float64
+		switch {
+		case q == "":
+			score = 0.1
+		case strings.ToLower(p.ID) == q || strings.ToLower(p.Name) == q:
+			score = 1
+		case strings.Contains(strings.ToLower(p.Name), q) || strings.Contains(strings.ToLower(p.ID), q):
+			score = 0.8
+		case strings.Contains(strings.ToLower(p.Description), q):
+			score = 0.5
+		default:
+			continue
+		}
+		results = append(results, ProviderSearchResult{Score: score, Provider: p})
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	return results, nil
+}
+
+func (s *Server) getProvider(r *request) (interface{}, error) {
+	p, ok := s.store.provider(r.params["provider"])
+	if !ok {
+		return nil, notFound("provider")
+	}
+	return p, nil
+}
+
+func (s *Server) validateIBAN(r *request) (interface{}, error) {
+	iban := strings.ToUpper(strings.Replace(r.params["iban"], " ", "", -1))
+	details := map[string]interface{}{
+		"iban":  iban,
+		"valid": validIBAN(iban),
+	}
+	if details["valid"] == true {
+		details["country"] = iban[:2]
+		details["account_number"] = accountNumber(iban)
+	}
+	return details, nil
+}
+
+func (s *Server) listAccesses(r *request) (interface{}, error) {
+	accesses := []Access{}
+	for _, a := range r.user.accesses {
+		accesses = append(accesses, a.Access)
+	}
+	return map[string]interface{}{"accesses": accesses}, nil
+}
+
+// addAccess checks the challenge answers for a provider and starts a job
+// logging in. The access is created when the job is polled for the first
+// time. An answer of "wrong" makes the login fail.
+func (s *Server) addAccess(r *request) (interface{}, error) {
+	var body struct {
+		ProviderID string            `json:"provider_id"`
+		Answers    []ChallengeAnswer `json:"challenge_answers"`
+	}
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	p, ok := s.store.provider(body.ProviderID)
+	if !ok {
+		return nil, notFound("provider")
+	}
+	answers, err := checkAnswers(p, body.Answers, true)
+	if err != nil {
+		return nil, err
+	}
+
+	j := s.store.newJob(r.user, nil)
+	if loginFails(answers) {
+		j.fail("login_failed")
+	} else {
+		j.onFinish = func() {
+			j.access = s.store.newAccess(r.user, p, answers)
+		}
+	}
+	return Job{URI: j.uri()}, nil
+}
+
+// checkAnswers validates challenge answers against the challenges of a
+// provider and returns the answers by id. If all is set every challenge
+// must be answered.
+func checkAnswers(p Provider, answers []ChallengeAnswer, all bool) (map[string]string, error) {
+	byID := map[string]string{}
+	for _, a := range answers {
+		var spec *ChallengeSpec
+		for i := range p.Challenges {
+			if p.Challenges[i].ID == a.ID {
+				spec = &p.Challenges[i]
+			}
+		}
+		if spec == nil {
+			return nil, badRequest("unknown_challenge", "%s has no challenge %q", p.ID, a.ID)
+		}
+		if spec.UnStoreable && a.Store {
+			return nil, badRequest("unstoreable_challenge", "the answer to %q cannot be stored", a.ID)
+		}
+		if spec.Type == "numeric" && a.Value != "wrong" {
+			if _, err := strconv.ParseUint(a.Value, 10, 64); err != nil {
+				return nil, badRequest("invalid_challenge_answer", "the answer to %q must be numeric", a.ID)
+			}
+		}
+		byID[a.ID] = a.Value
+	}
+	if all {
+		for _, c := range p.Challenges {
+			if _, ok := byID[c.ID]; !ok {
+				return nil, badRequest("missing_challenge_answer", "the challenge %q must be answered", c.ID)
+			}
+		}
+	}
+	return byID, nil
+}
+
+func loginFails(answers map[string]string) bool {
+	for _, v := range answers {
+		if v == "wrong" {
+			return true
+		}
+	}
+	return false
+}
+
+// access returns the access named by the request path.
+func (s *Server) access(r *request) (*access, int, error) {
+	id, err := strconv.ParseInt(r.params["access"], 10, 64)
+	if err != nil {
+		return nil, -1, notFound("access")
+	}
+	a, i := r.user.access(id)
+	if a == nil {
+		return nil, -1, notFound("access")
+	}
+	return a, i, nil
+}
+
+func (s *Server) getAccess(r *request) (interface{}, error) {
+	a, _, err := s.access(r)
+	if err != nil {
+		return nil, err
+	}
+	return a.Access, nil
+}
+
+func (s *Server) updateAccess(r *request) (interface{}, error) {
+	a, _, err := s.access(r)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		Answers []ChallengeAnswer `json:"challenge_answers"`
+	}
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	p, _ := s.store.provider(a.ProviderID)
+	answers, err := checkAnswers(p, body.Answers, false)
+	if err != nil {
+		return nil, err
+	}
+	if a.answers == nil {
+		a.answers = map[string]string{}
+	}
+	for id, v := range answers {
+		a.answers[id] = v
+	}
+	return a.Access, nil
+}
+
+func (s *Server) deleteAccess(r *request) (interface{}, error) {
+	a, i, err := s.access(r)
+	if err != nil {
+		return nil, err
+	}
+	r.user.accesses = append(r.user.accesses[:i], r.user.accesses[i+1:]...)
+	return map[string]interface{}{"id": a.ID}, nil
+}
+
+func (s *Server) refreshAccess(r *request) (interface{}, error) {
+	a, _, err := s.access(r)
+	if err != nil {
+		return nil, err
+	}
+	return Job{URI: s.refreshJob(r.user, a).uri()}, nil
+}
+
+func (s *Server) refreshAllAccesses(r *request) (interface{}, error) {
+	jobs := []Job{}
+	for _, a := range r.user.accesses {
+		jobs = append(jobs, Job{URI: s.refreshJob(r.user, a).uri()})
+	}
+	return jobs, nil
+}
+
+// refreshJob starts a job refreshing an access. Refreshing asks for the
+// answers of challenges that cannot be stored, such as a TAN.
+func (s *Server) refreshJob(u *user, a *access) *job {
+	j := s.store.newJob(u, a)
+	p, _ := s.store.provider(a.ProviderID)
+	for _, c := range p.Challenges {
+		if c.UnStoreable {
+			j.challenge = &c
+			break
+		}
+	}
+	if loginFails(a.answers) {
+		j.fail("login_failed")
+	}
+	return j
+}
+
+func (s *Server) job(r *request) (*job, error) {
+	j := s.store.jobs[r.params["job"]]
+	if j == nil || j.user != r.user {
+		return nil, notFound("job")
+	}
+	return j, nil
+}
+
+func (s *Server) getJob(r *request) (interface{}, error) {
+	j, err := s.job(r)
+	if err != nil {
+		return nil, err
+	}
+	j.advance()
+	return j.status(), nil
+}
+
+func (s *Server) answerJob(r *request) (interface{}, error) {
+	j, err := s.job(r)
+	if err != nil {
+		return nil, err
+	}
+	if j.challenge == nil {
+		return nil, &apiError{status: http.StatusConflict, code: "no_challenge", message: "the job is not waiting for an answer"}
+	}
+	var body struct {
+		Answers []ChallengeAnswer `json:"challenge_answers"`
+	}
+	if err := r.decode(&body); err != nil {
+		return nil, err
+	}
+	for _, a := range body.Answers {
+		if a.ID == j.challenge.ID {
+			if a.Value == "wrong" {
+				j.fail("invalid_challenge_answer")
+			}
+			j.challenge = nil
+			return nil, nil
+		}
+	}
+	return nil, badRequest("missing_challenge_answer", "the challenge %q must be answered", j.challenge.ID)
+}
+
+func (s *Server) cancelJob(r *request) (interface{}, error) {
+	j, err := s.job(r)
+	if err != nil {
+		return nil, err
+	}
+	if !j.finished {
+		j.fail("canceled")
+	}
+	return nil, nil
+}
+
+func (s *Server) listAccounts(r *request) (interface{}, error) {
+	accounts := []Account{}
+	for _, a := range r.user.accesses {
+		for _, acc := range a.accounts {
+			accounts = append(accounts, acc.Account)
+		}
+	}
+	return map[string]interface{}{"accounts": accounts}, nil
+}
+
+func (s *Server) getAccount(r *request) (interface{}, error) {
+	id, _ := strconv.ParseInt(r.params["account"], 10, 64)
+	acc := r.user.account(id)
+	if acc == nil {
+		return nil, notFound("account")
+	}
+	return acc.Account, nil
+}
+
+// accounts returns the accounts of the user, or only the one given by the
+// account_id query parameter.
+func (s *Server) accounts(r *request) ([]*account, error) {
+	if v := r.URL.Query().Get("account_id"); v != "" {
+		id, _ := strconv.ParseInt(v, 10, 64)
+		acc := r.user.account(id)
+		if acc == nil {
+			return nil, notFound("account")
+		}
+		return []*account{acc}, nil
+	}
+	var accounts []*account
+	for _, a := range r.user.accesses {
+		accounts = append(accounts, a.accounts...)
+	}
+	return accounts, nil
+}
+
+// listTransactions returns transactions, newest first, paged by the limit
+// and offset query parameters.
+func (s *Server) listTransactions(r *request) (interface{}, error) {
+	accounts, err := s.accounts(r)
+	if err != nil {
+		return nil, err
+	}
+	txs := []Transaction{}
+	for _, acc := range accounts {
+		txs = append(txs, acc.transactions...)
+	}
+	sort.SliceStable(txs, func(i, j int) bool {
+		if !txs[i].EntryDate.Equal(txs[j].EntryDate) {
+			return txs[i].EntryDate.After(txs[j].EntryDate)
+		}
+		return txs[i].ID > txs[j].ID
+	})
+
+	q := r.URL.Query()
+	offset, err := queryInt(q.Get("offset"), 0)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := queryInt(q.Get("limit"), 100)
+	if err != nil {
+		return nil, err
+	}
+	if offset > len(txs) {
+		offset = len(txs)
+	}
+	txs = txs[offset:]
+	if limit < len(txs) {
+		txs = txs[:limit]
+	}
+	return map[string]interface{}{"transactions": txs}, nil
+}
+
+func queryInt(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, badRequest("invalid_parameter", "%q is not a valid number", v)
+	}
+	return n, nil
+}
+
+func (s *Server) getTransaction(r *request) (interface{}, error) {
+	id, _ := strconv.ParseInt(r.params["transaction"], 10, 64)
+	accounts, _ := s.accounts(r)
+	for _, acc := range accounts {
+		for _, tx := range acc.transactions {
+			if tx.ID == id {
+				return tx, nil
+			}
+		}
+	}
+	return nil, notFound("transaction")
+}
+
+func (s *Server) listScheduledTransactions(r *request) (interface{}, error) {
+	accounts, err := s.accounts(r)
+	if err != nil {
+		return nil, err
+	}
+	txs := []ScheduledTransaction{}
+	for _, acc := range accounts {
+		txs = append(txs, acc.scheduled...)
+	}
+	return map[string]interface{}{"scheduled_transactions": txs}, nil
+}
+
+func (s *Server) getScheduledTransaction(r *request) (interface{}, error) {
+	id, _ := strconv.ParseInt(r.params["transaction"], 10, 64)
+	accounts, _ := s.accounts(r)
+	for _, acc := range accounts {
+		for _, tx := range acc.scheduled {
+			if tx.ID == id {
+				return tx, nil
+			}
+		}
+	}
+	return nil, notFound("scheduled transaction")
+}
+
+func (s *Server) listRepeatedTransactions(r *request) (interface{}, error) {
+	accounts, err := s.accounts(r)
+	if err != nil {
+		return nil, err
+	}
+	txs := []RepeatedTransaction{}
+	for _, acc := range accounts {
+		txs = append(txs, acc.repeated...)
+	}
+	return map[string]interface{}{"repeated_transactions": txs}, nil
+}
+
+func (s *Server) getRepeatedTransaction(r *request) (interface{}, error) {
+	id, _ := strconv.ParseInt(r.params["transaction"], 10, 64)
+	accounts, _ := s.accounts(r)
+	for _, acc := range accounts {
+		for _, tx := range acc.repeated {
+			if tx.ID == id {
+				return tx, nil
+			}
+		}
+	}
+	return nil, notFound("repeated transaction")
+}
+
+// deleteRepeatedTransaction starts a job cancelling a standing order at the
+// bank. The order is removed right away.
+func (s *Server) deleteRepeatedTransaction(r *request) (interface{}, error) {
+	id, _ := strconv.ParseInt(r.params["transaction"], 10, 64)
+	accounts, _ := s.accounts(r)
+	for _, acc := range accounts {
+		for i, tx := range acc.repeated {
+			if tx.ID == id {
+				acc.repeated = append(acc.repeated[:i], acc.repeated[i+1:]...)
+				return Job{URI: s.store.newJob(r.user, nil).uri()}, nil
+			}
+		}
+	}
+	return nil, notFound("repeated transaction")
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/abiosoft/ishell"
+	"gopkg.in/yaml.v2"
+)
+
+var outputFormat = flag.String("output", "", "format for printing results: json, compact, ndjson, yaml, csv or table")
+
+// outputFormats lists the supported values of the output setting. The empty
+// format prints structured results as indented JSON and keeps the plain text
+// output of commands such as listapps.
+var outputFormats = []string{"json", "compact", "ndjson", "yaml", "csv", "table"}
+
+func setOutputFormat(format string) error {
+	if format == "" || format == "default" {
+		*outputFormat = ""
+		return nil
+	}
+	for _, f := range outputFormats {
+		if f == format {
+			*outputFormat = format
+			return nil
+		}
+	}
+	return validationErrorf("unknown output format %q, expected one of %s", format, strings.Join(outputFormats, ", "))
+}
+
+// structuredOutput reports whether commands that normally print plain text
+// should print their result in the selected output format or template
+// instead. This is also the case when the result is passed through a query.
+func structuredOutput(c *ishell.Context) bool {
+	return *outputFormat != "" || outputTemplate != nil || pipelineOf(c) != nil
+}
+
+// column describes a column of table and csv output. The value of a column
+// is found by following path into each row.
+type column struct {
+	header string
+	path   string
+}
+
+var accountColumns = []column{
+	{"ID", ".id"},
+	{"Name", ".name"},
+	{"Type", ".type"},
+	{"IBAN", ".iban"},
+	{"Balance", ".balance"},
+	{"Currency", ".currency"},
+	{"Balance Date", ".balance_date"},
+}
+
+var transactionColumns = []column{
+	{"ID", ".id"},
+	{"Account", ".user_account.id"},
+	{"Entry Date", ".entry_date"},
+	{"Settlement Date", ".settlement_date"},
+	{"Amount", ".value.value"},
+	{"Currency", ".value.currency"},
+	{"Counterparty", ".counterparty.name"},
+	{"Purpose", ".usage"},
+}
+
+var accessColumns = []column{
+	{"ID", ".id"},
+	{"Name", ".name"},
+	{"Provider", ".provider_id"},
+	{"Enabled", ".enabled"},
+}
+
+var providerColumns = []column{
+	{"ID", ".id"},
+	{"Name", ".name"},
+	{"Country", ".country"},
+	{"Address", ".address"},
+	{"URL", ".url"},
+}
+
+var providerSearchColumns = []column{
+	{"Score", ".score"},
+	{"ID", ".provider.id"},
+	{"Name", ".provider.name"},
+	{"Country", ".provider.country"},
+	{"Address", ".provider.address"},
+}
+
+var jobColumns = []column{
+	{"Finished", ".finished"},
+	{"Stage", ".stage"},
+	{"Errors", ".errors"},
+}
+
+// tableColumns holds the columns used to print the results of commands as
+// a table or csv. Results of other commands use columns derived from the
+// fields of the result.
+var tableColumns = map[string][]column{
+	"accounts":        accountColumns,
+	"getaccount":      accountColumns,
+	"transactions":    transactionColumns,
+	"gettransaction":  transactionColumns,
+	"accesses":        accessColumns,
+	"getaccess":       accessColumns,
+	"updateaccess":    accessColumns,
+	"provider":        providerColumns,
+	"searchproviders": providerSearchColumns,
+	"job":             jobColumns,
+	"stats": {
+		{"Key", ".key"},
+		{"Count", ".count"},
+	},
+	"analyze": {
+		{"Key", ".key"},
+		{"Currency", ".currency"},
+		{"Count", ".count"},
+		{"Inflow", ".inflow"},
+		{"Outflow", ".outflow"},
+		{"Net", ".net"},
+		{"Average", ".average"},
+	},
+	"overview": {
+		{"Access", ".access"},
+		{"Type", ".type"},
+		{"ID", ".id"},
+		{"Account", ".name"},
+		{"IBAN", ".iban"},
+		{"Balance", ".balance"},
+		{"Currency", ".currency"},
+		{"As Of", ".balance_date"},
+		{"Stale", ".stale"},
+		{"Scheduled", ".scheduled"},
+		{"Projected", ".projected"},
+	},
+	"listapps": {
+		{"ID", ".application_id"},
+		{"Label", ".label"},
+	},
+	"listappkeys":  {{"Key", ""}},
+	"createappkey": {{"Key", ""}},
+	"listusers":    {{"User", ""}},
+}
+
+// tabular is implemented by results that are objects but are printed as a
+// list of rows in the list based formats ndjson, csv and table.
+type tabular interface {
+	rows() interface{}
+}
+
+// printResult records v as the result of the current command and prints it
+// in the selected output format, or using the output template if one is set.
+// If the command is followed by a pipeline,
+// the result of the pipeline is recorded and printed instead.
+func printResult(c *ishell.Context, v interface{}) {
+	cmd := c.Cmd.Name
+	if p := pipelineOf(c); p != nil {
+		var err error
+		if v, err = p.apply(v); err != nil {
+			c.Err(validationError(err))
+			return
+		}
+		// The columns of the command no longer fit the reshaped result.
+		cmd = ""
+	}
+	lastResult = v
+
+	var data []byte
+	var err error
+	switch {
+	case outputTemplate != nil:
+		data, err = formatTemplate(outputTemplate, v)
+	case *outputFormat == "" || *outputFormat == "json":
+		data, err = json.MarshalIndent(v, "", "  ")
+	case *outputFormat == "compact":
+		data, err = json.Marshal(v)
+	default:
+		rows := v
+		if t, ok := v.(tabular); ok && *outputFormat != "yaml" {
+			rows = t.rows()
+		}
+		var n interface{}
+		if n, err = normalize(rows); err != nil {
+			break
+		}
+		switch *outputFormat {
+		case "ndjson":
+			data, err = formatNDJSON(n)
+		case "yaml":
+			data, err = yaml.Marshal(yamlValue(n))
+		case "csv":
+			data, err = formatCSV(n, columnsFor(cmd, n))
+		case "table":
+			data, err = formatTable(n, columnsFor(cmd, n))
+		}
+	}
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	c.Println(strings.TrimRight(string(data), "\n"))
+}
+
+// rowsOf returns the rows of a result for list based formats. Lists and
+// objects holding a single list produce one row per element, anything else
+// produces a single row.
+func rowsOf(v interface{}) []interface{} {
+	if list, err := listOf(v); err == nil {
+		return list
+	}
+	return []interface{}{v}
+}
+
+// columnsFor returns the columns to print for the result of a command.
+func columnsFor(cmd string, v interface{}) []column {
+	if cols, ok := tableColumns[cmd]; ok {
+		return cols
+	}
+
+	seen := map[string]bool{}
+	var keys []string
+	for _, row := range rowsOf(v) {
+		m, ok := row.(map[string]interface{})
+		if !ok {
+			return []column{{"Value", ""}}
+		}
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+
+	cols := make([]column, len(keys))
+	for i, k := range keys {
+		cols[i] = column{header: k, path: "." + k}
+	}
+	return cols
+}
+
+// cells returns the formatted values of the columns for a row.
+func cells(row interface{}, cols []column) []string {
+	values := make([]string, len(cols))
+	for i, col := range cols {
+		v, err := lookupPath(row, col.path)
+		if err != nil {
+			continue
+		}
+		values[i] = formatValue(v)
+	}
+	return values
+}
+
+func formatNDJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, row := range rowsOf(v) {
+		data, err := json.Marshal(row)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+func formatCSV(v interface{}, cols []column) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	headers := make([]string, len(cols))
+	for i, col := range cols {
+		headers[i] = col.header
+	}
+	w.Write(headers)
+	for _, row := range rowsOf(v) {
+		w.Write(cells(row, cols))
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func formatTable(v interface{}, cols []column) ([]byte, error) {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	headers := make([]string, len(cols))
+	for i, col := range cols {
+		headers[i] = strings.ToUpper(col.header)
+	}
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+	for _, row := range rowsOf(v) {
+		values := cells(row, cols)
+		for i := range values {
+			values[i] = strings.Replace(values[i], "\n", " ", -1)
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// yamlValue converts the numbers in a normalized value so that they are
+// written as numbers rather than strings.
+func yamlValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case []interface{}:
+		for i := range v {
+			v[i] = yamlValue(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = yamlValue(v[k])
+		}
+	}
+	return v
+}
+
+// settings are values that can be changed with the set command. Scripts,
+// where set defines variables, change them with set -o.
+var settings = map[string]func(string) error{
+	"output":   setOutputFormat,
+	"template": setTemplate,
+}
+
+// applySetting changes a setting and reports whether name is a setting.
+func applySetting(name string, value string) (bool, error) {
+	fn, ok := settings[name]
+	if !ok {
+		return false, nil
+	}
+	return true, fn(value)
+}
+
+func setSetting(c *ishell.Context) {
+	if len(c.Args) > 0 && c.Args[0] == "-o" {
+		c.Args = c.Args[1:]
+	}
+	if len(c.Args) < 1 {
+		names := make([]string, 0, len(settings))
+		for name := range settings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		c.Err(validationErrorf("usage: set NAME value, where NAME is one of %s", strings.Join(names, ", ")))
+		return
+	}
+
+	ok, err := applySetting(c.Args[0], strings.Join(c.Args[1:], " "))
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	if !ok {
+		c.Err(validationErrorf("unknown setting %q", c.Args[0]))
+		return
+	}
+}
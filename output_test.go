@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScriptSettings(t *testing.T) {
+	h := newHarness(t)
+	defer setOutputFormat("")
+	defer setTemplate("none")
+	h.loginUser()
+
+	// Variables named like a setting are rejected instead of changing the
+	// output.
+	for _, script := range []string{"set output /tmp/out.json", "set template x"} {
+		name := strings.Fields(script)[1]
+		_, err := h.runScript(script, true)
+		if want := "test.bosh:1: " + name + " is a setting, use set -o " + name + " value to change it"; err == nil || err.Error() != want {
+			t.Errorf("%q: got %v, want %q", script, err, want)
+		}
+		if exitCode(err) != exitValidation {
+			t.Errorf("%q: got exit code %d, want %d", script, exitCode(err), exitValidation)
+		}
+	}
+	if *outputFormat != "" || outputTemplate != nil {
+		t.Fatalf("got output %q and template %v, want the defaults", *outputFormat, outputTemplate)
+	}
+
+	out, err := h.runScript("set f compact\nset -o output $f\naccounts\nset -o output default", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.contains(out, `{"accounts":[{"id":`)
+	if *outputFormat != "" {
+		t.Errorf("got output %q after set -o output default", *outputFormat)
+	}
+
+	for script, want := range map[string]string{
+		"set -o":            "test.bosh:1: usage: set -o NAME value",
+		"set -o colour red": `test.bosh:1: unknown setting "colour"`,
+		"set -o output xml": `test.bosh:1: unknown output format "xml"`,
+	} {
+		if _, err := h.runScript(script, true); err == nil || !strings.HasPrefix(err.Error(), want) {
+			t.Errorf("%q: got %v, want %q", script, err, want)
+		}
+	}
+
+	// The shell command accepts -o as well.
+	h.mustRun("", "set", "-o", "output", "yaml")
+	if *outputFormat != "yaml" {
+		t.Errorf("got output %q, want yaml", *outputFormat)
+	}
+}
+
+func TestStatsColumns(t *testing.T) {
+	h := newHarness(t)
+	defer setOutputFormat("")
+	h.loginDev()
+
+	h.mustRun("", "set", "output", "table")
+	out := h.mustRun("", "stats", "users")
+	if lines := strings.Split(strings.TrimSpace(out), "\n"); len(lines) != 2 || strings.Fields(lines[0])[0] != "KEY" || strings.Fields(lines[0])[1] != "COUNT" {
+		t.Errorf("got %q, want a key and a count column", out)
+	}
+	if strings.Contains(out, "{") || strings.Contains(out, "[") {
+		t.Errorf("table contains JSON:\n%s", out)
+	}
+	h.mustRun("", "set", "output", "csv")
+	h.contains(h.mustRun("", "stats", "users"), "Key,Count\n")
+}
+
+func TestOutputSetting(t *testing.T) {
+	h := newHarness(t)
+	h.loginUser()
+
+	h.mustRun("", "set", "output", "compact")
+	h.contains(h.mustRun("", "accounts"), `{"accounts":[{"id":`)
+	h.fails(exitValidation, `unknown output format "xml"`, "", "set", "output", "xml")
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"code.bankrs.com/bosgo"
+	"github.com/abiosoft/ishell"
+)
+
+// accountOverview is the result of the overview command.
+type accountOverview struct {
+	Groups []overviewGroup `json:"groups"`
+	Totals []currencyTotal `json:"totals"`
+}
+
+// overviewGroup holds the accounts of one type at a bank access.
+type overviewGroup struct {
+	AccessID   int64             `json:"access_id"`
+	AccessName string            `json:"access_name"`
+	ProviderID string            `json:"provider_id"`
+	Type       string            `json:"type"`
+	Accounts   []overviewAccount `json:"accounts"`
+}
+
+type overviewAccount struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	IBAN        string    `json:"iban"`
+	Currency    string    `json:"currency"`
+	Balance     string    `json:"balance"`
+	BalanceDate time.Time `json:"balance_date"`
+	Stale       bool      `json:"stale"`
+	Scheduled   string    `json:"scheduled,omitempty"`
+	Projected   string    `json:"projected,omitempty"`
+}
+
+// currencyTotal sums the balances of all accounts in a currency.
+type currencyTotal struct {
+	Currency  string `json:"currency"`
+	Balance   string `json:"balance"`
+	Scheduled string `json:"scheduled,omitempty"`
+	Projected string `json:"projected,omitempty"`
+}
+
+// overviewRow is an account or a currency total of an overview in list
+// based output formats.
+type overviewRow struct {
+	Access      string     `json:"access"`
+	Type        string     `json:"type,omitempty"`
+	ID          int64      `json:"id,omitempty"`
+	Name        string     `json:"name,omitempty"`
+	IBAN        string     `json:"iban,omitempty"`
+	Balance     string     `json:"balance"`
+	Currency    string     `json:"currency"`
+	BalanceDate *time.Time `json:"balance_date,omitempty"`
+	Stale       bool       `json:"stale,omitempty"`
+	Scheduled   string     `json:"scheduled,omitempty"`
+	Projected   string     `json:"projected,omitempty"`
+}
+
+// rows lists the accounts followed by the totals for list based output
+// formats.
+func (o *accountOverview) rows() interface{} {
+	rows := []overviewRow{}
+	for _, g := range o.Groups {
+		access := g.AccessName
+		if g.ProviderID != "" {
+			access += " (" + g.ProviderID + ")"
+		}
+		for _, a := range g.Accounts {
+			date := a.BalanceDate
+			rows = append(rows, overviewRow{
+				Access:      access,
+				Type:        g.Type,
+				ID:          a.ID,
+				Name:        a.Name,
+				IBAN:        a.IBAN,
+				Balance:     a.Balance,
+				Currency:    a.Currency,
+				BalanceDate: &date,
+				Stale:       a.Stale,
+				Scheduled:   a.Scheduled,
+				Projected:   a.Projected,
+			})
+		}
+	}
+	for _, t := range o.Totals {
+		rows = append(rows, overviewRow{
+			Access:    "TOTAL",
+			Balance:   t.Balance,
+			Currency:  t.Currency,
+			Scheduled: t.Scheduled,
+			Projected: t.Projected,
+		})
+	}
+	return rows
+}
+
+func overview(c *ishell.Context) {
+	if session.userClient == nil {
+		c.Err(errNoUser)
+		return
+	}
+
+	var scheduled bool
+	var until string
+	var stale time.Duration
+	fs := newCmdFlags("overview")
+	fs.BoolVar(&scheduled, "scheduled", false, "add scheduled transactions to project the balances")
+	fs.StringVar(&until, "until", "", "only add scheduled transactions booked on or before this date (yyyy-mm-dd)")
+	fs.DurationVar(&stale, "stale", 24*time.Hour, "age after which a balance is highlighted as stale")
+	if err := parseCmdFlags(c, fs); err != nil {
+		c.Err(err)
+		return
+	}
+	if until != "" {
+		if _, err := time.Parse("2006-01-02", until); err != nil {
+			c.Err(validationErrorf("expected a date in yyyy-mm-dd format: %v", err))
+			return
+		}
+		scheduled = true
+	}
+
+	accesses, err := session.userClient.Accesses.List().Send()
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	accounts, err := session.userClient.Accounts.List().Send()
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	var pending []bosgo.ScheduledTransaction
+	if scheduled {
+		list, err := session.userClient.ScheduledTransactions.List().Send()
+		if err != nil {
+			c.Err(err)
+			return
+		}
+		for _, t := range list.ScheduledTransactions {
+			if until == "" || t.BookingDate.Format("2006-01-02") <= until {
+				pending = append(pending, t)
+			}
+		}
+	}
+
+	o, err := newAccountOverview(accesses.Accesses, accounts.Accounts, pending, scheduled, time.Now().Add(-stale))
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	if structuredOutput(c) {
+		printResult(c, o)
+		return
+	}
+	lastResult = o
+	c.Print(formatOverview(o, scheduled, stale))
+}
+
+// newAccountOverview groups accounts by access and type. Balances dated
+// before staleBefore are marked as stale. If scheduled is set, the amounts
+// of the pending transactions are added to the balances of their accounts.
+func newAccountOverview(accesses []bosgo.Access, accounts []bosgo.Account, pending []bosgo.ScheduledTransaction, scheduled bool, staleBefore time.Time) (*accountOverview, error) {
+	names := map[int64]bosgo.Access{}
+	for _, a := range accesses {
+		names[a.ID] = a
+	}
+	pendingByAccount := map[int64]int64{}
+	for _, t := range pending {
+		amount, err := parseCents(t.Amount.Value)
+		if err != nil {
+			return nil, fmt.Errorf("scheduled transaction %d: invalid amount %q", t.ID, t.Amount.Value)
+		}
+		pendingByAccount[t.UserAccount.ID] += amount
+	}
+
+	sorted := append([]bosgo.Account(nil), accounts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].BankAccessID != sorted[j].BankAccessID {
+			return sorted[i].BankAccessID < sorted[j].BankAccessID
+		}
+		return sorted[i].Type < sorted[j].Type
+	})
+
+	o := &accountOverview{Groups: []overviewGroup{}, Totals: []currencyTotal{}}
+	type sums struct{ balance, scheduled int64 }
+	totals := map[string]*sums{}
+	var currencies []string
+	for _, acc := range sorted {
+		balance, err := parseCents(acc.Balance)
+		if err != nil {
+			return nil, fmt.Errorf("account %d: invalid balance %q", acc.ID, acc.Balance)
+		}
+		n := len(o.Groups)
+		if n == 0 || o.Groups[n-1].AccessID != acc.BankAccessID || o.Groups[n-1].Type != string(acc.Type) {
+			access := names[acc.BankAccessID]
+			providerID := access.ProviderID
+			if providerID == "" {
+				providerID = acc.ProviderID
+			}
+			o.Groups = append(o.Groups, overviewGroup{
+				AccessID:   acc.BankAccessID,
+				AccessName: access.Name,
+				ProviderID: providerID,
+				Type:       string(acc.Type),
+			})
+			n++
+		}
+		a := overviewAccount{
+			ID:          acc.ID,
+			Name:        acc.Name,
+			IBAN:        acc.IBAN,
+			Currency:    acc.Currency,
+			Balance:     signedCents(balance),
+			BalanceDate: acc.BalanceDate,
+			Stale:       acc.BalanceDate.Before(staleBefore),
+		}
+		if scheduled {
+			a.Scheduled = signedCents(pendingByAccount[acc.ID])
+			a.Projected = signedCents(balance + pendingByAccount[acc.ID])
+		}
+		o.Groups[n-1].Accounts = append(o.Groups[n-1].Accounts, a)
+
+		s, ok := totals[acc.Currency]
+		if !ok {
+			s = &sums{}
+			totals[acc.Currency] = s
+			currencies = append(currencies, acc.Currency)
+		}
+		s.balance += balance
+		s.scheduled += pendingByAccount[acc.ID]
+	}
+
+	sort.Strings(currencies)
+	for _, cur := range currencies {
+		s := totals[cur]
+		t := currencyTotal{Currency: cur, Balance: signedCents(s.balance)}
+		if scheduled {
+			t.Scheduled = signedCents(s.scheduled)
+			t.Projected = signedCents(s.balance + s.scheduled)
+		}
+		o.Totals = append(o.Totals, t)
+	}
+	return o, nil
+}
+
+// formatOverview prints an overview as a table. Accesses and types are only
+// printed on the first row they apply to, stale balances are marked with !.
+func formatOverview(o *accountOverview, scheduled bool, stale time.Duration) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	headers := []string{"ACCESS", "TYPE", "ACCOUNT", "IBAN", "BALANCE", "CURRENCY", "AS OF"}
+	if scheduled {
+		headers = append(headers, "SCHEDULED", "PROJECTED")
+	}
+	fmt.Fprintln(w, strings.Join(headers, "\t"))
+
+	anyStale := false
+	prevAccess := ""
+	for _, g := range o.Groups {
+		access := g.AccessName
+		if g.ProviderID != "" {
+			access += " (" + g.ProviderID + ")"
+		}
+		if access == prevAccess {
+			access = ""
+		} else {
+			prevAccess = access
+		}
+		typ := g.Type
+		for _, a := range g.Accounts {
+			asOf := a.BalanceDate.Format("2006-01-02 15:04")
+			if a.Stale {
+				asOf += " !"
+				anyStale = true
+			}
+			row := []string{access, typ, a.Name, a.IBAN, a.Balance, a.Currency, asOf}
+			if scheduled {
+				row = append(row, a.Scheduled, a.Projected)
+			}
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+			access, typ = "", ""
+		}
+	}
+	for i, t := range o.Totals {
+		label := ""
+		if i == 0 {
+			label = "TOTAL"
+		}
+		row := []string{label, "", "", "", t.Balance, t.Currency, ""}
+		if scheduled {
+			row = append(row, t.Scheduled, t.Projected)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+
+	if anyStale {
+		fmt.Fprintf(&buf, "! balance older than %s, update it with refreshaccess\n", stale)
+	}
+	return buf.String()
+}
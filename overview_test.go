@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOverview(t *testing.T) {
+	h := newHarness(t)
+	h.loginUser()
+
+	out := h.mustRun("", "overview")
+	h.contains(out, "Testbank (DE-TEST)", "Girokonto", "1024.50", "Tagesgeld", "5553.16", "TOTAL", "6577.66  EUR")
+	if strings.Contains(out, "PROJECTED") || strings.Contains(out, "refreshaccess") {
+		t.Errorf("got projections or stale balances without asking for them:\n%s", out)
+	}
+
+	out = h.mustRun("", "overview", "--scheduled", "--stale", "1ns")
+	h.contains(out, "SCHEDULED", "-850.00", "174.50", "5727.66", "! balance older than 1ns, update it with refreshaccess")
+	o := lastResult.(*accountOverview)
+	if len(o.Groups) != 2 || len(o.Totals) != 1 || !o.Groups[0].Accounts[0].Stale {
+		t.Errorf("got %+v, want 2 stale groups and 1 total", o)
+	}
+
+	h.mustRun("", "overview", "--until", "2018-12-01")
+	if total := lastResult.(*accountOverview).Totals[0]; total.Scheduled != "0.00" || total.Projected != "6577.66" {
+		t.Errorf("got %+v, want no scheduled transactions until 2018-12-01", total)
+	}
+	h.fails(exitValidation, "yyyy-mm-dd", "", "overview", "--until", "soon")
+}
+
+func TestOverviewRows(t *testing.T) {
+	h := newHarness(t)
+	defer setOutputFormat("")
+	h.loginUser()
+
+	h.mustRun("", "set", "output", "csv")
+	out := h.mustRun("", "overview", "--scheduled")
+	h.contains(out, "Access,Type,ID,Account,IBAN,Balance,Currency,As Of,Stale,Scheduled,Projected\n",
+		"Testbank (DE-TEST),current,2,Girokonto,DE89370400440532013000,1024.50,EUR,",
+		",-850.00,174.50\n", "TOTAL,,,,,6577.66,EUR,,,-850.00,5727.66\n")
+	if _, ok := lastResult.(*accountOverview); !ok {
+		t.Errorf("got result %T, want the overview", lastResult)
+	}
+	h.mustRun("", "set", "output", "ndjson")
+	if lines := strings.Split(strings.TrimSpace(h.mustRun("", "overview")), "\n"); len(lines) != 3 {
+		t.Errorf("got %d lines, want 2 accounts and a total:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+}
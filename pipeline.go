@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/abiosoft/ishell"
+)
+
+// pipelineKey is the context key holding the pipeline of a command.
+const pipelineKey = "pipeline"
+
+// sourceKey is the context key holding the source of the arguments of a
+// command when it is run by a script.
+const sourceKey = "source"
+
+// stage is a step of a pipeline that post-processes the result of a command
+// before it is printed.
+type stage struct {
+	name string
+	expr string
+	q    query
+}
+
+// pipeline is the list of stages following a command, as in
+//
+//	accounts | query .accounts[] | select(.currency == "EUR") | {id, iban}
+type pipeline []stage
+
+// apply runs the stages of the pipeline on a result.
+func (p pipeline) apply(v interface{}) (interface{}, error) {
+	if len(p) == 0 {
+		return v, nil
+	}
+	n, err := normalize(v)
+	if err != nil {
+		return nil, err
+	}
+	stream := []interface{}{n}
+	for _, s := range p {
+		var out []interface{}
+		for _, x := range stream {
+			r, err := s.q.eval(x)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", s.name, err)
+			}
+			out = append(out, r...)
+		}
+		stream = out
+	}
+	return single(stream), nil
+}
+
+// sourceArgs returns the arguments of a command as they were written, with
+// their quotes and backslashes kept. Scripts pass the source of every
+// command, in interactive mode it is recovered from the line that was read.
+// If the source is not known, as in one-shot mode, the arguments are escaped
+// instead. The result is aligned with c.Args.
+func sourceArgs(c *ishell.Context) []string {
+	if src, ok := c.Get(sourceKey).([]string); ok && len(src) >= len(c.Args) {
+		return src[len(src)-len(c.Args):]
+	}
+	if src := rawSource(c.RawArgs, c.Args); src != nil {
+		return src
+	}
+	src := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		var buf bytes.Buffer
+		for _, ch := range arg {
+			if ch == '\\' || ch == '"' || ch == '\'' {
+				buf.WriteByte('\\')
+			}
+			buf.WriteRune(ch)
+		}
+		src[i] = buf.String()
+	}
+	return src
+}
+
+// rawSource splits the line read by ishell into words and returns the source
+// of the last len(args) of them, or nil if they do not match args.
+func rawSource(raw, args []string) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	words, err := splitWords(strings.Join(raw, " "))
+	if err != nil || len(words) < len(args) {
+		return nil
+	}
+	words = words[len(words)-len(args):]
+	src := make([]string, len(words))
+	for i, w := range words {
+		if w.literal() != args[i] {
+			return nil
+		}
+		src[i] = w.src
+	}
+	return src
+}
+
+// joinSource joins the source of words into the text of an expression in
+// another language. Quotes of kind quote delimit string literals of that
+// language and are kept as written. Other quotes only group words, they are
+// removed like backslashes outside of string literals.
+func joinSource(src []string, quote byte) string {
+	var buf bytes.Buffer
+	for i, s := range src {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		var q byte
+		for j := 0; j < len(s); j++ {
+			ch := s[j]
+			switch {
+			case q == '\'':
+				if ch == '\'' {
+					q = 0
+					if quote == '\'' {
+						buf.WriteByte(ch)
+					}
+					continue
+				}
+				buf.WriteByte(ch)
+			case ch == '\\' && j+1 < len(s) && (q == 0 || strings.IndexByte("\"\\$", s[j+1]) >= 0):
+				j++
+				if q == quote && s[j] != '$' {
+					buf.WriteByte(ch)
+				}
+				buf.WriteByte(s[j])
+			case q == 0 && (ch == '"' || ch == '\''):
+				q = ch
+				if ch == quote {
+					buf.WriteByte(ch)
+				}
+			case q == '"' && ch == '"':
+				q = 0
+				if quote == '"' {
+					buf.WriteByte(ch)
+				}
+			default:
+				buf.WriteByte(ch)
+			}
+		}
+	}
+	return buf.String()
+}
+
+// splitPipeline separates the arguments of a command from the stages that
+// follow it. Stages are separated by a bare |. Since | is also the pipe
+// operator of queries, a segment that does not start with the name of a
+// stage continues the query before it. The expressions of the stages are
+// built from src, the source of args, so that string literals keep their
+// double quotes.
+func splitPipeline(args, src []string) ([]string, pipeline, error) {
+	var segments [][]string
+	start := 0
+	for i, arg := range args {
+		if arg == "|" && src[i] == "|" {
+			segments = append(segments, src[start:i])
+			start = i + 1
+		}
+	}
+	if segments == nil {
+		return args, nil, nil
+	}
+	segments = append(segments, src[start:])
+
+	var p pipeline
+	for _, seg := range segments[1:] {
+		if len(seg) > 0 && seg[0] == "query" {
+			p = append(p, stage{name: "query", expr: joinSource(seg[1:], '"')})
+			continue
+		}
+		if len(p) == 0 {
+			if len(seg) == 0 {
+				return nil, nil, fmt.Errorf("missing pipeline stage after |")
+			}
+			return nil, nil, fmt.Errorf("unknown pipeline stage %q", seg[0])
+		}
+		last := &p[len(p)-1]
+		last.expr += " | " + joinSource(seg, '"')
+	}
+
+	for i := range p {
+		if strings.TrimSpace(p[i].expr) == "" {
+			return nil, nil, fmt.Errorf("usage: query EXPRESSION")
+		}
+		q, err := compileQuery(p[i].expr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", p[i].name, err)
+		}
+		p[i].q = q
+	}
+	return args[:len(segments[0])], p, nil
+}
+
+// withPipelines wraps the functions of cmds and their subcommands so that
+// the pipeline following a command is removed from its arguments and made
+// available to printResult.
+func withPipelines(cmds []*ishell.Cmd) {
+	for _, cmd := range cmds {
+		if cmd.Func != nil {
+			cmd.Func = withPipeline(cmd.Func)
+		}
+		withPipelines(cmd.Children())
+	}
+}
+
+func withPipeline(fn func(*ishell.Context)) func(*ishell.Context) {
+	return func(c *ishell.Context) {
+		src := sourceArgs(c)
+		args, p, err := splitPipeline(c.Args, src)
+		if err != nil {
+			c.Err(syntaxError(err))
+			return
+		}
+		if p != nil {
+			c.Args = args
+			c.Set(sourceKey, src[:len(args)])
+			c.Set(pipelineKey, p)
+		}
+		fn(c)
+	}
+}
+
+// pipelineOf returns the pipeline of the current command, if any.
+func pipelineOf(c *ishell.Context) pipeline {
+	p, _ := c.Get(pipelineKey).(pipeline)
+	return p
+}
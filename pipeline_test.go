@@ -0,0 +1,137 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/abiosoft/ishell"
+)
+
+func TestPipeline(t *testing.T) {
+	h := newHarness(t)
+	h.loginUser()
+
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{
+			name:   "readme example",
+			script: `accounts | query .accounts[] | select(.currency == "EUR") | {id, iban, balance}`,
+			want:   "[\n  {\n    \"balance\": \"1024.50\",\n    \"iban\": \"DE89370400440532013000\",\n    \"id\": 2\n  },\n  {\n    \"balance\": \"5553.16\",\n    \"iban\": \"DE75512108001245126199\",\n    \"id\": 11\n  }\n]\n",
+		},
+		{
+			name:   "quoted expression",
+			script: `accounts | query '.accounts[] | select(.name == "Tagesgeld") | .id'`,
+			want:   "11\n",
+		},
+		{
+			name:   "variable in string literal",
+			script: "set name Girokonto\naccounts | query .accounts[] | select(.name == \"$name\") | .id",
+			want:   "2\n",
+		},
+		{
+			name:   "arithmetic",
+			script: `accounts | query .accounts[] | select(.balance - 2000 > 0) | .balance - 553.16`,
+			want:   "5000\n",
+		},
+		{
+			name:   "let",
+			script: "let ids = accounts | query '[.accounts[] | select(.currency == \"EUR\") | .id]'\necho ${ids[1]}",
+			want:   "[\n  2,\n  11\n]\n11\n",
+		},
+		{
+			name:   "quoted pipe is an argument",
+			script: `accounts | query .accounts[0] | .name + "|" + .currency`,
+			want:   "\"Girokonto|EUR\"\n",
+		},
+		{
+			name:   "escaped quotes",
+			script: `accounts | query .accounts[0].name + "\"" | length`,
+			want:   "10\n",
+		},
+	}
+	for _, tt := range tests {
+		out, err := h.runScript(tt.script+"\n", true)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if out != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, out, tt.want)
+		}
+	}
+}
+
+func TestPipelineInteractive(t *testing.T) {
+	// In interactive mode ishell has already removed the quotes from the
+	// arguments, so the expression is rebuilt from the raw line.
+	line := `accounts | query .accounts[] | select(.currency == "EUR") | {id, iban, balance}`
+	c := &ishell.Context{
+		Args:    []string{"|", "query", ".accounts[]", "|", "select(.currency", "==", "EUR)", "|", "{id,", "iban,", "balance}"},
+		RawArgs: strings.Fields(line),
+	}
+	args, p, err := splitPipeline(c.Args, sourceArgs(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 0 || len(p) != 1 {
+		t.Fatalf("got %q, %#v, want a single stage", args, p)
+	}
+	if want := `.accounts[] | select(.currency == "EUR") | {id, iban, balance}`; p[0].expr != want {
+		t.Errorf("got expression %q, want %q", p[0].expr, want)
+	}
+
+	// Without the source, as in one-shot mode, the arguments are used as they are.
+	c = &ishell.Context{Args: []string{"-n", "2", "|", "query", `.name == "it's"`}}
+	args, p, err = splitPipeline(c.Args, sourceArgs(c))
+	if err != nil || !reflect.DeepEqual(args, []string{"-n", "2"}) || len(p) != 1 || p[0].expr != `.name == "it's"` {
+		t.Errorf("got %q, %#v, %v", args, p, err)
+	}
+
+	// A raw line that does not match the arguments is ignored.
+	c = &ishell.Context{Args: []string{"a", "b"}, RawArgs: []string{"x", "'a'", "c"}}
+	if src := sourceArgs(c); !reflect.DeepEqual(src, c.Args) {
+		t.Errorf("got source %q, want %q", src, c.Args)
+	}
+}
+
+func TestJoinSource(t *testing.T) {
+	tests := []struct {
+		src   []string
+		quote byte
+		want  string
+	}{
+		{[]string{`.a`, `==`, `"EUR"`}, '"', `.a == "EUR"`},
+		{[]string{`'.a | select(.b == "x")'`}, '"', `.a | select(.b == "x")`},
+		{[]string{`"a\"b"`, `"\$x"`, `a\ b`}, '"', `"a\"b" "$x" a b`},
+		{[]string{`select`, `*`, `where`, `iban`, `like`, `'DE%'`}, '\'', `select * where iban like 'DE%'`},
+		{[]string{`"select * where iban like 'DE%'"`}, '\'', `select * where iban like 'DE%'`},
+		{[]string{`"say \"hi\""`}, '\'', `say "hi"`},
+	}
+	for _, tt := range tests {
+		if got := joinSource(tt.src, tt.quote); got != tt.want {
+			t.Errorf("joinSource(%q, %c) = %q, want %q", tt.src, tt.quote, got, tt.want)
+		}
+	}
+}
+
+func TestPipelineErrors(t *testing.T) {
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"|"}, "missing pipeline stage after |"},
+		{[]string{"|", "sort"}, `unknown pipeline stage "sort"`},
+		{[]string{"|", "query"}, "usage: query EXPRESSION"},
+		{[]string{"|", "query", ".[", "|", "x"}, "query: "},
+	}
+	for _, tt := range tests {
+		_, _, err := splitPipeline(tt.args, tt.args)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: got error %v, want %q", tt.args, err, tt.err)
+		}
+	}
+}
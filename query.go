@@ -0,0 +1,1226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// This file implements a small subset of the jq query language that is used
+// to filter and reshape command results. Queries operate on normalized
+// values and produce a stream of results. Supported are
+//
+//	.  .foo  .foo.bar  .[2]  .[1:3]  .[]  .foo?      paths and iteration
+//	a | b                                           pipes
+//	a, b                                            multiple outputs
+//	[ a ]  { name, iban: .account.iban }            array and object construction
+//	+ - * / %  -x                                   arithmetic
+//	== != < <= > >=  and  or                        comparisons and logic
+//	"str"  123  true  false  null                   literals
+//	length keys first last not sort tonumber tostring
+//	ascii_downcase ascii_upcase add
+//	select(f) map(f) sort_by(f) test(re) contains(s) has(key)
+
+// query is a compiled query expression.
+type query interface {
+	eval(v interface{}) ([]interface{}, error)
+}
+
+// compileQuery parses a query expression.
+func compileQuery(expr string) (query, error) {
+	toks, err := lexQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{toks: toks}
+	q, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q in query", p.peek().text)
+	}
+	return q, nil
+}
+
+// evalQuery runs a query against v. A single result is returned as is,
+// multiple results are collected into a list.
+func evalQuery(expr string, v interface{}) (interface{}, error) {
+	q, err := compileQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	out, err := q.eval(v)
+	if err != nil {
+		return nil, err
+	}
+	return single(out), nil
+}
+
+// single turns the results of a query into a single value.
+func single(out []interface{}) interface{} {
+	if len(out) == 1 {
+		return out[0]
+	}
+	if out == nil {
+		return []interface{}{}
+	}
+	return out
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind tokKind
+	text string
+}
+
+func lexQuery(s string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(s); {
+		ch := s[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+
+		case ch == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string in query")
+			}
+			str, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s in query", s[i:j+1])
+			}
+			toks = append(toks, token{tokString, str})
+			i = j + 1
+
+		case ch >= '0' && ch <= '9':
+			j := i
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{tokNumber, s[i:j]})
+			i = j
+
+		case ch == '_' || unicode.IsLetter(rune(ch)):
+			j := i
+			for j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, s[i:j]})
+			i = j
+
+		default:
+			if i+1 < len(s) {
+				switch two := s[i : i+2]; two {
+				case "==", "!=", "<=", ">=":
+					toks = append(toks, token{tokPunct, two})
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune(".[]{}()|,:;<>?+-*/%", rune(ch)) {
+				return nil, fmt.Errorf("unexpected %q in query", ch)
+			}
+			toks = append(toks, token{tokPunct, string(ch)})
+			i++
+		}
+	}
+	return toks, nil
+}
+
+type queryParser struct {
+	toks []token
+	pos  int
+}
+
+func (p *queryParser) peek() token {
+	if p.pos >= len(p.toks) {
+		return token{kind: tokEOF}
+	}
+	return p.toks[p.pos]
+}
+
+func (p *queryParser) next() token {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *queryParser) accept(punct string) bool {
+	if t := p.peek(); (t.kind == tokPunct || t.kind == tokIdent) && t.text == punct {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) expect(punct string) error {
+	if !p.accept(punct) {
+		if t := p.peek(); t.kind != tokEOF {
+			return fmt.Errorf("expected %q but found %q in query", punct, t.text)
+		}
+		return fmt.Errorf("expected %q at end of query", punct)
+	}
+	return nil
+}
+
+func (p *queryParser) parsePipe() (query, error) {
+	left, err := p.parseComma()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("|") {
+		right, err := p.parseComma()
+		if err != nil {
+			return nil, err
+		}
+		left = pipeQuery{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseComma() (query, error) {
+	left, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(",") {
+		right, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		left = commaQuery{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseOr() (query, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryQuery{"or", left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (query, error) {
+	left, err := p.parseCompare()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and") {
+		right, err := p.parseCompare()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryQuery{"and", left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseCompare() (query, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.accept(op) {
+			right, err := p.parseAdditive()
+			if err != nil {
+				return nil, err
+			}
+			return binaryQuery{op, left, right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAdditive() (query, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek().text
+		if p.peek().kind != tokPunct || op != "+" && op != "-" {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = arithQuery{op, left, right}
+	}
+}
+
+func (p *queryParser) parseMultiplicative() (query, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek().text
+		if p.peek().kind != tokPunct || op != "*" && op != "/" && op != "%" {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = arithQuery{op, left, right}
+	}
+}
+
+func (p *queryParser) parseUnary() (query, error) {
+	if t := p.peek(); t.kind == tokPunct && t.text == "-" {
+		p.next()
+		if n := p.peek(); n.kind == tokNumber {
+			p.next()
+			return literalQuery{json.Number("-" + n.text)}, nil
+		}
+		q, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return arithQuery{"-", literalQuery{json.Number("0")}, q}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *queryParser) parsePostfix() (query, error) {
+	q, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.peek().text == "." && p.peek().kind == tokPunct && p.pos+1 < len(p.toks) && (p.toks[p.pos+1].kind == tokIdent || p.toks[p.pos+1].kind == tokString):
+			p.next()
+			t := p.next()
+			q = pipeQuery{q, fieldQuery(t.text)}
+		case p.accept("["):
+			s, err := p.parseSubscript()
+			if err != nil {
+				return nil, err
+			}
+			q = pipeQuery{q, s}
+		case p.accept("?"):
+			q = tryQuery{q}
+		default:
+			return q, nil
+		}
+	}
+}
+
+// parseSubscript parses the part of an index, slice or iteration after the
+// opening bracket.
+func (p *queryParser) parseSubscript() (query, error) {
+	if p.accept("]") {
+		return iterQuery{}, nil
+	}
+	var from, to query
+	var err error
+	if p.peek().text != ":" {
+		if from, err = p.parsePipe(); err != nil {
+			return nil, err
+		}
+	}
+	if p.accept(":") {
+		if p.peek().text != "]" {
+			if to, err = p.parsePipe(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return sliceQuery{from, to}, nil
+	}
+	if err := p.expect("]"); err != nil {
+		return nil, err
+	}
+	return indexQuery{from}, nil
+}
+
+func (p *queryParser) parsePrimary() (query, error) {
+	t := p.next()
+	switch t.kind {
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of query")
+
+	case tokNumber:
+		return literalQuery{json.Number(t.text)}, nil
+
+	case tokString:
+		return literalQuery{t.text}, nil
+
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return literalQuery{true}, nil
+		case "false":
+			return literalQuery{false}, nil
+		case "null":
+			return literalQuery{nil}, nil
+		}
+		var args []query
+		if p.accept("(") {
+			for {
+				arg, err := p.parsePipe()
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, arg)
+				if p.accept(")") {
+					break
+				}
+				if err := p.expect(";"); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return newFuncQuery(t.text, args)
+	}
+
+	switch t.text {
+	case ".":
+		if n := p.peek(); n.kind == tokIdent || n.kind == tokString {
+			p.next()
+			return fieldQuery(n.text), nil
+		}
+		return identityQuery{}, nil
+
+	case "(":
+		q, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		return q, p.expect(")")
+
+	case "[":
+		if p.accept("]") {
+			return collectQuery{nil}, nil
+		}
+		q, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		return collectQuery{q}, p.expect("]")
+
+	case "{":
+		return p.parseObject()
+	}
+	return nil, fmt.Errorf("unexpected %q in query", t.text)
+}
+
+func (p *queryParser) parseObject() (query, error) {
+	var obj objectQuery
+	if p.accept("}") {
+		return obj, nil
+	}
+	for {
+		t := p.next()
+		if t.kind != tokIdent && t.kind != tokString {
+			return nil, fmt.Errorf("expected field name in object but found %q", t.text)
+		}
+		field := objectField{name: t.text, value: fieldQuery(t.text)}
+		if p.accept(":") {
+			v, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			field.value = v
+		}
+		obj = append(obj, field)
+		if p.accept("}") {
+			return obj, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type identityQuery struct{}
+
+func (identityQuery) eval(v interface{}) ([]interface{}, error) {
+	return []interface{}{v}, nil
+}
+
+type literalQuery struct {
+	value interface{}
+}
+
+func (q literalQuery) eval(v interface{}) ([]interface{}, error) {
+	return []interface{}{q.value}, nil
+}
+
+type fieldQuery string
+
+func (q fieldQuery) eval(v interface{}) ([]interface{}, error) {
+	switch v := v.(type) {
+	case nil:
+		return []interface{}{nil}, nil
+	case map[string]interface{}:
+		return []interface{}{v[string(q)]}, nil
+	}
+	return nil, fmt.Errorf("cannot get field %q of %s", string(q), typeName(v))
+}
+
+type indexQuery struct {
+	index query
+}
+
+func (q indexQuery) eval(v interface{}) ([]interface{}, error) {
+	idxs, err := q.index.eval(v)
+	if err != nil {
+		return nil, err
+	}
+	var out []interface{}
+	for _, idx := range idxs {
+		switch idx := idx.(type) {
+		case string:
+			r, err := fieldQuery(idx).eval(v)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, r...)
+		case json.Number:
+			n, err := idx.Int64()
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %s", idx)
+			}
+			switch v := v.(type) {
+			case nil:
+				out = append(out, nil)
+			case []interface{}:
+				i := int(n)
+				if i < 0 {
+					i += len(v)
+				}
+				if i < 0 || i >= len(v) {
+					out = append(out, nil)
+				} else {
+					out = append(out, v[i])
+				}
+			default:
+				return nil, fmt.Errorf("cannot index %s with a number", typeName(v))
+			}
+		default:
+			return nil, fmt.Errorf("cannot index with %s", typeName(idx))
+		}
+	}
+	return out, nil
+}
+
+type sliceQuery struct {
+	from, to query
+}
+
+func (q sliceQuery) eval(v interface{}) ([]interface{}, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		if v == nil {
+			return []interface{}{nil}, nil
+		}
+		return nil, fmt.Errorf("cannot slice %s", typeName(v))
+	}
+	bound := func(b query, def int) (int, error) {
+		if b == nil {
+			return def, nil
+		}
+		out, err := b.eval(v)
+		if err != nil {
+			return 0, err
+		}
+		if len(out) != 1 {
+			return 0, fmt.Errorf("slice bound must be a single number")
+		}
+		n, ok := toNumber(out[0])
+		if !ok {
+			return 0, fmt.Errorf("slice bound must be a number")
+		}
+		i := int(n)
+		if i < 0 {
+			i += len(list)
+		}
+		if i < 0 {
+			i = 0
+		}
+		if i > len(list) {
+			i = len(list)
+		}
+		return i, nil
+	}
+	from, err := bound(q.from, 0)
+	if err != nil {
+		return nil, err
+	}
+	to, err := bound(q.to, len(list))
+	if err != nil {
+		return nil, err
+	}
+	if to < from {
+		to = from
+	}
+	return []interface{}{list[from:to]}, nil
+}
+
+type iterQuery struct{}
+
+func (iterQuery) eval(v interface{}) ([]interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		return v, nil
+	case map[string]interface{}:
+		keys := sortedKeys(v)
+		out := make([]interface{}, len(keys))
+		for i, k := range keys {
+			out[i] = v[k]
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("cannot iterate over %s", typeName(v))
+}
+
+type tryQuery struct {
+	q query
+}
+
+func (q tryQuery) eval(v interface{}) ([]interface{}, error) {
+	out, err := q.q.eval(v)
+	if err != nil {
+		return nil, nil
+	}
+	return out, nil
+}
+
+type pipeQuery struct {
+	left, right query
+}
+
+func (q pipeQuery) eval(v interface{}) ([]interface{}, error) {
+	in, err := q.left.eval(v)
+	if err != nil {
+		return nil, err
+	}
+	var out []interface{}
+	for _, x := range in {
+		r, err := q.right.eval(x)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r...)
+	}
+	return out, nil
+}
+
+type commaQuery struct {
+	left, right query
+}
+
+func (q commaQuery) eval(v interface{}) ([]interface{}, error) {
+	l, err := q.left.eval(v)
+	if err != nil {
+		return nil, err
+	}
+	r, err := q.right.eval(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(l, r...), nil
+}
+
+type collectQuery struct {
+	q query
+}
+
+func (q collectQuery) eval(v interface{}) ([]interface{}, error) {
+	if q.q == nil {
+		return []interface{}{[]interface{}{}}, nil
+	}
+	out, err := q.q.eval(v)
+	if err != nil {
+		return nil, err
+	}
+	if out == nil {
+		out = []interface{}{}
+	}
+	return []interface{}{out}, nil
+}
+
+type objectField struct {
+	name  string
+	value query
+}
+
+type objectQuery []objectField
+
+func (q objectQuery) eval(v interface{}) ([]interface{}, error) {
+	obj := make(map[string]interface{}, len(q))
+	for _, f := range q {
+		out, err := f.value.eval(v)
+		if err != nil {
+			return nil, err
+		}
+		switch len(out) {
+		case 0:
+			return nil, nil
+		case 1:
+			obj[f.name] = out[0]
+		default:
+			obj[f.name] = out
+		}
+	}
+	return []interface{}{obj}, nil
+}
+
+type binaryQuery struct {
+	op          string
+	left, right query
+}
+
+func (q binaryQuery) eval(v interface{}) ([]interface{}, error) {
+	ls, err := q.left.eval(v)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := q.right.eval(v)
+	if err != nil {
+		return nil, err
+	}
+	var out []interface{}
+	for _, l := range ls {
+		for _, r := range rs {
+			var res bool
+			switch q.op {
+			case "and":
+				res = truthy(l) && truthy(r)
+			case "or":
+				res = truthy(l) || truthy(r)
+			case "==":
+				res = compareValues(l, r) == 0
+			case "!=":
+				res = compareValues(l, r) != 0
+			case "<":
+				res = compareValues(l, r) < 0
+			case "<=":
+				res = compareValues(l, r) <= 0
+			case ">":
+				res = compareValues(l, r) > 0
+			case ">=":
+				res = compareValues(l, r) >= 0
+			}
+			out = append(out, res)
+		}
+	}
+	return out, nil
+}
+
+// arithQuery applies an arithmetic operator to every combination of the
+// results of its operands.
+type arithQuery struct {
+	op          string
+	left, right query
+}
+
+func (q arithQuery) eval(v interface{}) ([]interface{}, error) {
+	ls, err := q.left.eval(v)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := q.right.eval(v)
+	if err != nil {
+		return nil, err
+	}
+	var out []interface{}
+	for _, l := range ls {
+		for _, r := range rs {
+			res, err := arith(q.op, l, r)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, res)
+		}
+	}
+	return out, nil
+}
+
+// arith computes a op b. As in jq, null is the neutral element of +, which
+// also concatenates strings and arrays and merges objects, and - removes the
+// elements of b from the array a. Otherwise both operands must be numbers or
+// numeric strings.
+func arith(op string, a, b interface{}) (interface{}, error) {
+	if op == "+" {
+		switch {
+		case a == nil:
+			return b, nil
+		case b == nil:
+			return a, nil
+		}
+		switch a := a.(type) {
+		case string:
+			if s, ok := b.(string); ok {
+				return a + s, nil
+			}
+		case []interface{}:
+			if l, ok := b.([]interface{}); ok {
+				return append(append([]interface{}{}, a...), l...), nil
+			}
+		case map[string]interface{}:
+			if m, ok := b.(map[string]interface{}); ok {
+				res := make(map[string]interface{}, len(a)+len(m))
+				for k, v := range a {
+					res[k] = v
+				}
+				for k, v := range m {
+					res[k] = v
+				}
+				return res, nil
+			}
+		}
+	}
+	if op == "-" {
+		if a, ok := a.([]interface{}); ok {
+			l, ok := b.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("cannot subtract %s from an array", typeName(b))
+			}
+			res := []interface{}{}
+			for _, x := range a {
+				found := false
+				for _, y := range l {
+					if compareValues(x, y) == 0 {
+						found = true
+						break
+					}
+				}
+				if !found {
+					res = append(res, x)
+				}
+			}
+			return res, nil
+		}
+	}
+
+	x, okx := toNumber(a)
+	y, oky := toNumber(b)
+	if !okx || !oky {
+		return nil, fmt.Errorf("cannot compute %s %s %s", typeName(a), op, typeName(b))
+	}
+	var res float64
+	switch op {
+	case "+":
+		res = x + y
+	case "-":
+		res = x - y
+	case "*":
+		res = x * y
+	case "/":
+		if y == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		res = x / y
+	case "%":
+		if int64(y) == 0 {
+			return nil, fmt.Errorf("modulo by zero")
+		}
+		res = float64(int64(x) % int64(y))
+	}
+	return json.Number(strconv.FormatFloat(res, 'f', -1, 64)), nil
+}
+
+type funcQuery struct {
+	name string
+	args []query
+	fn   func(v interface{}, args []query) ([]interface{}, error)
+}
+
+func (q funcQuery) eval(v interface{}) ([]interface{}, error) {
+	out, err := q.fn(v, q.args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", q.name, err)
+	}
+	return out, nil
+}
+
+type queryFunc struct {
+	nargs int
+	fn    func(v interface{}, args []query) ([]interface{}, error)
+}
+
+var queryFuncs map[string]queryFunc
+
+func init() {
+	queryFuncs = map[string]queryFunc{
+		"length":         {0, qLength},
+		"keys":           {0, qKeys},
+		"first":          {0, qFirst},
+		"last":           {0, qLast},
+		"not":            {0, qNot},
+		"sort":           {0, qSort},
+		"add":            {0, qAdd},
+		"tonumber":       {0, qToNumber},
+		"tostring":       {0, qToString},
+		"ascii_downcase": {0, qDowncase},
+		"ascii_upcase":   {0, qUpcase},
+		"select":         {1, qSelect},
+		"map":            {1, qMap},
+		"sort_by":        {1, qSortBy},
+		"test":           {1, qTest},
+		"contains":       {1, qContains},
+		"has":            {1, qHas},
+	}
+}
+
+func newFuncQuery(name string, args []query) (query, error) {
+	f, ok := queryFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s in query", name)
+	}
+	if len(args) != f.nargs {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", name, f.nargs, len(args))
+	}
+	return funcQuery{name: name, args: args, fn: f.fn}, nil
+}
+
+func qLength(v interface{}, _ []query) ([]interface{}, error) {
+	var n int
+	switch v := v.(type) {
+	case nil:
+	case string:
+		n = len([]rune(v))
+	case []interface{}:
+		n = len(v)
+	case map[string]interface{}:
+		n = len(v)
+	case json.Number:
+		return []interface{}{v}, nil
+	default:
+		return nil, fmt.Errorf("%s has no length", typeName(v))
+	}
+	return []interface{}{json.Number(strconv.Itoa(n))}, nil
+}
+
+func qKeys(v interface{}, _ []query) ([]interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		var out []interface{}
+		for _, k := range sortedKeys(v) {
+			out = append(out, k)
+		}
+		return []interface{}{out}, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = json.Number(strconv.Itoa(i))
+		}
+		return []interface{}{out}, nil
+	}
+	return nil, fmt.Errorf("%s has no keys", typeName(v))
+}
+
+func qFirst(v interface{}, _ []query) ([]interface{}, error) {
+	return indexQuery{literalQuery{json.Number("0")}}.eval(v)
+}
+
+func qLast(v interface{}, _ []query) ([]interface{}, error) {
+	return indexQuery{literalQuery{json.Number("-1")}}.eval(v)
+}
+
+func qNot(v interface{}, _ []query) ([]interface{}, error) {
+	return []interface{}{!truthy(v)}, nil
+}
+
+func qSort(v interface{}, _ []query) ([]interface{}, error) {
+	return qSortBy(v, []query{identityQuery{}})
+}
+
+func qAdd(v interface{}, _ []query) ([]interface{}, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot add the elements of %s", typeName(v))
+	}
+	var sum float64
+	for _, x := range list {
+		n, ok := toNumber(x)
+		if !ok {
+			return nil, fmt.Errorf("cannot add %s", typeName(x))
+		}
+		sum += n
+	}
+	return []interface{}{json.Number(strconv.FormatFloat(sum, 'f', -1, 64))}, nil
+}
+
+func qToNumber(v interface{}, _ []query) ([]interface{}, error) {
+	n, ok := toNumber(v)
+	if !ok {
+		return nil, fmt.Errorf("cannot convert %s to a number", typeName(v))
+	}
+	return []interface{}{json.Number(strconv.FormatFloat(n, 'f', -1, 64))}, nil
+}
+
+func qToString(v interface{}, _ []query) ([]interface{}, error) {
+	return []interface{}{formatValue(v)}, nil
+}
+
+func qDowncase(v interface{}, _ []query) ([]interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a string", typeName(v))
+	}
+	return []interface{}{strings.ToLower(s)}, nil
+}
+
+func qUpcase(v interface{}, _ []query) ([]interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a string", typeName(v))
+	}
+	return []interface{}{strings.ToUpper(s)}, nil
+}
+
+func qSelect(v interface{}, args []query) ([]interface{}, error) {
+	conds, err := args[0].eval(v)
+	if err != nil {
+		return nil, err
+	}
+	var out []interface{}
+	for _, c := range conds {
+		if truthy(c) {
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+func qMap(v interface{}, args []query) ([]interface{}, error) {
+	return collectQuery{pipeQuery{iterQuery{}, args[0]}}.eval(v)
+}
+
+func qSortBy(v interface{}, args []query) ([]interface{}, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot sort %s", typeName(v))
+	}
+	keys := make([]interface{}, len(list))
+	for i, x := range list {
+		out, err := args[0].eval(x)
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > 0 {
+			keys[i] = out[0]
+		}
+	}
+	idx := make([]int, len(list))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return compareValues(keys[idx[i]], keys[idx[j]]) < 0
+	})
+	sorted := make([]interface{}, len(list))
+	for i, j := range idx {
+		sorted[i] = list[j]
+	}
+	return []interface{}{sorted}, nil
+}
+
+func qTest(v interface{}, args []query) ([]interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a string", typeName(v))
+	}
+	pats, err := args[0].eval(v)
+	if err != nil {
+		return nil, err
+	}
+	var out []interface{}
+	for _, p := range pats {
+		re, err := regexp.Compile(formatValue(p))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, re.MatchString(s))
+	}
+	return out, nil
+}
+
+func qContains(v interface{}, args []query) ([]interface{}, error) {
+	subs, err := args[0].eval(v)
+	if err != nil {
+		return nil, err
+	}
+	var out []interface{}
+	for _, sub := range subs {
+		switch v := v.(type) {
+		case string:
+			out = append(out, strings.Contains(v, formatValue(sub)))
+		case []interface{}:
+			found := false
+			for _, x := range v {
+				if compareValues(x, sub) == 0 {
+					found = true
+					break
+				}
+			}
+			out = append(out, found)
+		default:
+			return nil, fmt.Errorf("%s cannot contain values", typeName(v))
+		}
+	}
+	return out, nil
+}
+
+func qHas(v interface{}, args []query) ([]interface{}, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s has no keys", typeName(v))
+	}
+	keys, err := args[0].eval(v)
+	if err != nil {
+		return nil, err
+	}
+	var out []interface{}
+	for _, k := range keys {
+		_, ok := m[formatValue(k)]
+		out = append(out, ok)
+	}
+	return out, nil
+}
+
+// truthy reports whether a value counts as true in a query. Only false and
+// null are false.
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+// toNumber converts numbers and numeric strings to float64. Amounts are
+// often represented as strings, so they are compared as numbers.
+func toNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// compareValues orders two values. Numbers, including numeric strings, are
+// compared numerically. Values of different types are ordered as in jq:
+// null, false, true, numbers, strings, arrays, objects.
+func compareValues(a, b interface{}) int {
+	_, aNum := a.(json.Number)
+	_, bNum := b.(json.Number)
+	if aNum || bNum {
+		x, okx := toNumber(a)
+		y, oky := toNumber(b)
+		if okx && oky {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+
+	switch a := a.(type) {
+	case bool:
+		if a == b.(bool) {
+			return 0
+		}
+		if !a {
+			return -1
+		}
+		return 1
+	case string:
+		return strings.Compare(a, b.(string))
+	case []interface{}:
+		bl := b.([]interface{})
+		for i := 0; i < len(a) && i < len(bl); i++ {
+			if c := compareValues(a[i], bl[i]); c != 0 {
+				return c
+			}
+		}
+		return len(a) - len(bl)
+	case map[string]interface{}:
+		return strings.Compare(formatValue(a), formatValue(b))
+	}
+	return 0
+}
+
+func typeRank(v interface{}) int {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		if !v {
+			return 1
+		}
+		return 2
+	case json.Number:
+		return 3
+	case string:
+		return 4
+	case []interface{}:
+		return 5
+	}
+	return 6
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
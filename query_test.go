@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const queryInput = `{
+	"accounts": [
+		{"id": 2, "name": "Girokonto", "currency": "EUR", "balance": "1024.50", "tags": ["main"]},
+		{"id": 11, "name": "Tagesgeld", "currency": "EUR", "balance": "5553.16", "tags": []},
+		{"id": 12, "name": "Travel", "currency": "USD", "balance": "-20", "limit": null}
+	],
+	"total": 3
+}`
+
+func TestQuery(t *testing.T) {
+	dec := json.NewDecoder(strings.NewReader(queryInput))
+	dec.UseNumber()
+	var input interface{}
+	if err := dec.Decode(&input); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr string
+		want string
+		err  string
+	}{
+		// paths
+		{expr: ".", want: `{"accounts":[{"balance":"1024.50","currency":"EUR","id":2,"name":"Girokonto","tags":["main"]},{"balance":"5553.16","currency":"EUR","id":11,"name":"Tagesgeld","tags":[]},{"balance":"-20","currency":"USD","id":12,"limit":null,"name":"Travel"}],"total":3}`},
+		{expr: ".total", want: `3`},
+		{expr: ".accounts[0].name", want: `"Girokonto"`},
+		{expr: `.accounts[-1]."name"`, want: `"Travel"`},
+		{expr: ".accounts[1:].id", err: `cannot get field "id" of array`},
+		{expr: "[.accounts[1:][].id]", want: `[11,12]`},
+		{expr: ".missing", want: `null`},
+		{expr: ".accounts[0].tags[0]", want: `"main"`},
+		{expr: ".accounts[5]", want: `null`},
+		{expr: ".total.x", err: `cannot get field "x" of number`},
+		{expr: ".total.x?", want: ``},
+
+		// iteration, pipes and construction
+		{expr: ".accounts[].id", want: `2 11 12`},
+		{expr: ".accounts[] | .name", want: `"Girokonto" "Tagesgeld" "Travel"`},
+		{expr: ".accounts[0] | .id, .name", want: `2 "Girokonto"`},
+		{expr: ".accounts[0] | {id, iban: .name}", want: `{"iban":"Girokonto","id":2}`},
+		{expr: ".total[]", err: "cannot iterate over number"},
+
+		// select and comparisons
+		{expr: `.accounts[] | select(.currency == "EUR") | .id`, want: `2 11`},
+		{expr: `.accounts[] | select(.currency != "EUR") | .id`, want: `12`},
+		{expr: `.accounts[] | select(.balance > 2000) | .id`, want: `11`},
+		{expr: `.accounts[] | select(.balance >= 1024.5) | .id`, want: `2 11`},
+		{expr: `.accounts[] | select(.balance < 0) | .id`, want: `12`},
+		{expr: `.accounts[] | select(.id <= 11 and .currency == "EUR") | .name`, want: `"Girokonto" "Tagesgeld"`},
+		{expr: `.accounts[] | select(.id == 2 or .id == 12) | .name`, want: `"Girokonto" "Travel"`},
+		{expr: `.accounts[] | select(.limit == null) | .id`, want: `2 11 12`},
+		{expr: `"a" < "b", null < false, 1 < "a", [1] == [1]`, want: `true true true true`},
+
+		// string literals
+		{expr: `"EUR"`, want: `"EUR"`},
+		{expr: `"a \"quoted\" | string"`, want: `"a \"quoted\" | string"`},
+		{expr: `.accounts[] | select(.name | test("^T")) | .id`, want: `11 12`},
+		{expr: `.accounts | map(.name | ascii_upcase)`, want: `["GIROKONTO","TAGESGELD","TRAVEL"]`},
+
+		// arithmetic
+		{expr: ".total - 1", want: `2`},
+		{expr: ".total-1", want: `2`},
+		{expr: "-.total", want: `-3`},
+		{expr: "1 + 2 * 3 - 4 / 2", want: `5`},
+		{expr: "(1 + 2) * 3", want: `9`},
+		{expr: "7 % 3", want: `1`},
+		{expr: ".accounts[0].balance - 24.5", want: `1000`},
+		{expr: ".accounts | map(.balance) | add", want: `6557.66`},
+		{expr: `.accounts[] | select(.balance - 1000 > 0) | .id`, want: `2 11`},
+		{expr: `"a" + "b"`, want: `"ab"`},
+		{expr: `null + 1`, want: `1`},
+		{expr: `[1, 2, 3] - [2]`, want: `[1,3]`},
+		{expr: `.accounts[0].tags + ["x"]`, want: `["main","x"]`},
+		{expr: `{a: 1} + {b: 2}`, want: `{"a":1,"b":2}`},
+		{expr: `1 / 0`, err: "division by zero"},
+		{expr: `1 % 0`, err: "modulo by zero"},
+		{expr: `"a" - 1`, err: "cannot compute string - number"},
+		{expr: `{} * 2`, err: "cannot compute object * number"},
+
+		// errors
+		{expr: "", err: "unexpected end of query"},
+		{expr: ".accounts[", err: "unexpected end of query"},
+		{expr: "(.total", err: `expected ")" at end of query`},
+		{expr: `"open`, err: "unterminated string in query"},
+		{expr: ".total )", err: `unexpected ")" in query`},
+		{expr: ".total @", err: `unexpected '@' in query`},
+		{expr: "EUR", err: `unknown function EUR`},
+		{expr: "select(.a; .b)", err: `select`},
+		{expr: "{1}", err: `expected field name in object but found "1"`},
+	}
+	for _, tt := range tests {
+		q, err := compileQuery(tt.expr)
+		var out []interface{}
+		if err == nil {
+			out, err = q.eval(input)
+		}
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: got %v, %v, want error %q", tt.expr, out, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		var got []string
+		for _, v := range out {
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			enc.SetEscapeHTML(false)
+			if err := enc.Encode(v); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, strings.TrimSpace(buf.String()))
+		}
+		if s := strings.Join(got, " "); s != tt.want {
+			t.Errorf("%s = %s, want %s", tt.expr, s, tt.want)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Errors returned by splitWords when the input ends in the middle of a word.
+// They indicate that more input is needed to complete the command.
+var (
+	errOpenSingleQuote = errors.New("unterminated single quote")
+	errOpenDoubleQuote = errors.New("unterminated double quote")
+	errOpenBackslash   = errors.New("unexpected end of input after backslash")
+)
+
+func incomplete(err error) bool {
+	return err == errOpenSingleQuote || err == errOpenDoubleQuote || err == errOpenBackslash
+}
+
+// scriptError is an error found while reading a script. It records the
+// name of the script and the line the offending command started on.
+type scriptError struct {
+	file string
+	line int
+	err  error
+}
+
+func (e *scriptError) Error() string {
+	return fmt.Sprintf("%s:%d: %v", e.file, e.line, e.err)
+}
+
+// scriptReader reads commands from a bosh script. Commands may span several
+// physical lines using quotes or a backslash at the end of the line.
+type scriptReader struct {
+	name    string
+	scanner *bufio.Scanner
+	line    int // number of the last physical line read
+}
+
+func newScriptReader(r io.Reader, name string) *scriptReader {
+	return &scriptReader{
+		name:    name,
+		scanner: bufio.NewScanner(r),
+	}
+}
+
+// next returns the words of the next non-empty command in the script along
+// with the line number it starts on. It returns io.EOF when the script has
+// been read completely.
+func (r *scriptReader) next() ([]word, int, error) {
+	for {
+		if !r.scanner.Scan() {
+			if err := r.scanner.Err(); err != nil {
+				return nil, 0, fmt.Errorf("reading %s: %v", r.name, err)
+			}
+			return nil, 0, io.EOF
+		}
+		r.line++
+		start := r.line
+		text := r.scanner.Text()
+
+		words, err := splitWords(text)
+		for incomplete(err) {
+			if !r.scanner.Scan() {
+				if serr := r.scanner.Err(); serr != nil {
+					return nil, 0, fmt.Errorf("reading %s: %v", r.name, serr)
+				}
+				return nil, 0, &scriptError{file: r.name, line: start, err: syntaxError(err)}
+			}
+			r.line++
+			text += "\n" + r.scanner.Text()
+			words, err = splitWords(text)
+		}
+		if err != nil {
+			return nil, 0, &scriptError{file: r.name, line: start, err: syntaxError(err)}
+		}
+		if len(words) == 0 {
+			continue
+		}
+		return words, start, nil
+	}
+}
+
+// wordPart is a fragment of a word. Variable references in a part are only
+// expanded if expand is set, i.e. the text was not single quoted or escaped.
+type wordPart struct {
+	text   string
+	expand bool
+}
+
+// word is a single unexpanded argument of a command. src holds the word as
+// it was written, including its quotes and backslashes.
+type word struct {
+	parts []wordPart
+	src   string
+}
+
+// literal returns the text of the word without expanding variables.
+func (w word) literal() string {
+	var s string
+	for _, p := range w.parts {
+		s += p.text
+	}
+	return s
+}
+
+func (w *word) add(text string, expand bool) {
+	if n := len(w.parts); n > 0 && w.parts[n-1].expand == expand {
+		w.parts[n-1].text += text
+		return
+	}
+	w.parts = append(w.parts, wordPart{text: text, expand: expand})
+}
+
+// splitWords splits a command line into words using shell-like rules.
+// Words are separated by unquoted whitespace. Single quotes preserve their
+// contents literally, double quotes allow backslash escapes of '"', '\' and
+// '$'. Outside of quotes a backslash escapes the following character and a
+// backslash before a newline joins the two lines. An unquoted '#' at the
+// start of a word begins a comment that runs to the end of the line.
+func splitWords(s string) ([]word, error) {
+	var words []word
+	var w word
+	inWord := false
+	start := 0
+
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		ch := rs[i]
+		if !inWord {
+			start = i
+		}
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n':
+			if inWord {
+				w.src = string(rs[start:i])
+				words = append(words, w)
+				w = word{}
+				inWord = false
+			}
+
+		case ch == '#' && !inWord:
+			// Comment, skip to end of line
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+
+		case ch == '\\':
+			if i+1 >= len(rs) {
+				return nil, errOpenBackslash
+			}
+			i++
+			if rs[i] == '\n' {
+				// Line continuation
+				continue
+			}
+			w.add(string(rs[i]), false)
+			inWord = true
+
+		case ch == '\'':
+			end := indexRune(rs, i+1, '\'')
+			if end < 0 {
+				return nil, errOpenSingleQuote
+			}
+			w.add(string(rs[i+1:end]), false)
+			i = end
+			inWord = true
+
+		case ch == '"':
+			i++
+			for ; i < len(rs) && rs[i] != '"'; i++ {
+				if rs[i] == '\\' && i+1 < len(rs) {
+					switch rs[i+1] {
+					case '"', '\\', '$':
+						i++
+						w.add(string(rs[i]), false)
+						continue
+					case '\n':
+						i++
+						continue
+					}
+				}
+				w.add(string(rs[i]), true)
+			}
+			if i >= len(rs) {
+				return nil, errOpenDoubleQuote
+			}
+			inWord = true
+
+		default:
+			w.add(string(ch), true)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		w.src = string(rs[start:])
+		words = append(words, w)
+	}
+	return words, nil
+}
+
+func indexRune(rs []rune, from int, r rune) int {
+	for i := from; i < len(rs); i++ {
+		if rs[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// stmt is a statement of a bosh script.
+type stmt interface {
+	pos() int
+}
+
+// cmdStmt is a simple statement such as a shell command or an assignment.
+type cmdStmt struct {
+	line  int
+	words []word
+}
+
+// ifStmt runs then if cond is true and otherwise runs els, which may hold
+// a single nested ifStmt for an elif clause.
+type ifStmt struct {
+	line int
+	cond []word
+	then []stmt
+	els  []stmt
+}
+
+// forStmt runs body once for each of the words in items.
+type forStmt struct {
+	line  int
+	name  string
+	items []word
+	body  []stmt
+}
+
+// foreachStmt runs a command and then runs body once for each element of
+// the command's result.
+type foreachStmt struct {
+	line int
+	name string
+	cmd  []word
+	body []stmt
+}
+
+// defStmt defines a function that can be called like a command.
+type defStmt struct {
+	line int
+	name string
+	body []stmt
+}
+
+func (s *cmdStmt) pos() int     { return s.line }
+func (s *ifStmt) pos() int      { return s.line }
+func (s *forStmt) pos() int     { return s.line }
+func (s *foreachStmt) pos() int { return s.line }
+func (s *defStmt) pos() int     { return s.line }
+
+// readStmt reads the next statement from the script. Blocks are read up to
+// their closing end so that the returned statement is complete. It returns
+// io.EOF when the script has been read completely.
+func (r *scriptReader) readStmt() (stmt, error) {
+	words, line, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	s, term, _, err := r.parseStmt(words, line)
+	if err != nil {
+		return nil, err
+	}
+	if term != "" {
+		return nil, r.errorf(line, "%s without matching if, for, foreach or def", term)
+	}
+	return s, nil
+}
+
+// parseStmt parses a statement starting with words. If the words are a block
+// terminator such as else or end, no statement is returned and term holds the
+// terminator along with the remaining words of the line.
+func (r *scriptReader) parseStmt(words []word, line int) (s stmt, term string, rest []word, err error) {
+	keyword := words[0].literal()
+	switch keyword {
+	case "end", "else", "elif":
+		return nil, keyword, words[1:], nil
+
+	case "if":
+		return r.parseIf(words[1:], line)
+
+	case "for", "foreach":
+		if len(words) < 4 || words[2].literal() != "in" {
+			return nil, "", nil, r.errorf(line, "usage: %s NAME in ...", keyword)
+		}
+		name := words[1].literal()
+		if !validName(name) {
+			return nil, "", nil, r.errorf(line, "invalid variable name %q", name)
+		}
+		body, err := r.readBody(keyword, line)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if keyword == "for" {
+			return &forStmt{line: line, name: name, items: words[3:], body: body}, "", nil, nil
+		}
+		return &foreachStmt{line: line, name: name, cmd: words[3:], body: body}, "", nil, nil
+
+	case "def":
+		if len(words) != 2 {
+			return nil, "", nil, r.errorf(line, "usage: def NAME")
+		}
+		name := words[1].literal()
+		if !validName(name) {
+			return nil, "", nil, r.errorf(line, "invalid function name %q", name)
+		}
+		body, err := r.readBody(keyword, line)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return &defStmt{line: line, name: name, body: body}, "", nil, nil
+	}
+
+	return &cmdStmt{line: line, words: words}, "", nil, nil
+}
+
+func (r *scriptReader) parseIf(cond []word, line int) (stmt, string, []word, error) {
+	if len(cond) == 0 {
+		return nil, "", nil, r.errorf(line, "if requires a condition")
+	}
+	s := &ifStmt{line: line, cond: cond}
+	var term string
+	var rest []word
+	var err error
+	s.then, term, rest, err = r.readBlock("if", line)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	switch term {
+	case "elif":
+		elif, _, _, err := r.parseIf(rest, r.line)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		s.els = []stmt{elif}
+	case "else":
+		if len(rest) != 0 {
+			return nil, "", nil, r.errorf(r.line, "unexpected words after else")
+		}
+		if s.els, err = r.readBody("if", line); err != nil {
+			return nil, "", nil, err
+		}
+	}
+	return s, "", nil, nil
+}
+
+// readBody reads the statements of a block that can only be closed by end.
+func (r *scriptReader) readBody(keyword string, line int) ([]stmt, error) {
+	body, term, _, err := r.readBlock(keyword, line)
+	if err != nil {
+		return nil, err
+	}
+	if term != "end" {
+		return nil, r.errorf(r.line, "%s without matching if", term)
+	}
+	return body, nil
+}
+
+// readBlock reads statements up to the terminator of the block that was
+// opened by keyword on the given line.
+func (r *scriptReader) readBlock(keyword string, line int) ([]stmt, string, []word, error) {
+	var body []stmt
+	for {
+		words, l, err := r.next()
+		if err == io.EOF {
+			return nil, "", nil, r.errorf(line, "%s without matching end", keyword)
+		}
+		if err != nil {
+			return nil, "", nil, err
+		}
+		s, term, rest, err := r.parseStmt(words, l)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		if term != "" {
+			if term == "end" && len(rest) != 0 {
+				return nil, "", nil, r.errorf(l, "unexpected words after end")
+			}
+			return body, term, rest, nil
+		}
+		body = append(body, s)
+	}
+}
+
+func (r *scriptReader) errorf(line int, format string, args ...interface{}) error {
+	return &scriptError{file: r.name, line: line, err: syntaxError(fmt.Errorf(format, args...))}
+}
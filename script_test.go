@@ -0,0 +1,326 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		err  error
+	}{
+		{in: "searchproviders deutsche bank", want: []string{"searchproviders", "deutsche", "bank"}},
+		{in: "  a   b\t c ", want: []string{"a", "b", "c"}},
+		{in: "", want: nil},
+		{in: `setprofile 'Example  Corp'`, want: []string{"setprofile", "Example  Corp"}},
+		{in: `echo 'it''s'`, want: []string{"echo", "its"}},
+		{in: `echo "say \"hi\" \\ \$x"`, want: []string{"echo", `say "hi" \ $x`}},
+		{in: `echo "a\nb"`, want: []string{"echo", `a\nb`}},
+		{in: `echo a\ b c`, want: []string{"echo", "a b", "c"}},
+		{in: `echo '' ""`, want: []string{"echo", "", ""}},
+		{in: `echo pre"mid"'post'`, want: []string{"echo", "premidpost"}},
+		{in: "echo a # comment", want: []string{"echo", "a"}},
+		{in: "# only a comment", want: nil},
+		{in: "echo a#b '#c'", want: []string{"echo", "a#b", "#c"}},
+		{in: "echo a \\\nb", want: []string{"echo", "a", "b"}},
+		{in: "ec\\\nho", want: []string{"echo"}},
+		{in: "echo \"one\\\ntwo\"", want: []string{"echo", "onetwo"}},
+		{in: "echo 'multi\nline'", want: []string{"echo", "multi\nline"}},
+		{in: `echo "open`, err: errOpenDoubleQuote},
+		{in: `echo 'open`, err: errOpenSingleQuote},
+		{in: `echo open\`, err: errOpenBackslash},
+	}
+	for _, tt := range tests {
+		words, err := splitWords(tt.in)
+		if err != tt.err {
+			t.Errorf("splitWords(%q): got error %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		var got []string
+		for _, w := range words {
+			got = append(got, w.literal())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitWords(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestScriptReader(t *testing.T) {
+	r := newScriptReader(strings.NewReader(`# comment only
+
+searchproviders "deutsche bank"
+setprofile 'Acme  Widgets' \
+	y   # trailing comment
+echo "first
+second" third
+  
+echo a\ b`), "test.bosh")
+
+	tests := []struct {
+		line int
+		want []string
+	}{
+		{3, []string{"searchproviders", "deutsche bank"}},
+		{4, []string{"setprofile", "Acme  Widgets", "y"}},
+		{6, []string{"echo", "first\nsecond", "third"}},
+		{9, []string{"echo", "a b"}},
+	}
+	for _, tt := range tests {
+		words, line, err := r.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, w := range words {
+			got = append(got, w.literal())
+		}
+		if line != tt.line || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("got %q on line %d, want %q on line %d", got, line, tt.want, tt.line)
+		}
+	}
+	if _, _, err := r.next(); err != io.EOF {
+		t.Errorf("got %v at the end of the script, want EOF", err)
+	}
+
+	for script, want := range map[string]string{
+		"echo a\necho 'open\nstill open": "test.bosh:2: unterminated single quote",
+		"\n\necho \"open":                "test.bosh:3: unterminated double quote",
+		"echo a\necho \\\n":              "test.bosh:2: unexpected end of input after backslash",
+		"echo ok\n# \"\necho 'x' 'y\nz":  "test.bosh:3: unterminated single quote",
+	} {
+		r := newScriptReader(strings.NewReader(script), "test.bosh")
+		var err error
+		for err == nil {
+			_, _, err = r.next()
+		}
+		if err == io.EOF || err.Error() != want || exitCode(err) != exitSyntax {
+			t.Errorf("%q: got error %v, want %q", script, err, want)
+		}
+	}
+}
+
+// TestScriptInput runs scripts given with -i and piped to stdin.
+func TestScriptInput(t *testing.T) {
+	dir := tempDir(t)
+	script := "echo 'deutsche  bank' \\\n\tx   # comment\necho \"a\\\"b\"\n"
+	want := "deutsche  bank x\na\"b\n"
+
+	if out, code := runBosh(t, dir, script, nil); code != exitOK || out != want {
+		t.Errorf("stdin: got %q with exit code %d, want %q", out, code, want)
+	}
+
+	file := filepath.Join(dir, "script.bosh")
+	if err := ioutil.WriteFile(file, []byte(script), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// -i takes precedence over stdin, which is not a terminal in tests.
+	if out, code := runBosh(t, dir, "echo stdin\n", nil, "-i", file); code != exitOK || out != want {
+		t.Errorf("-i: got %q with exit code %d, want %q", out, code, want)
+	}
+
+	if err := ioutil.WriteFile(file, []byte("echo ok\necho 'open\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, code := runBosh(t, dir, "", nil, "-i", file)
+	if want := file + ":2: unterminated single quote"; code != exitSyntax || !strings.Contains(out, want) {
+		t.Errorf("got %q with exit code %d, want %q", out, code, want)
+	}
+	// Commands run as they are read, so the line before the error has run.
+	if !strings.HasPrefix(out, "ok\n") {
+		t.Errorf("got %q, want the output of the first line", out)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	os.Setenv("BOSH_TEST_VAR", "from env")
+	defer os.Unsetenv("BOSH_TEST_VAR")
+
+	in := newInterpreter(nil, "test.bosh")
+	in.vars["name"] = "alice"
+	in.vars["BOSH_TEST_VAR"] = "from script"
+	acc, err := normalize(map[string]interface{}{
+		"accounts": []interface{}{
+			map[string]interface{}{"id": 7, "iban": "DE89370400440532013000"},
+			map[string]interface{}{"id": 8, "iban": "DE75512108001245126199"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.vars["acc"] = acc
+	in.args = []string{"first"}
+
+	tests := []struct {
+		in   string
+		want string
+		err  string
+	}{
+		{in: `$name`, want: "alice"},
+		{in: `${name}s`, want: "alices"},
+		{in: `"hi $name!"`, want: "hi alice!"},
+		{in: `'$name'`, want: "$name"},
+		{in: `\$name`, want: "$name"},
+		{in: `"\$name"`, want: "$name"},
+		{in: `costs$`, want: "costs$"},
+		{in: `$-`, want: "$-"},
+		{in: `${acc.accounts[0].id}`, want: "7"},
+		{in: `${acc.accounts[-1].iban}`, want: "DE75512108001245126199"},
+		{in: `${acc.accounts}`, want: `[{"iban":"DE89370400440532013000","id":7},{"iban":"DE75512108001245126199","id":8}]`},
+		{in: `$1`, want: "first"},
+		{in: `$BOSH_TEST_VAR`, want: "from script"},
+		{in: `$2`, err: "undefined variable 2"},
+		{in: `$missing`, err: "undefined variable missing"},
+		{in: `${name`, err: "missing closing brace"},
+		{in: `${.id}`, err: "invalid variable reference"},
+		{in: `${acc.nothing}`, err: `no field "nothing"`},
+		{in: `${acc.accounts[2]}`, err: "index 2 out of range"},
+	}
+	for _, tt := range tests {
+		words, err := splitWords(tt.in)
+		if err != nil || len(words) != 1 {
+			t.Fatalf("splitWords(%q) = %v, %v", tt.in, words, err)
+		}
+		got, err := in.expand(words[0])
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expand(%q): got %q, %v, want error %q", tt.in, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("expand(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+
+	delete(in.vars, "BOSH_TEST_VAR")
+	if got, err := in.expand(word{parts: []wordPart{{text: "$BOSH_TEST_VAR", expand: true}}}); err != nil || got != "from env" {
+		t.Errorf("got %q, %v, want the environment variable", got, err)
+	}
+}
+
+// readScript parses all statements of a script.
+func readScript(script string) ([]stmt, error) {
+	r := newScriptReader(strings.NewReader(script), "test.bosh")
+	var stmts []stmt
+	for {
+		s, err := r.readStmt()
+		if err == io.EOF {
+			return stmts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s)
+	}
+}
+
+func TestReadStmt(t *testing.T) {
+	stmts, err := readScript(`# setup
+set a 'first
+second'
+if $a == 1
+	echo one
+elif $a == 2
+	echo two
+else
+	echo other
+end
+
+for x in a b c
+	for y in 1 2
+		echo $x$y
+	end
+end
+def greet
+	echo hi \
+		$1
+end
+foreach acc in accounts
+	echo ${acc.id}
+end
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 5 {
+		t.Fatalf("got %d statements, want 5: %#v", len(stmts), stmts)
+	}
+
+	if s, ok := stmts[0].(*cmdStmt); !ok || s.line != 2 || s.words[2].literal() != "first\nsecond" {
+		t.Errorf("got %#v, want the set command on line 2", stmts[0])
+	}
+
+	ifs, ok := stmts[1].(*ifStmt)
+	if !ok || ifs.line != 4 || literals(ifs.cond) != "$a == 1" || len(ifs.then) != 1 || len(ifs.els) != 1 {
+		t.Fatalf("got %#v, want an if statement on line 4", stmts[1])
+	}
+	elif, ok := ifs.els[0].(*ifStmt)
+	if !ok || elif.line != 6 || literals(elif.cond) != "$a == 2" || len(elif.then) != 1 || len(elif.els) != 1 {
+		t.Fatalf("got %#v, want the elif clause on line 6", ifs.els[0])
+	}
+	if s := elif.els[0].(*cmdStmt); s.line != 9 || literals(s.words) != "echo other" {
+		t.Errorf("got %#v, want the else clause on line 9", s)
+	}
+
+	outer, ok := stmts[2].(*forStmt)
+	if !ok || outer.line != 12 || outer.name != "x" || literals(outer.items) != "a b c" || len(outer.body) != 1 {
+		t.Fatalf("got %#v, want a for loop on line 12", stmts[2])
+	}
+	if inner, ok := outer.body[0].(*forStmt); !ok || inner.name != "y" || len(inner.body) != 1 {
+		t.Errorf("got %#v, want a nested for loop", outer.body[0])
+	}
+
+	def, ok := stmts[3].(*defStmt)
+	if !ok || def.name != "greet" || len(def.body) != 1 {
+		t.Fatalf("got %#v, want a function definition", stmts[3])
+	}
+	if s := def.body[0].(*cmdStmt); s.line != 18 || literals(s.words) != "echo hi $1" {
+		t.Errorf("got %#v, want the continued echo on line 18", s)
+	}
+
+	if s, ok := stmts[4].(*foreachStmt); !ok || s.line != 21 || s.name != "acc" || literals(s.cmd) != "accounts" {
+		t.Errorf("got %#v, want a foreach loop on line 21", stmts[4])
+	}
+}
+
+func TestReadStmtErrors(t *testing.T) {
+	tests := []struct {
+		script string
+		err    string
+	}{
+		{"if true\necho a\n", "test.bosh:1: if without matching end"},
+		{"echo\nfor x in a\nif true\nend\n", "test.bosh:2: for without matching end"},
+		{"def f\n", "test.bosh:1: def without matching end"},
+		{"end\n", "test.bosh:1: end without matching if, for, foreach or def"},
+		{"echo\nelse\n", "test.bosh:2: else without matching if, for, foreach or def"},
+		{"for x in a\nelse\nend\n", "test.bosh:2: else without matching if"},
+		{"if true\nend extra\n", "test.bosh:2: unexpected words after end"},
+		{"if true\nelse x\nend\n", "test.bosh:2: unexpected words after else"},
+		{"if\nend\n", "test.bosh:1: if requires a condition"},
+		{"for x a b\nend\n", "test.bosh:1: usage: for NAME in ..."},
+		{"foreach x in\nend\n", "test.bosh:1: usage: foreach NAME in ..."},
+		{"for 1x in a\nend\n", `test.bosh:1: invalid variable name "1x"`},
+		{"def\nend\n", "test.bosh:1: usage: def NAME"},
+		{"def a-b\nend\n", `test.bosh:1: invalid function name "a-b"`},
+		{"echo\necho 'open\nstill open\n", "test.bosh:2: unterminated single quote"},
+		{"if true\necho \"open\n", "test.bosh:2: unterminated double quote"},
+	}
+	for _, tt := range tests {
+		_, err := readScript(tt.script)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: got error %v, want %q", tt.script, err, tt.err)
+			continue
+		}
+		if code := exitCode(err); code != exitSyntax {
+			t.Errorf("%q: got exit code %d, want %d", tt.script, code, exitSyntax)
+		}
+	}
+}
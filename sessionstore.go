@@ -0,0 +1,418 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"code.bankrs.com/bosgo"
+	"github.com/abiosoft/ishell"
+)
+
+var noSession = flag.Bool("no-session", false, "start without restoring the last saved session")
+
+// sessionName is the name under which the current session is saved.
+var sessionName = "default"
+
+// savedSession is the part of a session that is kept between runs of bosh.
+type savedSession struct {
+	Address        string    `json:"address"`
+	DevEmail       string    `json:"dev_email,omitempty"`
+	DevSessionID   string    `json:"dev_session_id,omitempty"`
+	ApplicationKey string    `json:"application_key,omitempty"`
+	UserName       string    `json:"user_name,omitempty"`
+	UserSessionID  string    `json:"user_session_id,omitempty"`
+	Saved          time.Time `json:"saved"`
+}
+
+// sessionStore holds the saved sessions. It is stored encrypted with
+// AES-GCM using a random key kept in a separate file that only the user
+// can read. Since the key lives next to the store, the encryption only keeps
+// the tokens out of sight of anyone who gets hold of the store alone; the
+// file permissions are what protects them.
+type sessionStore struct {
+	Last     string                   `json:"last,omitempty"`
+	Sessions map[string]*savedSession `json:"sessions"`
+}
+
+func sessionStorePath() string {
+	return filepath.Join(configDir(), "sessions")
+}
+
+func sessionKeyPath() string {
+	return filepath.Join(configDir(), "session.key")
+}
+
+// sessionKey reads the key used to encrypt the session store, creating it if
+// create is true and no key exists yet.
+func sessionKey(create bool) ([]byte, error) {
+	key, err := ioutil.ReadFile(sessionKeyPath())
+	if err == nil {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid session key in %s", sessionKeyPath())
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) || !create {
+		return nil, err
+	}
+
+	key = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(configDir(), 0700); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(sessionKeyPath(), key, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// loadSessionStore reads the saved sessions. A missing store is empty.
+func loadSessionStore() (*sessionStore, error) {
+	store := &sessionStore{Sessions: map[string]*savedSession{}}
+	data, err := ioutil.ReadFile(sessionStorePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return nil, err
+	}
+
+	key, err := sessionKey(false)
+	if err != nil {
+		return nil, fmt.Errorf("reading session key: %v", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("session store %s is corrupt", sessionStorePath())
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting session store %s: %v", sessionStorePath(), err)
+	}
+	if err := json.Unmarshal(plain, store); err != nil {
+		return nil, fmt.Errorf("reading session store %s: %v", sessionStorePath(), err)
+	}
+	if store.Sessions == nil {
+		store.Sessions = map[string]*savedSession{}
+	}
+	return store, nil
+}
+
+// save encrypts and writes the store.
+func (s *sessionStore) save() error {
+	plain, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	key, err := sessionKey(true)
+	if err != nil {
+		return fmt.Errorf("creating session key: %v", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	data := gcm.Seal(nonce, nonce, plain, nil)
+
+	tmp := sessionStorePath() + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, sessionStorePath())
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// saved returns the persistent part of the session.
+func (s *state) saved() *savedSession {
+	saved := &savedSession{
+		Address:        *addr,
+		DevEmail:       s.devEmail,
+		ApplicationKey: s.applicationKey,
+		UserName:       s.userName,
+		Saved:          time.Now().UTC(),
+	}
+	if s.devClient != nil {
+		saved.DevSessionID = s.devClient.SessionID()
+	}
+	if s.userClient != nil {
+		saved.UserSessionID = s.userClient.SessionID()
+	}
+	return saved
+}
+
+// empty reports whether nobody is logged in and no application is used.
+func (s *state) empty() bool {
+	return s.devClient == nil && s.appClient == nil && s.userClient == nil
+}
+
+// restoreSession recreates a session from a saved session. The session
+// tokens are checked with the api, tokens that have expired are dropped
+// from saved and described in the returned list of messages.
+func restoreSession(saved *savedSession) (state, []string, error) {
+	st := state{client: session.client}
+	var expired []string
+
+	if saved.DevSessionID != "" {
+		devClient := bosgo.NewDevClient(httpClient, *addr, saved.DevSessionID, clientOptions...)
+		if _, err := devClient.Profile().Send(); err != nil {
+			if classify(err) != kindAuth {
+				return state{}, nil, err
+			}
+			expired = append(expired, fmt.Sprintf("developer session of %s has expired, login again", saved.DevEmail))
+			saved.DevEmail, saved.DevSessionID = "", ""
+		} else {
+			st.devEmail = saved.DevEmail
+			st.devClient = devClient
+		}
+	}
+
+	if saved.ApplicationKey != "" {
+		st.appClient = session.client.WithApplicationKey(saved.ApplicationKey)
+		st.applicationKey = saved.ApplicationKey
+	}
+
+	if saved.UserSessionID != "" && saved.ApplicationKey != "" {
+		userClient := bosgo.NewUserClient(httpClient, *addr, saved.UserSessionID, saved.ApplicationKey, clientOptions...)
+		if _, err := userClient.Accesses.List().Send(); err != nil {
+			if classify(err) != kindAuth {
+				return state{}, nil, err
+			}
+			expired = append(expired, fmt.Sprintf("session of user %s has expired, use loginuser again", saved.UserName))
+			saved.UserName, saved.UserSessionID = "", ""
+		} else {
+			st.userName = saved.UserName
+			st.userClient = userClient
+		}
+	}
+
+	return st, expired, nil
+}
+
+// loadSession replaces the current session with the saved session name and
+// makes it the session restored on the next start.
+func loadSession(name string) ([]string, error) {
+	store, err := loadSessionStore()
+	if err != nil {
+		return nil, err
+	}
+	saved, ok := store.Sessions[name]
+	if !ok {
+		return nil, validationErrorf("no saved session %q", name)
+	}
+	if saved.Address != *addr {
+		return nil, validationErrorf("session %q was saved for %s, not %s", name, saved.Address, *addr)
+	}
+
+	st, expired, err := restoreSession(saved)
+	if err != nil {
+		return nil, &cmdError{kind: classify(err), err: fmt.Errorf("restoring session %s: %v", name, err)}
+	}
+	*session = st
+	sessionName = name
+
+	store.Last = name
+	if err := store.save(); err != nil {
+		return expired, err
+	}
+	return expired, nil
+}
+
+// saveSession stores the current session under name and makes it the
+// session restored on the next start.
+func saveSession(name string) error {
+	store, err := loadSessionStore()
+	if err != nil {
+		return err
+	}
+	store.Sessions[name] = session.saved()
+	store.Last = name
+	sessionName = name
+	return store.save()
+}
+
+// restoreLastSession restores the session that was saved or loaded last,
+// unless it belongs to a different api. If the saved sessions cannot be read
+// or the session cannot be checked with the api, a warning is printed and
+// bosh starts with an empty session. The saved session is then left as it is
+// instead of being replaced on exit.
+func restoreLastSession() {
+	store, err := loadSessionStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: ignoring saved sessions, remove %s to start over: %v\n", sessionStorePath(), err)
+		sessionName = ""
+		return
+	}
+	saved, ok := store.Sessions[store.Last]
+	if !ok || saved.Address != *addr {
+		return
+	}
+	expired, err := loadSession(store.Last)
+	for _, msg := range expired {
+		fmt.Fprintln(os.Stderr, msg)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: starting with an empty session: %v\n", err)
+		sessionName = ""
+	}
+}
+
+// saveOnExit keeps the session of an interactive shell for the next start.
+func saveOnExit() {
+	if sessionName == "" {
+		return
+	}
+	if session.empty() {
+		store, err := loadSessionStore()
+		if err != nil || store.Sessions[sessionName] == nil {
+			return
+		}
+	}
+	if err := saveSession(sessionName); err != nil {
+		fmt.Fprintf(os.Stderr, "saving session: %v\n", err)
+	}
+}
+
+func saveSessionCmd(c *ishell.Context) {
+	name := sessionName
+	if name == "" {
+		name = "default"
+	}
+	if len(c.Args) > 0 {
+		name = c.Args[0]
+	}
+	if err := saveSession(name); err != nil {
+		c.Err(err)
+		return
+	}
+}
+
+func loadSessionCmd(c *ishell.Context) {
+	name := "default"
+	if len(c.Args) > 0 {
+		name = c.Args[0]
+	} else if store, err := loadSessionStore(); err == nil && store.Last != "" {
+		name = store.Last
+	}
+	expired, err := loadSession(name)
+	for _, msg := range expired {
+		c.Println(msg)
+	}
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	c.SetPrompt(prompt())
+}
+
+// sessionInfo describes a saved session without its tokens.
+type sessionInfo struct {
+	Name           string    `json:"name"`
+	Current        bool      `json:"current"`
+	Address        string    `json:"address"`
+	DevEmail       string    `json:"dev_email,omitempty"`
+	ApplicationKey string    `json:"application_key,omitempty"`
+	UserName       string    `json:"user_name,omitempty"`
+	Saved          time.Time `json:"saved"`
+}
+
+func listSessions(c *ishell.Context) {
+	store, err := loadSessionStore()
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	names := make([]string, 0, len(store.Sessions))
+	for name := range store.Sessions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	infos := make([]sessionInfo, 0, len(names))
+	for _, name := range names {
+		s := store.Sessions[name]
+		infos = append(infos, sessionInfo{
+			Name:           name,
+			Current:        name == sessionName,
+			Address:        s.Address,
+			DevEmail:       s.DevEmail,
+			ApplicationKey: s.ApplicationKey,
+			UserName:       s.UserName,
+			Saved:          s.Saved,
+		})
+	}
+
+	if structuredOutput(c) {
+		printResult(c, infos)
+		return
+	}
+	lastResult = names
+	for _, info := range infos {
+		marker := " "
+		if info.Current {
+			marker = "*"
+		}
+		who := info.DevEmail
+		if info.UserName != "" {
+			who = info.ApplicationKey + "/" + info.UserName
+		} else if info.ApplicationKey != "" {
+			who = info.ApplicationKey
+		}
+		c.Printf("%s %s\t%s\t%s\t%s\n", marker, info.Name, info.Address, who, info.Saved.Local().Format("2006-01-02 15:04"))
+	}
+}
+
+func dropSession(c *ishell.Context) {
+	name, err := readOneArg("Session", c)
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	store, err := loadSessionStore()
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	if _, ok := store.Sessions[name]; !ok {
+		c.Err(validationErrorf("no saved session %q", name))
+		return
+	}
+	delete(store.Sessions, name)
+	if store.Last == name {
+		store.Last = ""
+	}
+	if sessionName == name {
+		// Don't save the dropped session again on exit.
+		sessionName = ""
+	}
+	if err := store.save(); err != nil {
+		c.Err(err)
+		return
+	}
+}
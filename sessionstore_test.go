@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionStoreRoundTrip(t *testing.T) {
+	newHarness(t)
+
+	store := &sessionStore{
+		Last: "work",
+		Sessions: map[string]*savedSession{
+			"work": {
+				Address:        "api.example.com",
+				DevEmail:       "dev@example.com",
+				DevSessionID:   "dev-secret-token",
+				ApplicationKey: "demo-key",
+				UserName:       "alice",
+				UserSessionID:  "user-secret-token",
+				Saved:          time.Date(2018, 11, 30, 12, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+	if err := store.save(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadSessionStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, store) {
+		t.Errorf("got %+v, want %+v", got, store)
+	}
+
+	data, err := ioutil.ReadFile(sessionStorePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret-token")) || bytes.Contains(data, []byte("alice")) {
+		t.Errorf("session store contains plain text: %q", data)
+	}
+	for _, path := range []string{sessionStorePath(), sessionKeyPath()} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := fi.Mode().Perm(); perm != 0600 {
+			t.Errorf("%s has mode %v, want 0600", path, perm)
+		}
+	}
+
+	// Saving again uses a fresh nonce.
+	if err := store.save(); err != nil {
+		t.Fatal(err)
+	}
+	again, err := ioutil.ReadFile(sessionStorePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(data, again) {
+		t.Error("got the same ciphertext twice")
+	}
+}
+
+func TestSessionStoreErrors(t *testing.T) {
+	newHarness(t)
+
+	if store, err := loadSessionStore(); err != nil || len(store.Sessions) != 0 {
+		t.Fatalf("got %+v, %v, want an empty store", store, err)
+	}
+
+	save := func() []byte {
+		t.Helper()
+		store := &sessionStore{Sessions: map[string]*savedSession{"default": {Address: *addr, UserSessionID: "token"}}}
+		if err := store.save(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(sessionStorePath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	otherKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, otherKey); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		setup func(data []byte)
+		err   string
+	}{
+		{"wrong key", func([]byte) {
+			ioutil.WriteFile(sessionKeyPath(), otherKey, 0600)
+		}, "decrypting session store"},
+		{"tampered", func(data []byte) {
+			data[len(data)-1] ^= 1
+			ioutil.WriteFile(sessionStorePath(), data, 0600)
+		}, "decrypting session store"},
+		{"truncated", func(data []byte) {
+			ioutil.WriteFile(sessionStorePath(), data[:4], 0600)
+		}, "is corrupt"},
+		{"invalid key", func([]byte) {
+			ioutil.WriteFile(sessionKeyPath(), []byte("short"), 0600)
+		}, "invalid session key"},
+		{"missing key", func([]byte) {
+			os.Remove(sessionKeyPath())
+		}, "reading session key"},
+	}
+	for _, tt := range tests {
+		os.Remove(sessionKeyPath())
+		tt.setup(save())
+		_, err := loadSessionStore()
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestSessionSaveAndLoad(t *testing.T) {
+	h := newHarness(t)
+	h.loginUser()
+	defer func() { sessionName = "default" }()
+
+	h.mustRun("", "session", "save", "work")
+	*session = state{client: session.client}
+	h.fails(exitAuth, "login as a user first", "", "accounts")
+
+	h.mustRun("", "session", "load", "work")
+	h.contains(h.mustRun("", "accounts"), "Girokonto")
+	h.contains(h.mustRun("", "session", "list"), "* work", "demo-key/alice")
+
+	h.mustRun("", "session", "drop", "work")
+	h.fails(exitValidation, `no saved session "work"`, "", "session", "load", "work")
+}
+
+func TestRestoreExpiredSession(t *testing.T) {
+	h := newHarness(t)
+	h.loginDev()
+	h.loginUser()
+	saved := session.saved()
+	h.mustRun("", "logoutuser")
+
+	st, expired, err := restoreSession(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"session of user alice has expired, use loginuser again"}
+	if !reflect.DeepEqual(expired, want) {
+		t.Errorf("got messages %q, want %q", expired, want)
+	}
+	if st.userClient != nil || st.devClient == nil || st.applicationKey != "demo-key" {
+		t.Errorf("got session %+v, want the developer and application only", st)
+	}
+	if saved.UserName != "" || saved.UserSessionID != "" || saved.DevSessionID == "" {
+		t.Errorf("got saved session %+v, want the user login dropped", saved)
+	}
+}
+
+func TestRestoreOnStart(t *testing.T) {
+	h := newHarness(t)
+	h.loginUser()
+	defer func() { sessionName = "default" }()
+
+	if err := saveSession("default"); err != nil {
+		t.Fatal(err)
+	}
+	*session = state{client: session.client}
+	if err := startSession(); err != nil {
+		t.Fatal(err)
+	}
+	h.contains(h.mustRun("", "accounts"), "Girokonto")
+
+	// A session that cannot be checked is kept for later and bosh starts
+	// without it.
+	store, err := loadSessionStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Sessions["default"].Address = "127.0.0.1:1"
+	if err := store.save(); err != nil {
+		t.Fatal(err)
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	out, code := runBosh(t, dir, "echo started\n", nil, "-a", "127.0.0.1:1")
+	if code != exitOK || !strings.Contains(out, "warning: starting with an empty session") ||
+		!strings.Contains(out, "connection refused") || !strings.Contains(out, "started") {
+		t.Errorf("unreachable api: got exit code %d and output:\n%s", code, out)
+	}
+	if store, err := loadSessionStore(); err != nil || store.Sessions["default"].UserSessionID == "" {
+		t.Errorf("got store %+v, %v, want the saved session kept", store, err)
+	}
+
+	os.Remove(sessionKeyPath())
+	out, code = runBosh(t, dir, "echo started\n", nil, "-a", "127.0.0.1:1")
+	if code != exitOK || !strings.Contains(out, "warning: ignoring saved sessions") || !strings.Contains(out, "started") {
+		t.Errorf("unreadable store: got exit code %d and output:\n%s", code, out)
+	}
+}
+
+func TestSaveOnExit(t *testing.T) {
+	h := newHarness(t)
+	defer func() { sessionName = "default" }()
+
+	stored := func() *savedSession {
+		t.Helper()
+		store, err := loadSessionStore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store.Sessions["default"]
+	}
+
+	saveOnExit()
+	if _, err := os.Stat(sessionStorePath()); !os.IsNotExist(err) {
+		t.Errorf("empty session was saved: %v", err)
+	}
+
+	h.loginUser()
+	sessionName = ""
+	saveOnExit()
+	if s := stored(); s != nil {
+		t.Errorf("got %+v saved without a session name", s)
+	}
+
+	sessionName = "default"
+	saveOnExit()
+	if s := stored(); s == nil || s.UserName != "alice" || s.UserSessionID == "" {
+		t.Errorf("got saved session %+v, want alice", s)
+	}
+
+	// Logging out is saved too.
+	*session = state{client: session.client}
+	saveOnExit()
+	if s := stored(); s == nil || s.UserSessionID != "" {
+		t.Errorf("got saved session %+v, want it empty", s)
+	}
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"code.bankrs.com/bosgo"
+	"github.com/abiosoft/ishell"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var cacheFile = flag.String("db", "", "SQLite database used by sync and sql, defaults to cache.db in the config directory")
+
+func cachePath() string {
+	if *cacheFile != "" {
+		return *cacheFile
+	}
+	return filepath.Join(configDir(), "cache.db")
+}
+
+// cacheSchema creates the tables of the cache. Every row belongs to the user
+// and application key it was synced for. Dates are stored as yyyy-mm-dd
+// text and amounts as numbers so they can be compared in queries.
+const cacheSchema = `
+CREATE TABLE IF NOT EXISTS syncs (
+	app TEXT, user TEXT, synced_at TEXT,
+	PRIMARY KEY (app, user));
+CREATE TABLE IF NOT EXISTS accesses (
+	app TEXT, user TEXT, id INTEGER, name TEXT, provider_id TEXT, enabled INTEGER,
+	PRIMARY KEY (app, user, id));
+CREATE TABLE IF NOT EXISTS accounts (
+	app TEXT, user TEXT, id INTEGER, access_id INTEGER, provider_id TEXT, name TEXT, type TEXT,
+	number TEXT, iban TEXT, currency TEXT, balance REAL, balance_date TEXT, enabled INTEGER,
+	PRIMARY KEY (app, user, id));
+CREATE TABLE IF NOT EXISTS transactions (
+	app TEXT, user TEXT, id INTEGER, account_id INTEGER, entry_date TEXT, settlement_date TEXT,
+	amount REAL, currency TEXT, counterparty TEXT, counterparty_iban TEXT, usage TEXT, type TEXT,
+	category_id INTEGER, repeated_transaction_id INTEGER,
+	PRIMARY KEY (app, user, id));
+CREATE TABLE IF NOT EXISTS scheduled_transactions (
+	app TEXT, user TEXT, id INTEGER, account_id INTEGER, booking_date TEXT,
+	amount REAL, currency TEXT, counterparty TEXT, counterparty_iban TEXT, usage TEXT,
+	PRIMARY KEY (app, user, id));
+CREATE TABLE IF NOT EXISTS repeated_transactions (
+	app TEXT, user TEXT, id INTEGER, account_id INTEGER,
+	amount REAL, currency TEXT, counterparty TEXT, counterparty_iban TEXT, usage TEXT,
+	PRIMARY KEY (app, user, id));
+`
+
+// openCache opens the cache database, creating it if necessary.
+func openCache() (*sql.DB, error) {
+	path := cachePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(cacheSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return db, nil
+}
+
+// syncResult counts the rows written by sync.
+type syncResult struct {
+	File                  string `json:"file"`
+	Accesses              int    `json:"accesses"`
+	Accounts              int    `json:"accounts"`
+	NewTransactions       int    `json:"new_transactions"`
+	ScheduledTransactions int    `json:"scheduled_transactions"`
+	RepeatedTransactions  int    `json:"repeated_transactions"`
+}
+
+func syncCache(c *ishell.Context) {
+	if session.userClient == nil {
+		c.Err(errNoUser)
+		return
+	}
+
+	var full bool
+	fs := newCmdFlags("sync")
+	fs.BoolVar(&full, "full", false, "fetch all transactions again instead of only new ones")
+	if err := parseCmdFlags(c, fs); err != nil {
+		c.Err(err)
+		return
+	}
+
+	db, err := openCache()
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	defer db.Close()
+
+	res, err := syncUser(db, session.applicationKey, session.userName, full)
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	if structuredOutput(c) {
+		printResult(c, res)
+		return
+	}
+	lastResult = res
+	c.Printf("Synced %d accesses, %d accounts, %d new transactions, %d scheduled and %d repeated transactions to %s\n",
+		res.Accesses, res.Accounts, res.NewTransactions, res.ScheduledTransactions, res.RepeatedTransactions, res.File)
+}
+
+// syncUser stores the data of the current user in db. Accesses, accounts,
+// scheduled and repeated transactions replace those stored before.
+// Transactions are requested page by page and every one requested is
+// stored again, so pending transactions pick up their changes. Unless full
+// is set, paging stops at the first page holding a transaction entered
+// before the oldest stored pending transaction, or before the newest stored
+// transaction if none is pending. The dates are compared for every
+// transaction of a page instead of relying on the order within it.
+func syncUser(db *sql.DB, app, user string, full bool) (*syncResult, error) {
+	accesses, err := session.userClient.Accesses.List().Send()
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := session.userClient.Accounts.List().Send()
+	if err != nil {
+		return nil, err
+	}
+	scheduled, err := session.userClient.ScheduledTransactions.List().Send()
+	if err != nil {
+		return nil, err
+	}
+	repeated, err := session.userClient.RepeatedTransactions.List().Send()
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[int64]bool{}
+	pending := map[int64]bool{}
+	var oldestPending, newest string
+	rows, err := db.Query(`SELECT id, entry_date, settlement_date FROM transactions WHERE app = ? AND user = ?`, app, user)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		var entry, settlement sql.NullString
+		if err := rows.Scan(&id, &entry, &settlement); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		known[id] = true
+		if entry.String > newest {
+			newest = entry.String
+		}
+		if !settlement.Valid {
+			pending[id] = true
+			if oldestPending == "" || entry.String < oldestPending {
+				oldestPending = entry.String
+			}
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	since := newest
+	if len(pending) > 0 {
+		since = oldestPending
+	}
+
+	var txs []bosgo.Transaction
+	for offset := 0; ; {
+		req := session.userClient.Transactions.List().Limit(transactionPageSize)
+		if offset > 0 {
+			req.Offset(offset)
+		}
+		page, err := req.Send()
+		if err != nil {
+			return nil, err
+		}
+		if len(page.Transactions) == 0 {
+			break
+		}
+		txs = append(txs, page.Transactions...)
+		offset += len(page.Transactions)
+		if !full && since != "" && enteredBefore(page.Transactions, since) {
+			break
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	res := &syncResult{File: cachePath()}
+	for _, table := range []string{"accesses", "accounts", "scheduled_transactions", "repeated_transactions"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE app = ? AND user = ?`, app, user); err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range accesses.Accesses {
+		if _, err := tx.Exec(`INSERT INTO accesses VALUES (?, ?, ?, ?, ?, ?)`,
+			app, user, a.ID, a.Name, a.ProviderID, a.Enabled); err != nil {
+			return nil, err
+		}
+		res.Accesses++
+	}
+	accountIDs := make([]interface{}, 0, len(accounts.Accounts))
+	for _, a := range accounts.Accounts {
+		if _, err := tx.Exec(`INSERT INTO accounts VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			app, user, a.ID, a.BankAccessID, a.ProviderID, a.Name, string(a.Type), a.Number, a.IBAN, a.Currency,
+			sqlAmount(a.Balance), sqlDate(a.BalanceDate), a.Enabled); err != nil {
+			return nil, err
+		}
+		accountIDs = append(accountIDs, a.ID)
+		res.Accounts++
+	}
+	for _, t := range txs {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO transactions VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			app, user, t.ID, t.UserAccount.ID, sqlDate(t.EntryDate), sqlDate(t.SettlementDate),
+			sqlAmount(t.Value.Value), t.Value.Currency, t.Counterparty.Name, t.Counterparty.Account.IBAN, t.Usage,
+			t.TransactionType, t.CategoryID, t.RepeatedTransactionID); err != nil {
+			return nil, err
+		}
+		if !known[t.ID] {
+			res.NewTransactions++
+		}
+		delete(pending, t.ID)
+	}
+	// Pending transactions that are no longer returned were dropped by the
+	// bank.
+	for id := range pending {
+		if _, err := tx.Exec(`DELETE FROM transactions WHERE app = ? AND user = ? AND id = ?`, app, user, id); err != nil {
+			return nil, err
+		}
+	}
+	// Transactions of accounts that no longer exist are removed.
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(accountIDs)), ", ")
+	if _, err := tx.Exec(`DELETE FROM transactions WHERE app = ? AND user = ? AND account_id NOT IN (`+placeholders+`)`,
+		append([]interface{}{app, user}, accountIDs...)...); err != nil {
+		return nil, err
+	}
+	for _, t := range scheduled.ScheduledTransactions {
+		if _, err := tx.Exec(`INSERT INTO scheduled_transactions VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			app, user, t.ID, t.UserAccount.ID, sqlDate(t.BookingDate), sqlAmount(t.Amount.Value), t.Amount.Currency,
+			t.Counterparty.Name, t.Counterparty.Account.IBAN, t.Usage); err != nil {
+			return nil, err
+		}
+		res.ScheduledTransactions++
+	}
+	for _, t := range repeated.RepeatedTransactions {
+		if _, err := tx.Exec(`INSERT INTO repeated_transactions VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			app, user, t.ID, t.UserAccount.ID, sqlAmount(t.Amount.Value), t.Amount.Currency,
+			t.Counterparty.Name, t.Counterparty.Account.IBAN, t.Usage); err != nil {
+			return nil, err
+		}
+		res.RepeatedTransactions++
+	}
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO syncs VALUES (?, ?, ?)`, app, user, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return nil, err
+	}
+	return res, tx.Commit()
+}
+
+// enteredBefore reports whether any of txs was entered before the date
+// since, given as yyyy-mm-dd.
+func enteredBefore(txs []bosgo.Transaction, since string) bool {
+	for _, t := range txs {
+		if t.EntryDate.Format("2006-01-02") < since {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlAmount converts an amount of the api to a number, or NULL if it is not
+// a valid number.
+func sqlAmount(s string) interface{} {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+func sqlDate(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Format("2006-01-02")
+}
+
+func sqlQuery(c *ishell.Context) {
+	// The query is taken from the source so that single quotes around
+	// string literals are kept.
+	query := strings.TrimSpace(joinSource(sourceArgs(c), '\''))
+	if query == "" {
+		c.Err(validationErrorf("usage: sql QUERY, e.g. sql select * from accounts"))
+		return
+	}
+
+	db, err := openCache()
+	if err != nil {
+		c.Err(err)
+		return
+	}
+	defer db.Close()
+
+	cols, rows, err := queryCache(db, query)
+	if err != nil {
+		c.Err(queryError(err))
+		return
+	}
+	if structuredOutput(c) {
+		printResult(c, rows)
+		return
+	}
+	lastResult = rows
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.ToUpper(strings.Join(cols, "\t")))
+	for _, row := range rows {
+		values := make([]string, len(cols))
+		for i, col := range cols {
+			if v := row[col]; v != nil {
+				values[i] = strings.Replace(fmt.Sprint(v), "\n", " ", -1)
+			}
+		}
+		fmt.Fprintln(w, strings.Join(values, "\t"))
+	}
+	w.Flush()
+	c.Print(buf.String())
+}
+
+// queryError classifies an error of a query. SQLite reports malformed
+// queries as syntax errors or incomplete input, anything else, like a
+// missing table, is a validation error.
+func queryError(err error) error {
+	if msg := err.Error(); strings.Contains(msg, "syntax error") || strings.Contains(msg, "incomplete input") {
+		return syntaxError(err)
+	}
+	return validationError(err)
+}
+
+// queryCache runs a query and returns the names of its columns and its rows.
+func queryCache(db *sql.DB, query string) ([]string, []map[string]interface{}, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	result := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, err
+		}
+		row := make(map[string]interface{}, len(cols))
+		for i, col := range cols {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[col] = values[i]
+		}
+		result = append(result, row)
+	}
+	return cols, result, rows.Err()
+}
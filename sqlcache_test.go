@@ -0,0 +1,129 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"code.bankrs.com/bosgo"
+	"github.com/abiosoft/ishell"
+)
+
+func TestSyncAndSQL(t *testing.T) {
+	h := newHarness(t)
+	h.loginUser()
+
+	out := h.mustRun("", "sync")
+	h.contains(out, "Synced 1 accesses, 2 accounts, 6 new transactions, 1 scheduled and 2 repeated transactions to ")
+	h.contains(h.mustRun("", "sync"), "0 new transactions")
+	h.contains(h.mustRun("", "sync", "--full"), "0 new transactions")
+
+	// Stored pending transactions are updated, and dropped if the api no
+	// longer returns them, while paging back to the oldest of them.
+	db, err := openCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`UPDATE transactions SET settlement_date = NULL, usage = 'stale' WHERE usage = 'Salary November'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO transactions (app, user, id, entry_date) VALUES ('demo-key', 'alice', 999, '2018-11-20')`); err != nil {
+		t.Fatal(err)
+	}
+	// A transaction of an account that is gone.
+	if _, err := db.Exec(`INSERT INTO transactions (app, user, id, account_id, entry_date, settlement_date) VALUES ('demo-key', 'alice', 998, 4242, '2018-11-20', '2018-11-20')`); err != nil {
+		t.Fatal(err)
+	}
+	requests := strings.Count(h.log.String(), "GET /v1/transactions?")
+	h.contains(h.mustRun("", "sync"), "0 new transactions")
+	if n := strings.Count(h.log.String(), "GET /v1/transactions?") - requests; n != 2 {
+		t.Errorf("got %d requests for transactions, want 2 to reach the oldest pending transaction", n)
+	}
+	var usage string
+	var settled sql.NullString
+	if err := db.QueryRow(`SELECT usage, settlement_date FROM transactions WHERE usage LIKE 'Salary%' OR usage = 'stale'`).Scan(&usage, &settled); err != nil {
+		t.Fatal(err)
+	}
+	if usage != "Salary November" || !settled.Valid {
+		t.Errorf("got %q settled on %v, want the pending transaction updated", usage, settled)
+	}
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM transactions WHERE id = 999`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("got %d rows of the dropped pending transaction, %v", n, err)
+	}
+	if err := db.QueryRow(`SELECT count(*) FROM transactions WHERE account_id = 4242`).Scan(&n); err != nil || n != 0 {
+		t.Errorf("got %d rows of a removed account, %v", n, err)
+	}
+	requests = strings.Count(h.log.String(), "GET /v1/transactions?")
+	h.mustRun("", "sync")
+	if n := strings.Count(h.log.String(), "GET /v1/transactions?") - requests; n != 1 {
+		t.Errorf("got %d requests for transactions, want 1 without pending transactions", n)
+	}
+
+	min, max := waitMinInterval, waitMaxInterval
+	waitMinInterval, waitMaxInterval = time.Millisecond, time.Millisecond
+	defer func() { waitMinInterval, waitMaxInterval = min, max }()
+	h.mustRun("12345\ny\nsecret\ny\n", "addaccess", "GB-TEST", "--wait")
+	h.contains(h.mustRun("", "sync"), "2 accesses, 3 accounts")
+	if res := lastResult.(*syncResult); res.NewTransactions == 0 {
+		t.Errorf("got no new transactions after adding an access: %+v", res)
+	}
+
+	// The database can be queried without a session.
+	h.mustRun("", "logoutuser")
+	out, err = h.runScript("sql select name, balance from accounts where iban like 'DE%' order by id\n", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.contains(out, "NAME       BALANCE", "Girokonto  1024.5", "Tagesgeld  5553.16")
+	h.contains(h.mustRun("", "sql", "select", "name from accounts where iban like 'DE%' order by id"), "Girokonto")
+	line := `sql select name from accounts where name = 'Tagesgeld' and "iban" like 'DE%'`
+	c := &ishell.Context{
+		Args:    []string{"select", "name", "from", "accounts", "where", "name", "=", "Tagesgeld", "and", "iban", "like", "DE%"},
+		RawArgs: strings.Fields(line),
+	}
+	if got, want := joinSource(sourceArgs(c), '\''), strings.TrimPrefix(strings.Replace(line, `"`, "", -1), "sql "); got != want {
+		t.Errorf("got query %q, want %q", got, want)
+	}
+	h.mustRun("", "sql", "select count(*) as n, sum(amount) as total from transactions where account_id =",
+		"(select id from accounts where name = 'Girokonto')")
+	if row := lastResult.([]map[string]interface{})[0]; row["n"] != int64(5) || row["total"] != 1024.5 {
+		t.Errorf("got %v for the transactions of the Girokonto, want 5 with a total of 1024.5", row)
+	}
+	h.contains(h.mustRun("", "sql", "select user, app from syncs"), "alice  demo-key")
+
+	h.fails(exitValidation, "no such table", "", "sql", "select * from missing")
+	h.fails(exitSyntax, "syntax error", "", "sql", "selec * from accounts")
+	h.fails(exitSyntax, "incomplete input", "", "sql", "select * from")
+	h.fails(exitValidation, "usage: sql", "", "sql")
+}
+
+func TestEnteredBefore(t *testing.T) {
+	date := func(s string) bosgo.Transaction {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bosgo.Transaction{EntryDate: d}
+	}
+	// The oldest transaction is not the last one of the page.
+	page := []bosgo.Transaction{date("2018-11-12"), date("2018-11-01"), date("2018-11-05")}
+	tests := []struct {
+		since string
+		want  bool
+	}{
+		{"2018-11-01", false},
+		{"2018-11-02", true},
+		{"2018-11-12", true},
+	}
+	for _, tt := range tests {
+		if got := enteredBefore(page, tt.since); got != tt.want {
+			t.Errorf("enteredBefore(%s) = %v, want %v", tt.since, got, tt.want)
+		}
+	}
+	if enteredBefore(nil, "2018-11-01") {
+		t.Error("got true for an empty page")
+	}
+}
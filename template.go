@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var templateFlag = flag.String("template", "", "Go template used to print each result, either inline or the name of a file in the templates config directory")
+
+func init() {
+	flag.StringVar(templateFlag, "t", "", "shorthand for -template")
+}
+
+// outputTemplate is the template used to print results instead of the
+// output format. It is nil when no template is set.
+var outputTemplate *template.Template
+
+// templateFuncs are the helper functions available in output templates.
+var templateFuncs = template.FuncMap{
+	"money":    formatMoney,
+	"date":     formatDate,
+	"maskiban": maskIBAN,
+}
+
+// setTemplate selects the template for printing results. Text containing
+// {{ is used as the template itself, otherwise it names a template file
+// NAME.tmpl in the templates directory of the config dir. An empty value or
+// "none" removes the template.
+func setTemplate(text string) error {
+	if text == "" || text == "none" {
+		outputTemplate = nil
+		return nil
+	}
+
+	name := "inline"
+	if !strings.Contains(text, "{{") {
+		name = text
+		data, err := ioutil.ReadFile(filepath.Join(configDir(), "templates", name+".tmpl"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return validationErrorf("unknown template %q", name)
+			}
+			return err
+		}
+		text = strings.TrimRight(string(data), "\n")
+	}
+
+	t, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return validationError(err)
+	}
+	outputTemplate = t
+	return nil
+}
+
+// formatTemplate executes the template once for each element of a list
+// result, or once for any other result, writing one line for each.
+func formatTemplate(t *template.Template, v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, elem := range templateElems(v) {
+		if err := t.Execute(&buf, elem); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// templateElems returns the values a template is applied to. Slices and
+// structs holding a single slice, such as the page of accounts returned by
+// the accounts command, yield their elements.
+func templateElems(v interface{}) []interface{} {
+	if n, ok := v.(map[string]interface{}); ok {
+		return rowsOf(n)
+	}
+
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() == reflect.Struct {
+		var list reflect.Value
+		for i := 0; i < rv.NumField(); i++ {
+			if rv.Type().Field(i).PkgPath != "" || rv.Field(i).Kind() != reflect.Slice {
+				continue
+			}
+			if list.IsValid() {
+				return []interface{}{v}
+			}
+			list = rv.Field(i)
+		}
+		if !list.IsValid() {
+			return []interface{}{v}
+		}
+		rv = list
+	}
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []interface{}{v}
+	}
+
+	elems := make([]interface{}, rv.Len())
+	for i := range elems {
+		elems[i] = rv.Index(i).Interface()
+	}
+	return elems
+}
+
+// formatMoney formats an amount with two decimals and thousands separators,
+// followed by the currency if given. The amount may be a number, a numeric
+// string or a struct with Value and Currency fields.
+func formatMoney(amount interface{}, currency ...string) (string, error) {
+	rv := reflect.Indirect(reflect.ValueOf(amount))
+	if rv.Kind() == reflect.Struct {
+		value, cur := rv.FieldByName("Value"), rv.FieldByName("Currency")
+		if value.IsValid() && cur.IsValid() {
+			amount = value.Interface()
+			if len(currency) == 0 {
+				currency = []string{fmt.Sprint(cur.Interface())}
+			}
+		}
+	}
+
+	// Amounts are converted to cents exactly, so they are rounded the same
+	// way as in exported statements.
+	var cents int64
+	var err error
+	switch a := amount.(type) {
+	case float64:
+		cents, err = parseCents(strconv.FormatFloat(a, 'f', -1, 64))
+	case int:
+		cents = int64(a) * 100
+	case int64:
+		cents = a * 100
+	default:
+		cents, err = parseCents(fmt.Sprint(amount))
+	}
+	if err != nil {
+		return "", fmt.Errorf("money: invalid amount %q", strings.TrimSpace(fmt.Sprint(amount)))
+	}
+
+	s := formatCents(cents, ".")
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+	}
+	whole, frac := s[:len(s)-3], s[len(s)-3:]
+	var grouped []string
+	for len(whole) > 3 {
+		grouped = append([]string{whole[len(whole)-3:]}, grouped...)
+		whole = whole[:len(whole)-3]
+	}
+	grouped = append([]string{whole}, grouped...)
+	s = sign + strings.Join(grouped, ",") + frac
+
+	if len(currency) > 0 && currency[0] != "" {
+		s += " " + currency[0]
+	}
+	return s, nil
+}
+
+// formatDate formats a time or an RFC 3339 timestamp using layout, which
+// defaults to 2006-01-02. Zero times are formatted as an empty string.
+func formatDate(v interface{}, layout ...string) (string, error) {
+	l := "2006-01-02"
+	if len(layout) > 0 {
+		l = layout[0]
+	}
+
+	var t time.Time
+	switch v := v.(type) {
+	case time.Time:
+		t = v
+	case *time.Time:
+		if v == nil {
+			return "", nil
+		}
+		t = *v
+	case string:
+		if v == "" {
+			return "", nil
+		}
+		var err error
+		if t, err = time.Parse(time.RFC3339, v); err != nil {
+			return "", fmt.Errorf("date: invalid time %q", v)
+		}
+	default:
+		return "", fmt.Errorf("date: cannot format %T", v)
+	}
+	if t.IsZero() {
+		return "", nil
+	}
+	return t.Format(l), nil
+}
+
+// maskIBAN hides all but the country code, check digits and the last four
+// characters of an IBAN.
+func maskIBAN(iban string) string {
+	s := strings.Replace(iban, " ", "", -1)
+	if len(s) <= 8 {
+		return s
+	}
+	return s[:4] + strings.Repeat("*", len(s)-8) + s[len(s)-4:]
+}
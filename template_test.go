@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTemplateOutput(t *testing.T) {
+	h := newHarness(t)
+	h.loginUser()
+
+	tests := []struct {
+		template string
+		cmd      string
+		want     string
+	}{
+		{
+			template: "{{.Name}} {{money .Balance .Currency}} {{maskiban .IBAN}}",
+			cmd:      "accounts",
+			want:     "Girokonto 1,024.50 EUR DE89**************3000\nTagesgeld 5,553.16 EUR DE75**************6199\n",
+		},
+		{
+			// Query results use the JSON field names.
+			template: "{{.name}} {{money .balance .currency}} {{maskiban .iban}}",
+			cmd:      `accounts | query .accounts[] | select(.currency == "EUR")`,
+			want:     "Girokonto 1,024.50 EUR DE89**************3000\nTagesgeld 5,553.16 EUR DE75**************6199\n",
+		},
+		{
+			template: "{{.Name}}",
+			cmd:      `accounts | query .accounts[0]`,
+			want:     "<no value>\n",
+		},
+		{
+			template: "{{.}}",
+			cmd:      `accounts | query .accounts[].id`,
+			want:     "2\n11\n",
+		},
+	}
+	for _, tt := range tests {
+		if err := setTemplate(tt.template); err != nil {
+			t.Fatal(err)
+		}
+		out, err := h.runScript(tt.cmd+"\n", true)
+		if err != nil {
+			t.Errorf("%s: %v", tt.cmd, err)
+			continue
+		}
+		if out != tt.want {
+			t.Errorf("%s with %s: got %q, want %q", tt.cmd, tt.template, out, tt.want)
+		}
+	}
+}
+
+func TestSetTemplate(t *testing.T) {
+	newHarness(t)
+	dir := filepath.Join(configDir(), "templates")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "ids.tmpl"), []byte("id {{.}}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := setTemplate("ids"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := formatTemplate(outputTemplate, []int{1, 2})
+	if err != nil || string(out) != "id 1\nid 2\n" {
+		t.Errorf("got %q, %v, want the template file applied to each element", out, err)
+	}
+
+	for _, text := range []string{"missing", "{{.Name"} {
+		if err := setTemplate(text); exitCode(err) != exitValidation {
+			t.Errorf("setTemplate(%q): got %v, want a validation error", text, err)
+		}
+	}
+
+	if err := setTemplate("none"); err != nil || outputTemplate != nil {
+		t.Errorf("got %v, %v, want no template", outputTemplate, err)
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	type amount struct {
+		Value    string
+		Currency string
+	}
+	money := []struct {
+		amount   interface{}
+		currency []string
+		want     string
+		err      bool
+	}{
+		{amount: 1234567.891, want: "1,234,567.89"},
+		{amount: 0, want: "0.00"},
+		{amount: int64(-1000), want: "-1,000.00"},
+		{amount: json.Number("-62.35"), currency: []string{"EUR"}, want: "-62.35 EUR"},
+		{amount: "999.999", want: "1,000.00"},
+		{amount: "0.125", want: "0.12"},
+		{amount: json.Number("0.135"), want: "0.14"},
+		{amount: json.Number("-2.675"), want: "-2.68"},
+		{amount: 2.675, want: "2.68"},
+		{amount: "-0.001", want: "0.00"},
+		{amount: " 12345678901234.56 ", want: "12,345,678,901,234.56"},
+		{amount: amount{"2500.00", "EUR"}, want: "2,500.00 EUR"},
+		{amount: &amount{"1", "EUR"}, currency: []string{"USD"}, want: "1.00 USD"},
+		{amount: "ten", err: true},
+	}
+	for _, tt := range money {
+		got, err := formatMoney(tt.amount, tt.currency...)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("money %v %v = %q, %v, want %q", tt.amount, tt.currency, got, err, tt.want)
+		}
+	}
+
+	day := time.Date(2018, 11, 30, 12, 0, 0, 0, time.UTC)
+	var none *time.Time
+	dates := []struct {
+		v      interface{}
+		layout []string
+		want   string
+		err    bool
+	}{
+		{v: day, want: "2018-11-30"},
+		{v: &day, layout: []string{"02.01.2006"}, want: "30.11.2018"},
+		{v: none, want: ""},
+		{v: time.Time{}, want: ""},
+		{v: "2018-11-30T12:00:00Z", want: "2018-11-30"},
+		{v: "", want: ""},
+		{v: "yesterday", err: true},
+		{v: 42, err: true},
+	}
+	for _, tt := range dates {
+		got, err := formatDate(tt.v, tt.layout...)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("date %v %v = %q, %v, want %q", tt.v, tt.layout, got, err, tt.want)
+		}
+	}
+
+	for iban, want := range map[string]string{
+		"DE89 3704 0044 0532 0130 00": "DE89**************3000",
+		"DE89370400440532013000":      "DE89**************3000",
+		"DE123456":                    "DE123456",
+	} {
+		if got := maskIBAN(iban); got != want {
+			t.Errorf("maskiban %s = %s, want %s", iban, got, want)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abiosoft/ishell"
+)
+
+// scenario is the outcome of running a single test script.
+type scenario struct {
+	name     string
+	file     string
+	duration time.Duration
+	output   string
+	err      error
+}
+
+// runTests implements the test subcommand. It runs every script named on the
+// command line, or found in the named directories, as a separate scenario
+// and reports the results. It returns the exit code for the process.
+func runTests(shell *ishell.Shell, args []string) int {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	format := fs.String("format", "text", "report format: text, tap or junit")
+	out := fs.String("o", "", "filename to write the report to instead of standard output")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: bosh [flags] test [-format text|tap|junit] [-o file] path...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitSyntax
+	}
+
+	var report func(io.Writer, []scenario) error
+	switch *format {
+	case "text":
+		report = reportText
+	case "tap":
+		report = reportTAP
+	case "junit":
+		report = reportJUnit
+	default:
+		fmt.Fprintf(os.Stderr, "unknown report format %q\n", *format)
+		return exitSyntax
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := findScripts(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitGeneral
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "no .bosh scripts found")
+		return exitSyntax
+	}
+
+	results := make([]scenario, 0, len(files))
+	for _, file := range files {
+		results = append(results, runScenario(shell, file))
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitGeneral
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := report(w, results); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitGeneral
+	}
+
+	for _, r := range results {
+		if r.err != nil {
+			return exitGeneral
+		}
+	}
+	return exitOK
+}
+
+// expect checks the result of the last command. Its arguments are one of
+//
+//	PATH               the path exists and is not null
+//	PATH OP VALUE      the value at path compares to value, see compare
+//	count PATH OP N    the number of elements in the list at path compares to n
+//
+// A path of "." refers to the whole result.
+func expect(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: expect [count] PATH [OP VALUE]")
+	}
+	if lastResult == nil {
+		return fmt.Errorf("expect: no result to check")
+	}
+	res, err := normalize(lastResult)
+	if err != nil {
+		return err
+	}
+
+	expr := strings.Join(args, " ")
+	count := args[0] == "count"
+	if count {
+		args = args[1:]
+	}
+	if len(args) != 1 && len(args) != 3 {
+		return fmt.Errorf("usage: expect [count] PATH [OP VALUE]")
+	}
+
+	path := args[0]
+	if path == "." {
+		path = ""
+	}
+	v, err := lookupPath(res, path)
+	if err != nil {
+		return fmt.Errorf("expectation failed: %s: %v", args[0], err)
+	}
+
+	if count {
+		list, err := listOf(v)
+		if err != nil {
+			return fmt.Errorf("expectation failed: %s: %v", args[0], err)
+		}
+		v = json.Number(strconv.Itoa(len(list)))
+	}
+
+	if len(args) == 1 {
+		if v == nil {
+			return fmt.Errorf("expectation failed: %s is null", args[0])
+		}
+		return nil
+	}
+
+	got := formatValue(v)
+	ok, err := compare(got, args[1], args[2])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("expectation failed: %s (got %q)", expr, got)
+	}
+	return nil
+}
+
+// findScripts expands directories in paths to the .bosh scripts they contain.
+func findScripts(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && filepath.Ext(p) == ".bosh" {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// runScenario runs a script with a fresh session and captures its output.
+func runScenario(shell *ishell.Shell, file string) (sc scenario) {
+	sc = scenario{
+		name: strings.TrimSuffix(filepath.ToSlash(file), ".bosh"),
+		file: file,
+	}
+
+	resetContexts()
+	lastResult = nil
+
+	var buf bytes.Buffer
+	shell.SetOut(&buf)
+	defer shell.SetOut(os.Stdout)
+
+	start := time.Now()
+	defer func() {
+		sc.duration = time.Since(start)
+		sc.output = buf.String()
+	}()
+	f, err := os.Open(file)
+	if err != nil {
+		sc.err = err
+		return sc
+	}
+	defer f.Close()
+
+	in := newInterpreter(shell, file)
+	in.errOut = &buf
+	sc.err = in.run(f)
+	return sc
+}
+
+func reportText(w io.Writer, results []scenario) error {
+	failed := 0
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+			fmt.Fprintf(w, "FAIL %s (%.2fs)\n     %v\n", r.name, r.duration.Seconds(), r.err)
+			continue
+		}
+		fmt.Fprintf(w, "PASS %s (%.2fs)\n", r.name, r.duration.Seconds())
+	}
+	_, err := fmt.Fprintf(w, "%d scenarios, %d passed, %d failed\n", len(results), len(results)-failed, failed)
+	return err
+}
+
+// reportTAP writes results using the Test Anything Protocol, version 13.
+func reportTAP(w io.Writer, results []scenario) error {
+	fmt.Fprintln(w, "TAP version 13")
+	fmt.Fprintf(w, "1..%d\n", len(results))
+	for i, r := range results {
+		if r.err == nil {
+			fmt.Fprintf(w, "ok %d - %s\n", i+1, r.name)
+			continue
+		}
+		fmt.Fprintf(w, "not ok %d - %s\n", i+1, r.name)
+		fmt.Fprintln(w, "  ---")
+		fmt.Fprintf(w, "  message: %q\n", r.err.Error())
+		fmt.Fprintf(w, "  file: %q\n", r.file)
+		fmt.Fprintf(w, "  duration_ms: %d\n", r.duration.Nanoseconds()/1e6)
+		if r.output != "" {
+			fmt.Fprintln(w, "  output: |")
+			for _, line := range strings.Split(strings.TrimRight(r.output, "\n"), "\n") {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+		}
+		fmt.Fprintln(w, "  ...")
+	}
+	return nil
+}
+
+type junitSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// reportJUnit writes results as JUnit XML as understood by most CI systems.
+func reportJUnit(w io.Writer, results []scenario) error {
+	suite := junitSuite{
+		Name:  "bosh",
+		Tests: len(results),
+	}
+	var total time.Duration
+	for _, r := range results {
+		total += r.duration
+		tc := junitCase{
+			Name:      r.name,
+			Classname: "bosh",
+			Time:      fmt.Sprintf("%.3f", r.duration.Seconds()),
+			SystemOut: r.output,
+		}
+		if r.err != nil {
+			suite.Failures++
+			tc.Failure = &junitFailure{Message: r.err.Error(), Text: r.err.Error()}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Time = fmt.Sprintf("%.3f", total.Seconds())
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitSuites{Suites: []junitSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// testdata is the absolute path of the golden files, tests may change the
+// working directory.
+var testdata, _ = filepath.Abs("testdata")
+
+// golden compares got with the content of testdata/name, or writes it there
+// if -update is given.
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join(testdata, name)
+	if *update {
+		if err := ioutil.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s does not match, got:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+// writeScenarios creates a passing and a failing scenario in a temporary
+// directory and changes into it.
+func writeScenarios(t *testing.T) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "bosh-scenarios")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	})
+	scripts := map[string]string{
+		"pass.bosh":          "echo hello\nassert 1 == 1\n",
+		"fail.bosh":          "echo before\nassert 1 == 2\necho after\n",
+		"ignored.txt":        "assert 1 == 2\n",
+		"nested/inner.bosh":  "set x <a&b>\nassert $x == '<a&b>'\n",
+		"nested/broken.bosh": "if true\necho unterminated\n",
+	}
+	for name, script := range scripts {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(script), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReports(t *testing.T) {
+	h := newHarness(t)
+	writeScenarios(t)
+
+	files, err := findScripts([]string{"fail.bosh", "pass.bosh", "nested"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []scenario
+	for i, file := range files {
+		r := runScenario(h.shell, file)
+		r.duration = time.Duration(i+1) * 1250 * time.Millisecond
+		results = append(results, r)
+	}
+
+	for _, tt := range []struct {
+		file   string
+		report func(io.Writer, []scenario) error
+	}{
+		{"report.txt", reportText},
+		{"report.tap", reportTAP},
+		{"report.xml", reportJUnit},
+	} {
+		var buf bytes.Buffer
+		if err := tt.report(&buf, results); err != nil {
+			t.Fatal(err)
+		}
+		golden(t, tt.file, buf.Bytes())
+	}
+}
+
+func TestRunTests(t *testing.T) {
+	h := newHarness(t)
+	writeScenarios(t)
+
+	if code := runTests(h.shell, []string{"-format", "tap", "-o", "pass.tap", "pass.bosh"}); code != exitOK {
+		t.Errorf("got exit code %d for a passing scenario, want %d", code, exitOK)
+	}
+	if code := runTests(h.shell, []string{"-format", "junit", "-o", "all.xml"}); code != exitGeneral {
+		t.Errorf("got exit code %d for a failing scenario, want %d", code, exitGeneral)
+	}
+	for file, want := range map[string]string{
+		"pass.tap": "TAP version 13\n1..1\nok 1 - pass\n",
+		"all.xml":  `<testsuite name="bosh" tests="4" failures="2"`,
+	} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s does not contain %q:\n%s", file, want, data)
+		}
+	}
+
+	for _, tt := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"-format", "html", "."}, exitSyntax},
+		{[]string{"-bogus"}, exitSyntax},
+		{[]string{"nested/empty"}, exitSyntax},
+		{[]string{"missing.bosh"}, exitGeneral},
+		{[]string{"-o", "nested/pass.bosh/report.txt", "pass.bosh"}, exitGeneral},
+	} {
+		if err := os.MkdirAll("nested/empty", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if code := runTests(h.shell, tt.args); code != tt.code {
+			t.Errorf("test %s: got exit code %d, want %d", strings.Join(tt.args, " "), code, tt.code)
+		}
+	}
+}
+
+func TestRunScenario(t *testing.T) {
+	h := newHarness(t)
+	writeScenarios(t)
+	scripts := map[string]string{
+		"login.bosh":   "useapp demo-key\nloginuser alice secret\naccounts\nexpect count .accounts == 2\n",
+		"session.bosh": "accounts\n",
+		"output.bosh":  "echo one\nassert 1 == 2\necho two\n",
+	}
+	for name, script := range scripts {
+		if err := ioutil.WriteFile(name, []byte(script), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if r := runScenario(h.shell, "login.bosh"); r.err != nil || r.name != "login" || r.duration <= 0 {
+		t.Errorf("got %+v, want a passing scenario", r)
+	}
+	// Every scenario starts with a fresh session.
+	if r := runScenario(h.shell, "session.bosh"); r.err == nil || !strings.Contains(r.err.Error(), "login as a user first") {
+		t.Errorf("got %v, want the scenario to run without the session of the previous one", r.err)
+	}
+	r := runScenario(h.shell, "output.bosh")
+	if r.output != "one\n" || r.err == nil || r.err.Error() != "output.bosh:2: assertion failed: 1 == 2" {
+		t.Errorf("got output %q and error %v", r.output, r.err)
+	}
+	if r := runScenario(h.shell, "missing.bosh"); r.err == nil || r.duration <= 0 {
+		t.Errorf("got %+v, want an error and a duration for a missing script", r)
+	}
+}
+
+// TestTestCommand runs bosh test as a separate process.
+func TestTestCommand(t *testing.T) {
+	writeScenarios(t)
+	api := []string{"-a", startServer(t), "-insecure"}
+	if err := ioutil.WriteFile("accounts.bosh", []byte("useapp demo-key\nloginuser alice secret\naccounts\nexpect .accounts[0].name == Girokonto\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, code := runBosh(t, tempDir(t), "", nil, with(api, "test", "-format", "tap", "accounts.bosh", "pass.bosh")...)
+	if code != exitOK || out != "TAP version 13\n1..2\nok 1 - accounts\nok 2 - pass\n" {
+		t.Errorf("got %q with exit code %d", out, code)
+	}
+	out, code = runBosh(t, tempDir(t), "", nil, with(api, "test", "fail.bosh")...)
+	if code != exitGeneral || !strings.Contains(out, "FAIL fail") {
+		t.Errorf("got %q with exit code %d, want the failing scenario", out, code)
+	}
+}
+
+func TestAssert(t *testing.T) {
+	h := newHarness(t)
+
+	for _, script := range []string{
+		"set n 3\nassert $n == 3",
+		"assert abc != abd",
+		"assert ab =~ ^a",
+		"assert ab !~ ^b",
+		"assert 2 < 10",
+		"assert 2 <= 2",
+		"assert 10 > 2",
+		"assert 2 >= 2",
+		"assert yes",
+		"assert not defined nothing",
+		"assert not ok getaccess 1",
+	} {
+		if _, err := h.runScript(script, true); err != nil {
+			t.Errorf("%q: %v", script, err)
+		}
+	}
+
+	tests := []struct {
+		script string
+		err    string
+	}{
+		{"set n 4\necho before\nassert $n == 3\necho after", "test.bosh:3: assertion failed: $n == 3"},
+		{"assert 10 < 2", "test.bosh:1: assertion failed: 10 < 2"},
+		{"assert ''", "test.bosh:1: assertion failed: "},
+		{"assert ok getaccess 1", "test.bosh:1: assertion failed: ok getaccess 1"},
+		{"assert", "test.bosh:1: missing condition"},
+		{"assert a < b", `test.bosh:1: <: expected a number, got "a"`},
+		{"assert a ~ b", `test.bosh:1: unknown operator "~"`},
+	}
+	for _, tt := range tests {
+		out, err := h.runScript(tt.script, true)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: got error %v, want %q", tt.script, err, tt.err)
+		}
+		if strings.Contains(out, "after") {
+			t.Errorf("%q: script continued after a failed assertion", tt.script)
+		}
+	}
+
+	// Without errexit failed assertions are reported and collected.
+	out, err := h.runScript("assert 1 == 2\nassert 3 == 3\nassert 4 > 5\necho done", false)
+	h.contains(out, "test.bosh:1: assertion failed: 1 == 2\n", "test.bosh:3: assertion failed: 4 > 5\n", "done\n")
+	want := "2 commands failed:\n  test.bosh:1: assertion failed: 1 == 2\n  test.bosh:3: assertion failed: 4 > 5"
+	if err == nil || err.Error() != want {
+		t.Errorf("got %v, want %q", err, want)
+	}
+	if code := exitCode(err); code != exitGeneral {
+		t.Errorf("got exit code %d, want %d", code, exitGeneral)
+	}
+}
+
+func TestExpect(t *testing.T) {
+	h := newHarness(t)
+
+	if _, err := h.runScript("expect .", true); err == nil || err.Error() != "test.bosh:1: expect: no result to check" {
+		t.Errorf("got %v, want an error without a result", err)
+	}
+
+	h.loginUser()
+	h.mustRun("", "accounts")
+	for _, args := range []string{
+		".",
+		".accounts[0].id",
+		".accounts[0].name == Girokonto",
+		".accounts[1].name != Girokonto",
+		".accounts[0].iban =~ ^DE[0-9]{20}$",
+		".accounts[0].iban !~ ^GB",
+		".accounts[0].balance > 1000",
+		".accounts[0].balance >= 1024.5",
+		".accounts[0].balance < 1024.51",
+		".accounts[-1].balance <= 5553.16",
+		".accounts[0].enabled == true",
+		"count .accounts == 2",
+		"count . >= 2",
+		"count .accounts != 0",
+	} {
+		if _, err := h.runScript("expect "+args, true); err != nil {
+			t.Errorf("expect %s: %v", args, err)
+		}
+	}
+
+	tests := []struct {
+		args string
+		err  string
+	}{
+		{".accounts[0].name == Tagesgeld", `expectation failed: .accounts[0].name == Tagesgeld (got "Girokonto")`},
+		{".accounts[0].balance > 2000", `expectation failed: .accounts[0].balance > 2000 (got "1024.50")`},
+		{"count .accounts < 2", `expectation failed: count .accounts < 2 (got "2")`},
+		{".accounts[0].iban =~ ^GB", `expectation failed: .accounts[0].iban =~ ^GB (got "DE89370400440532013000")`},
+		{".accounts[2]", "expectation failed: .accounts[2]: index 2 out of range"},
+		{".accounts[0].nothing", `expectation failed: .accounts[0].nothing: no field "nothing"`},
+		{"count .accounts[0].name == 1", "expectation failed: .accounts[0].name: string is not a list"},
+		{".accounts[0].name < 1", `<: expected a number, got "Girokonto"`},
+		{".accounts[0].name ~ x", `unknown operator "~"`},
+		{"", "usage: expect [count] PATH [OP VALUE]"},
+		{".accounts ==", "usage: expect [count] PATH [OP VALUE]"},
+	}
+	for _, tt := range tests {
+		_, err := h.runScript("expect "+tt.args, true)
+		if want := "test.bosh:1: " + tt.err; err == nil || err.Error() != want {
+			t.Errorf("expect %s: got error %v, want %q", tt.args, err, want)
+		}
+	}
+
+	h.mustRun("", "getaccount", "2")
+	if _, err := h.runScript("expect .alias", true); err != nil {
+		t.Errorf("expect .alias: %v", err)
+	}
+}
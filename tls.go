@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	caFile     = flag.String("ca", "", "file with PEM encoded CA certificates to trust instead of the system roots")
+	certFile   = flag.String("cert", "", "file with a PEM encoded client certificate for mutual TLS")
+	keyFile    = flag.String("key", "", "file with the PEM encoded private key of the client certificate")
+	tlsMin     = flag.String("tls-min", "", "minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	serverName = flag.String("server-name", "", "server name to send with SNI and to verify the certificate against")
+	pins       = flag.String("pin", "", "comma separated list of sha256 pins of server public keys, as base64 with an optional sha256/ prefix")
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsConfig returns the TLS configuration given by flags or the profile. It
+// returns nil if the defaults should be used.
+func tlsConfig() (*tls.Config, error) {
+	if !*insecure && *caFile == "" && *certFile == "" && *keyFile == "" && *tlsMin == "" && *serverName == "" && *pins == "" {
+		return nil, nil
+	}
+
+	cfg := &tls.Config{
+		InsecureSkipVerify: *insecure,
+		ServerName:         *serverName,
+	}
+
+	if *caFile != "" {
+		data, err := ioutil.ReadFile(*caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA certificates: %v", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(data) {
+			return nil, validationErrorf("no certificates found in %s", *caFile)
+		}
+	}
+
+	if *certFile != "" || *keyFile != "" {
+		if *certFile == "" || *keyFile == "" {
+			return nil, validationErrorf("both a client certificate and key are required for mutual TLS")
+		}
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if *tlsMin != "" {
+		v, ok := tlsVersions[*tlsMin]
+		if !ok {
+			return nil, validationErrorf("unknown TLS version %q, expected one of 1.0, 1.1, 1.2 or 1.3", *tlsMin)
+		}
+		cfg.MinVersion = v
+	}
+
+	if *pins != "" {
+		pinned := map[string]bool{}
+		for _, pin := range strings.Split(*pins, ",") {
+			pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+			if b, err := base64.StdEncoding.DecodeString(pin); err != nil || len(b) != sha256.Size {
+				return nil, validationErrorf("invalid pin %q, expected a base64 encoded sha256 hash", pin)
+			}
+			pinned[pin] = true
+		}
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return checkPins(rawCerts, pinned)
+		}
+	}
+
+	return cfg, nil
+}
+
+// checkPins verifies that the public key of one of the certificates sent by
+// the server is pinned. Pinning the key of an intermediate or root
+// certificate allows the server certificate to be renewed.
+func checkPins(rawCerts [][]byte, pinned map[string]bool) error {
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		if pinned[base64.StdEncoding.EncodeToString(sum[:])] {
+			return nil
+		}
+	}
+	return fmt.Errorf("none of the server's public keys is pinned")
+}
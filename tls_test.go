@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"flag"
+	"testing"
+)
+
+// serverPin returns the pin of the public key of the server at addr.
+func serverPin(t *testing.T, addr string) string {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	sum := sha256.Sum256(conn.ConnectionState().PeerCertificates[0].RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestPins(t *testing.T) {
+	h := newHarness(t)
+	defer flag.Set("pin", "")
+
+	pin := serverPin(t, flag.Lookup("a").Value.String())
+	other := sha256.Sum256([]byte("another key"))
+	otherPin := base64.StdEncoding.EncodeToString(other[:])
+
+	tests := []struct {
+		name string
+		pins string
+		ok   bool
+	}{
+		{"match", pin, true},
+		{"match with prefix", " sha256/" + pin + " ", true},
+		{"one of several", otherPin + ",sha256/" + pin, true},
+		{"mismatch", "sha256/" + otherPin, false},
+	}
+	for _, tt := range tests {
+		flag.Set("pin", tt.pins)
+		if err := connect(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if tt.ok {
+			h.mustRun("", "login", "dev@example.com", "secret")
+			continue
+		}
+		h.fails(exitNetwork, "none of the server's public keys is pinned", "", "login", "dev@example.com", "secret")
+	}
+
+	for _, bad := range []string{"sha256/abc", "not base64!", pin + ",", base64.StdEncoding.EncodeToString([]byte("short"))} {
+		flag.Set("pin", bad)
+		if _, err := tlsConfig(); exitCode(err) != exitValidation {
+			t.Errorf("pin %q: got %v, want a validation error", bad, err)
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	newHarness(t)
+	defer func() {
+		for _, name := range []string{"tls-min", "ca", "cert", "server-name"} {
+			flag.Set(name, "")
+		}
+	}()
+
+	flag.Set("tls-min", "1.2")
+	flag.Set("server-name", "gateway.internal")
+	cfg, err := tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS12 || cfg.ServerName != "gateway.internal" || !cfg.InsecureSkipVerify {
+		t.Errorf("got %+v", cfg)
+	}
+
+	tests := []struct {
+		flag, value string
+		code        int
+	}{
+		{"tls-min", "1.4", exitValidation},
+		{"cert", "client.pem", exitValidation},
+		{"ca", "/nonexistent/ca.pem", exitGeneral},
+	}
+	for _, tt := range tests {
+		flag.Set("tls-min", "")
+		flag.Set(tt.flag, tt.value)
+		if _, err := tlsConfig(); err == nil || exitCode(err) != tt.code {
+			t.Errorf("-%s %s: got %v, want exit code %d", tt.flag, tt.value, err, tt.code)
+		}
+		flag.Set(tt.flag, "")
+	}
+}
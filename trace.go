@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/abiosoft/ishell"
+)
+
+var traceFlag = flag.Bool("trace", false, "print every http request and response made to the api")
+
+// maxTraceBody limits the length of bodies printed by the tracer.
+const maxTraceBody = 4096
+
+// traceOut receives the trace output. It is kept separate from the results
+// printed by commands.
+var traceOut io.Writer = os.Stderr
+
+// tracingTransport prints requests and responses when tracing is turned on.
+type tracingTransport struct {
+	next http.RoundTripper
+
+	mu      sync.Mutex
+	enabled bool
+}
+
+// tracer is the transport of all api clients.
+var tracer = &tracingTransport{}
+
+func (t *tracingTransport) setEnabled(on bool) {
+	t.mu.Lock()
+	t.enabled = on
+	t.mu.Unlock()
+}
+
+func (t *tracingTransport) isEnabled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.enabled
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if !t.isEnabled() {
+		return next.RoundTrip(req)
+	}
+
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	start := time.Now()
+	resp, err := next.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--> %s %s\n", req.Method, redactURL(req.URL))
+	writeHeaders(&buf, req.Header)
+	writeBody(&buf, req.Header.Get("Content-Type"), reqBody)
+
+	if err != nil {
+		fmt.Fprintf(&buf, "<-- error after %s: %v\n", elapsed.Round(time.Millisecond), err)
+		t.print(buf.Bytes())
+		return nil, err
+	}
+
+	respBody, readErr := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	fmt.Fprintf(&buf, "<-- %s (%s)", resp.Status, elapsed.Round(time.Millisecond))
+	if id := requestID(resp.Header); id != "" {
+		fmt.Fprintf(&buf, " request id %s", id)
+	}
+	buf.WriteByte('\n')
+	writeHeaders(&buf, resp.Header)
+	writeBody(&buf, resp.Header.Get("Content-Type"), respBody)
+	t.print(buf.Bytes())
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	return resp, nil
+}
+
+func (t *tracingTransport) print(data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	traceOut.Write(data)
+}
+
+// requestID returns the id the api assigned to a request, if any.
+func requestID(h http.Header) string {
+	for _, name := range []string{"X-Request-Id", "X-Correlation-Id", "X-Amzn-Trace-Id"} {
+		if id := h.Get(name); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+func writeHeaders(w io.Writer, h http.Header) {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := strings.Join(h[name], ", ")
+		if secretHeader(name) {
+			value = redacted
+		}
+		fmt.Fprintf(w, "    %s: %s\n", name, value)
+	}
+}
+
+func writeBody(w io.Writer, contentType string, body []byte) {
+	if len(body) == 0 {
+		return
+	}
+	s := redactBody(contentType, body)
+	if len(s) > maxTraceBody {
+		// Don't cut a character in two.
+		n := maxTraceBody
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		s = s[:n] + fmt.Sprintf("... (%d bytes)", len(body))
+	}
+	for _, line := range strings.Split(s, "\n") {
+		fmt.Fprintf(w, "    %s\n", line)
+	}
+}
+
+// redacted replaces secrets in trace output.
+const redacted = "***"
+
+// secretHeader reports whether a header carries credentials.
+func secretHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"authorization", "cookie", "token", "secret", "password", "session"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretKey reports whether a field of a request or response holds a
+// secret such as a password, PIN or token.
+func secretKey(key string) bool {
+	key = strings.ToLower(key)
+	switch key {
+	case "pin", "tan", "otp", "key":
+		return true
+	}
+	for _, s := range []string{"password", "passwd", "secret", "token", "session_id", "sessionid", "credential_value"} {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactBody returns a body for printing with its secrets masked. JSON and
+// form encoded bodies are supported, others are printed as they are.
+func redactBody(contentType string, body []byte) string {
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		if v, err := url.ParseQuery(string(body)); err == nil {
+			return redactValues(v).Encode()
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return string(body)
+	}
+	data, err := json.MarshalIndent(redactJSON(v), "", "  ")
+	if err != nil {
+		return string(body)
+	}
+	return string(data)
+}
+
+// redactJSON masks the values of secret fields. Challenge answers, which
+// are objects with an id such as "pin" and a value, are masked as well.
+func redactJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if id, ok := v["id"].(string); ok && secretKey(id) {
+			if _, ok := v["value"]; ok {
+				v["value"] = redacted
+			}
+		}
+		for k, x := range v {
+			if secretKey(k) {
+				v[k] = redacted
+				continue
+			}
+			v[k] = redactJSON(x)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactJSON(v[i])
+		}
+	}
+	return v
+}
+
+func redactValues(v url.Values) url.Values {
+	for k := range v {
+		if secretKey(k) {
+			v[k] = []string{redacted}
+		}
+	}
+	return v
+}
+
+// redactURL returns a URL for printing with secret query parameters masked.
+func redactURL(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.String()
+	}
+	r := *u
+	r.RawQuery = strings.Replace(redactValues(u.Query()).Encode(), url.QueryEscape(redacted), redacted, -1)
+	return r.String()
+}
+
+func setTrace(c *ishell.Context) {
+	if len(c.Args) == 0 {
+		if tracer.isEnabled() {
+			c.Println("tracing is on")
+		} else {
+			c.Println("tracing is off")
+		}
+		return
+	}
+	switch c.Args[0] {
+	case "on":
+		tracer.setEnabled(true)
+	case "off":
+		tracer.setEnabled(false)
+	default:
+		c.Err(validationErrorf("usage: trace on|off"))
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRedactJSON(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`{"email":"dev@example.com","password":"secret"}`, `{"email":"dev@example.com","password":"***"}`},
+		{`{"user":{"name":"alice","new_password":"x","Session_ID":"abc"}}`, `{"user":{"Session_ID":"***","name":"alice","new_password":"***"}}`},
+		{`{"pin":1234,"tan":"5678","otp":"1","key":"k","keys":["a"]}`, `{"key":"***","keys":["a"],"otp":"***","pin":"***","tan":"***"}`},
+		{`{"access_token":{"value":"t"},"refresh_token":"r"}`, `{"access_token":"***","refresh_token":"***"}`},
+		{`{"answers":[{"id":"login","value":"alice"},{"id":"PIN","value":"1234"},{"id":"tan","value":"42"}]}`, `{"answers":[{"id":"login","value":"alice"},{"id":"PIN","value":"***"},{"id":"tan","value":"***"}]}`},
+		{`{"credentials":[{"credential_value":"x","name":"login"}]}`, `{"credentials":[{"credential_value":"***","name":"login"}]}`},
+		{`[{"id":"pin"},{"value":"1"}]`, `[{"id":"pin"},{"value":"1"}]`},
+		{`"password"`, `"password"`},
+	}
+	for _, tt := range tests {
+		dec := json.NewDecoder(strings.NewReader(tt.in))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(redactJSON(v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("redactJSON(%s) = %s, want %s", tt.in, data, tt.want)
+		}
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"https://api.example.com/v1/accounts", "https://api.example.com/v1/accounts"},
+		{"https://api.example.com/v1/accounts?limit=10", "https://api.example.com/v1/accounts?limit=10"},
+		{"https://api.example.com/v1/reset?token=abc&email=dev%40example.com", "https://api.example.com/v1/reset?email=dev%40example.com&token=***"},
+		{"https://api.example.com/v1/x?password=a&password=b&PIN=1", "https://api.example.com/v1/x?PIN=***&password=***"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := redactURL(u); got != tt.want {
+			t.Errorf("redactURL(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+		if u.String() != tt.in {
+			t.Errorf("redactURL changed %s to %s", tt.in, u)
+		}
+	}
+}
+
+func TestRedactHeadersAndBodies(t *testing.T) {
+	for name, secret := range map[string]bool{
+		"Authorization":    true,
+		"Cookie":           true,
+		"X-Session-Token":  true,
+		"X-Application-Id": false,
+		"Content-Type":     false,
+	} {
+		if secretHeader(name) != secret {
+			t.Errorf("secretHeader(%s) = %v, want %v", name, !secret, secret)
+		}
+	}
+
+	tests := []struct {
+		contentType string
+		body        string
+		want        string
+	}{
+		{"application/x-www-form-urlencoded", "username=alice&password=secret", "password=%2A%2A%2A&username=alice"},
+		{"application/json", `{"password":"secret"}`, "{\n  \"password\": \"***\"\n}"},
+		{"", `{"password":"secret"}`, "{\n  \"password\": \"***\"\n}"},
+		{"text/plain", "password=secret", "password=secret"},
+	}
+	for _, tt := range tests {
+		if got := redactBody(tt.contentType, []byte(tt.body)); got != tt.want {
+			t.Errorf("redactBody(%s, %s) = %q, want %q", tt.contentType, tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestWriteBodyTruncates(t *testing.T) {
+	// A two byte character straddles the limit.
+	body := strings.Repeat("a", maxTraceBody-1) + "ü" + strings.Repeat("a", 10)
+	var buf bytes.Buffer
+	writeBody(&buf, "text/plain", []byte(body))
+	got := strings.TrimSpace(buf.String())
+	want := strings.Repeat("a", maxTraceBody-1) + fmt.Sprintf("... (%d bytes)", len(body))
+	if got != want {
+		t.Errorf("got %q, want %q", got[len(got)-30:], want[len(want)-30:])
+	}
+	if !utf8.ValidString(got) {
+		t.Error("truncated body is not valid UTF-8")
+	}
+}
+
+func TestTrace(t *testing.T) {
+	h := newHarness(t)
+	var buf bytes.Buffer
+	prev := traceOut
+	traceOut = &buf
+	defer func() {
+		tracer.setEnabled(false)
+		traceOut = prev
+	}()
+
+	h.contains(h.mustRun("", "trace"), "tracing is off")
+	h.mustRun("", "trace", "on")
+	h.loginDev()
+	h.mustRun("", "trace", "off")
+	h.mustRun("", "login", "dev@example.com", "secret")
+
+	out := buf.String()
+	h.contains(out, "--> POST https://", "<-- 200 OK", `"password": "***"`)
+	if strings.Contains(out, "secret") {
+		t.Errorf("trace contains the password:\n%s", out)
+	}
+	if n := strings.Count(out, "--> "); n != 1 {
+		t.Errorf("got %d traced requests, want 1:\n%s", n, out)
+	}
+	h.fails(exitValidation, "usage: trace on|off", "", "trace", "maybe")
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.bankrs.com/bosgo"
+	"github.com/abiosoft/ishell"
+)
+
+// transactionFilter selects and orders the transactions listed by the
+// transactions command. The account is sent to the api, everything else is
+// applied to the transactions it returns. The limit and offset are sent to
+// the api too unless the transactions are filtered or sorted, then they
+// apply to the filtered and sorted list.
+type transactionFilter struct {
+	account  string
+	from, to string
+	min, max string
+	search   string
+	booked   bool
+	pending  bool
+	sort     string
+	limit    int
+	offset   int
+	all      bool
+
+	minAmount, maxAmount *float64
+	now                  time.Time
+}
+
+// transactionPageSize is the number of transactions requested per page
+// when all pages are requested without --limit.
+var transactionPageSize = 100
+
+func (f *transactionFilter) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&f.account, "account", "", "only list transactions of the account with this id")
+	fs.StringVar(&f.from, "from", "", "only list transactions entered on or after this date (yyyy-mm-dd)")
+	fs.StringVar(&f.to, "to", "", "only list transactions entered on or before this date (yyyy-mm-dd)")
+	fs.StringVar(&f.min, "min", "", "only list transactions with at least this amount")
+	fs.StringVar(&f.max, "max", "", "only list transactions with at most this amount")
+	fs.StringVar(&f.search, "search", "", "only list transactions whose counterparty or purpose contains this text")
+	fs.BoolVar(&f.booked, "booked", false, "only list booked transactions")
+	fs.BoolVar(&f.pending, "pending", false, "only list pending transactions")
+	fs.StringVar(&f.sort, "sort", "", "sort by date or amount, prefixed with - for descending order (default the order of the api, newest first)")
+	fs.IntVar(&f.limit, "limit", 0, "maximum number of transactions to list")
+	fs.IntVar(&f.offset, "offset", 0, "number of transactions to skip")
+	fs.BoolVar(&f.all, "all", false, "request all pages of transactions")
+}
+
+// check validates the flags and parses the amounts.
+func (f *transactionFilter) check() error {
+	for _, date := range []string{f.from, f.to} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return validationErrorf("expected a date in yyyy-mm-dd format: %v", err)
+		}
+	}
+	var err error
+	if f.minAmount, err = parseAmountFlag("min", f.min); err != nil {
+		return err
+	}
+	if f.maxAmount, err = parseAmountFlag("max", f.max); err != nil {
+		return err
+	}
+	if f.booked && f.pending {
+		return validationErrorf("--booked and --pending exclude each other")
+	}
+	switch strings.TrimPrefix(f.sort, "-") {
+	case "", "date", "amount":
+	default:
+		return validationErrorf("unknown sort order %q, expected date, -date, amount or -amount", f.sort)
+	}
+	if f.limit < 0 || f.offset < 0 {
+		return validationErrorf("--limit and --offset must not be negative")
+	}
+	return nil
+}
+
+func parseAmountFlag(name, v string) (*float64, error) {
+	if v == "" {
+		return nil, nil
+	}
+	amount, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, validationErrorf("invalid amount for --%s: %q", name, v)
+	}
+	return &amount, nil
+}
+
+// clientSide reports whether the transactions are filtered or sorted after
+// they are received. All of them are needed then.
+func (f *transactionFilter) clientSide() bool {
+	return f.from != "" || f.to != "" || f.minAmount != nil || f.maxAmount != nil || f.search != "" ||
+		f.booked || f.pending || f.sort != ""
+}
+
+// match reports whether tx passes the filter.
+func (f *transactionFilter) match(tx bosgo.Transaction) bool {
+	date := tx.EntryDate.Format("2006-01-02")
+	if f.from != "" && date < f.from || f.to != "" && date > f.to {
+		return false
+	}
+	if f.minAmount != nil || f.maxAmount != nil {
+		amount, err := strconv.ParseFloat(tx.Value.Value, 64)
+		if err != nil || f.minAmount != nil && amount < *f.minAmount || f.maxAmount != nil && amount > *f.maxAmount {
+			return false
+		}
+	}
+	if f.search != "" {
+		search := strings.ToLower(f.search)
+		if !strings.Contains(strings.ToLower(tx.Counterparty.Name), search) && !strings.Contains(strings.ToLower(tx.Usage), search) {
+			return false
+		}
+	}
+	if f.booked || f.pending {
+		if booked(tx, f.now) != f.booked {
+			return false
+		}
+	}
+	return true
+}
+
+// sortTransactions orders txs as requested by --sort, keeping the order of
+// the api for equal values.
+func (f *transactionFilter) sortTransactions(txs []bosgo.Transaction) {
+	desc := strings.HasPrefix(f.sort, "-")
+	var less func(a, b bosgo.Transaction) bool
+	switch strings.TrimPrefix(f.sort, "-") {
+	case "date":
+		less = func(a, b bosgo.Transaction) bool { return a.EntryDate.Before(b.EntryDate) }
+	case "amount":
+		less = func(a, b bosgo.Transaction) bool {
+			x, _ := strconv.ParseFloat(a.Value.Value, 64)
+			y, _ := strconv.ParseFloat(b.Value.Value, 64)
+			return x < y
+		}
+	default:
+		return
+	}
+	sort.SliceStable(txs, func(i, j int) bool {
+		if desc {
+			return less(txs[j], txs[i])
+		}
+		return less(txs[i], txs[j])
+	})
+}
+
+// listTransactions requests the transactions selected by the account,
+// limit and offset of f, following all pages if f.all is set. If f filters
+// or sorts on the client, all pages are followed and the limit and offset
+// are left to apply. Pages are followed until one is empty since the api
+// may return fewer transactions than requested.
+func listTransactions(f *transactionFilter) ([]bosgo.Transaction, error) {
+	all, limit, offset := f.all, f.limit, f.offset
+	if f.clientSide() {
+		all, limit, offset = true, 0, 0
+	}
+	if all && limit == 0 {
+		limit = transactionPageSize
+	}
+	var txs []bosgo.Transaction
+	for {
+		req := session.userClient.Transactions.List()
+		if f.account != "" {
+			req.AccountID(f.account)
+		}
+		if limit > 0 {
+			req.Limit(limit)
+		}
+		if offset > 0 {
+			req.Offset(offset)
+		}
+		page, err := req.Send()
+		if err != nil {
+			return nil, err
+		}
+		if !all || len(page.Transactions) == 0 {
+			return append(txs, page.Transactions...), nil
+		}
+		txs = append(txs, page.Transactions...)
+		offset += len(page.Transactions)
+	}
+}
+
+func transactions(c *ishell.Context) {
+	if session.userClient == nil {
+		c.Err(errNoUser)
+		return
+	}
+
+	f := transactionFilter{now: time.Now()}
+	fs := newCmdFlags("transactions")
+	f.addFlags(fs)
+	if err := parseCmdFlags(c, fs); err != nil {
+		c.Err(err)
+		return
+	}
+	if len(c.Args) > 0 {
+		c.Err(syntaxError(fmt.Errorf("unexpected argument %q", c.Args[0])))
+		return
+	}
+	if err := f.check(); err != nil {
+		c.Err(err)
+		return
+	}
+
+	txs, err := listTransactions(&f)
+	if err != nil {
+		c.Err(err)
+		return
+	}
+
+	printResult(c, &bosgo.TransactionPage{Transactions: f.apply(txs)})
+}
+
+// apply filters and sorts the transactions returned by listTransactions.
+// The limit and offset are applied to the result when the api could not.
+func (f *transactionFilter) apply(txs []bosgo.Transaction) []bosgo.Transaction {
+	list := []bosgo.Transaction{}
+	for _, tx := range txs {
+		if f.match(tx) {
+			list = append(list, tx)
+		}
+	}
+	f.sortTransactions(list)
+	if !f.clientSide() {
+		return list
+	}
+	offset := f.offset
+	if offset > len(list) {
+		offset = len(list)
+	}
+	list = list[offset:]
+	if f.limit > 0 && f.limit < len(list) {
+		list = list[:f.limit]
+	}
+	return list
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"code.bankrs.com/bosgo"
+)
+
+func TestTransactionFilters(t *testing.T) {
+	h := newHarness(t)
+	h.loginUser()
+
+	h.mustRun("", "accounts")
+	giro := strconv.FormatInt(lastResult.(*bosgo.AccountPage).Accounts[0].ID, 10)
+
+	// list returns the purposes of the transactions listed with args.
+	list := func(args ...string) []string {
+		t.Helper()
+		h.mustRun("", append([]string{"transactions"}, args...)...)
+		var usages []string
+		for _, tx := range lastResult.(*bosgo.TransactionPage).Transactions {
+			usages = append(usages, tx.Usage)
+		}
+		return usages
+	}
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--account", giro, "--limit", "2"}, "Transfer to savings,Subscription"},
+		{[]string{"--from", "2018-11-05", "--to", "2018-11-12"}, "Subscription,Card payment"},
+		{[]string{"--max", "-100", "--account", giro}, "Transfer to savings,Rent November"},
+		{[]string{"--min", "0", "--search", "SALARY"}, "Salary November"},
+		{[]string{"--search", "hausverwaltung"}, "Rent November"},
+		{[]string{"--account", giro, "--sort", "amount"}, "Rent November,Transfer to savings,Card payment,Subscription,Salary November"},
+		{[]string{"--sort", "-date", "--from", "2018-11-12"}, "Transfer to savings,Transfer to savings,Subscription"},
+		{[]string{"--sort", "date", "--to", "2018-11-02"}, "Salary November,Rent November"},
+		{[]string{"--pending"}, ""},
+		{[]string{"--to", "2018-11-02"}, "Rent November,Salary November"},
+		{[]string{"--limit", "2", "--offset", "4"}, "Rent November,Salary November"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(list(tt.args...), ","); got != tt.want {
+			t.Errorf("transactions %s: got %q, want %q", strings.Join(tt.args, " "), got, tt.want)
+		}
+	}
+
+	if n := len(list("--booked")); n != 6 {
+		t.Errorf("got %d booked transactions, want 6", n)
+	}
+	requests := strings.Count(h.log.String(), "GET /v1/transactions?")
+	if n := len(list("--all", "--limit", "4")); n != 6 {
+		t.Errorf("got %d transactions with --all, want 6", n)
+	}
+	// Paging stops at the first empty page.
+	if n := strings.Count(h.log.String(), "GET /v1/transactions?") - requests; n != 3 {
+		t.Errorf("got %d requests for all pages, want 3", n)
+	}
+
+	h.fails(exitValidation, "exclude each other", "", "transactions", "--booked", "--pending")
+	h.fails(exitValidation, "unknown sort order", "", "transactions", "--sort", "name")
+	h.fails(exitValidation, "yyyy-mm-dd", "", "transactions", "--from", "2018-13-01")
+	h.fails(exitValidation, "invalid amount", "", "transactions", "--min", "ten")
+	h.fails(exitSyntax, "unexpected argument", "", "transactions", "all")
+	h.fails(exitAPI, "not_found", "", "transactions", "--account", "999")
+}
+
+func TestTransactionFiltersAcrossPages(t *testing.T) {
+	size := transactionPageSize
+	transactionPageSize = 2
+	defer func() { transactionPageSize = size }()
+	h := newHarness(t)
+	h.loginUser()
+
+	list := func(args ...string) string {
+		t.Helper()
+		h.mustRun("", append([]string{"transactions"}, args...)...)
+		var usages []string
+		for _, tx := range lastResult.(*bosgo.TransactionPage).Transactions {
+			usages = append(usages, tx.Usage)
+		}
+		return strings.Join(usages, ",")
+	}
+
+	// The rent is on the third page of two transactions.
+	requests := strings.Count(h.log.String(), "GET /v1/transactions?")
+	if got := list("--search", "hausverwaltung"); got != "Rent November" {
+		t.Errorf("got %q, want the rent from the third page", got)
+	}
+	if n := strings.Count(h.log.String(), "GET /v1/transactions?") - requests; n != 4 {
+		t.Errorf("got %d requests, want 4 to reach the empty page", n)
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--search", "transfer", "--limit", "1", "--offset", "1"}, "Transfer to savings"},
+		{[]string{"--to", "2018-11-02", "--limit", "1"}, "Rent November"},
+		{[]string{"--sort", "amount", "--limit", "2"}, "Rent November,Transfer to savings"},
+		{[]string{"--sort", "date", "--limit", "2", "--offset", "1"}, "Rent November,Card payment"},
+		{[]string{"--search", "rent", "--offset", "5"}, ""},
+	}
+	for _, tt := range tests {
+		if got := list(tt.args...); got != tt.want {
+			t.Errorf("transactions %s: got %q, want %q", strings.Join(tt.args, " "), got, tt.want)
+		}
+	}
+
+	// Without filters the limit and offset are left to the api.
+	if got := list("--limit", "2", "--offset", "4"); got != "Rent November,Salary November" {
+		t.Errorf("got %q for a page from the api", got)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"code.bankrs.com/bosgo"
+	"github.com/abiosoft/ishell"
+)
+
+// Jobs are polled with an interval growing from waitMinInterval to
+// waitMaxInterval.
+var (
+	waitMinInterval = 500 * time.Millisecond
+	waitMaxInterval = 5 * time.Second
+)
+
+// waitOptions control how long to wait for jobs.
+type waitOptions struct {
+	wait    bool
+	timeout time.Duration
+	cancel  bool
+}
+
+// addFlags registers the flags for waiting. The -wait flag is only used by
+// commands that start jobs.
+func (o *waitOptions) addFlags(fs *flag.FlagSet, withWait bool) {
+	if withWait {
+		fs.BoolVar(&o.wait, "wait", false, "wait for the started jobs to finish")
+	}
+	fs.DurationVar(&o.timeout, "timeout", 5*time.Minute, "maximum time to wait for jobs")
+	fs.BoolVar(&o.cancel, "cancel", false, "cancel jobs still running after a timeout or interrupt")
+}
+
+// errInterrupted is reported when waiting is interrupted with Ctrl-C.
+var errInterrupted = fmt.Errorf("interrupted")
+
+func waitForJobs(c *ishell.Context) {
+	if session.userClient == nil {
+		c.Err(errNoUser)
+		return
+	}
+	var opts waitOptions
+	fs := newCmdFlags("wait")
+	opts.addFlags(fs, false)
+	if err := parseCmdFlags(c, fs); err != nil {
+		c.Err(err)
+		return
+	}
+	if len(c.Args) == 0 {
+		c.Err(validationErrorf("usage: wait [--timeout duration] [--cancel] uri..."))
+		return
+	}
+
+	statuses, err := pollJobs(c, c.Args, opts)
+	if structuredOutput(c) {
+		printResult(c, statuses)
+	} else {
+		lastResult = statuses
+	}
+	if err != nil {
+		c.Err(err)
+	}
+}
+
+// waitForStartedJobs waits for the jobs started by a command if it was run
+// with -wait. The result of the command stays the job URIs.
+func waitForStartedJobs(c *ishell.Context, uris []string, opts waitOptions) {
+	if !opts.wait {
+		return
+	}
+	if _, err := pollJobs(c, uris, opts); err != nil {
+		c.Err(err)
+	}
+}
+
+// pollJobs polls jobs until they are finished or wait for a challenge to be
+// answered, printing each change of their stage. It returns the last status
+// of every job and an error if a job failed, the timeout was reached or
+// waiting was interrupted.
+func pollJobs(c *ishell.Context, uris []string, opts waitOptions) ([]*bosgo.JobStatus, error) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	deadline := time.Now().Add(opts.timeout)
+	interval := waitMinInterval
+	statuses := make([]*bosgo.JobStatus, len(uris))
+	states := make([]string, len(uris))
+	for {
+		pending := 0
+		for i, uri := range uris {
+			if jobDone(statuses[i]) {
+				continue
+			}
+			status, err := session.userClient.Jobs.Get(uri).Send()
+			if err != nil {
+				return statuses, err
+			}
+			statuses[i] = status
+			if state := jobState(uri, status); state != states[i] {
+				states[i] = state
+				if !structuredOutput(c) {
+					c.Printf("%s: %s\n", uri, state)
+				}
+			}
+			if !jobDone(status) {
+				pending++
+			}
+		}
+		if pending == 0 {
+			return statuses, jobFailures(uris, statuses)
+		}
+
+		wait := interval
+		if left := time.Until(deadline); left < wait {
+			wait = left
+		}
+		if wait <= 0 {
+			return statuses, stopJobs(c, uris, statuses, opts, fmt.Errorf("timed out after %s waiting for %d job(s)", opts.timeout, pending))
+		}
+		select {
+		case <-time.After(wait):
+		case <-interrupt:
+			return statuses, stopJobs(c, uris, statuses, opts, errInterrupted)
+		}
+		if interval = interval * 3 / 2; interval > waitMaxInterval {
+			interval = waitMaxInterval
+		}
+	}
+}
+
+// jobDone reports whether polling a job can stop, because it is finished
+// or needs an answer to a challenge.
+func jobDone(status *bosgo.JobStatus) bool {
+	return status != nil && (status.Finished || status.Challenge != nil)
+}
+
+func jobState(uri string, status *bosgo.JobStatus) string {
+	switch {
+	case status.Finished && len(status.Errors) > 0:
+		return "failed: " + problemCodes(status.Errors)
+	case status.Finished:
+		return "finished"
+	case status.Challenge != nil:
+		return "waiting for a challenge answer, use: answer " + uri
+	}
+	return string(status.Stage)
+}
+
+func problemCodes(problems []bosgo.Problem) string {
+	codes := make([]string, 0, len(problems))
+	for _, p := range problems {
+		codes = append(codes, p.Code)
+	}
+	return strings.Join(codes, ", ")
+}
+
+// jobFailures returns an error naming the jobs that finished with errors.
+func jobFailures(uris []string, statuses []*bosgo.JobStatus) error {
+	var failed []string
+	for i, status := range statuses {
+		if status.Finished && len(status.Errors) > 0 {
+			failed = append(failed, fmt.Sprintf("%s (%s)", uris[i], problemCodes(status.Errors)))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &cmdError{kind: kindAPI, err: fmt.Errorf("job failed: %s", strings.Join(failed, "; "))}
+}
+
+// stopJobs cancels the jobs that are still running if requested and
+// returns reason.
+func stopJobs(c *ishell.Context, uris []string, statuses []*bosgo.JobStatus, opts waitOptions, reason error) error {
+	if !opts.cancel {
+		return reason
+	}
+	for i, uri := range uris {
+		if jobDone(statuses[i]) {
+			continue
+		}
+		if err := session.userClient.Jobs.Cancel(uri).Send(); err != nil {
+			return fmt.Errorf("%v, cancelling %s: %v", reason, uri, err)
+		}
+		if !structuredOutput(c) {
+			c.Printf("%s: cancelled\n", uri)
+		}
+	}
+	return reason
+}
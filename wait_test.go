@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"code.bankrs.com/bosgo"
+)
+
+func TestWait(t *testing.T) {
+	min, max := waitMinInterval, waitMaxInterval
+	waitMinInterval, waitMaxInterval = time.Millisecond, time.Millisecond
+	defer func() { waitMinInterval, waitMaxInterval = min, max }()
+	h := newHarness(t)
+	h.loginUser()
+
+	out := h.mustRun("alice\ny\n1234\ny\n", "addaccess", "--wait", "DE-TEST")
+	uri := lastResult.(string)
+	h.contains(out, uri+": authenticating", uri+": downloading", uri+": finished")
+
+	h.mustRun("", "refreshall")
+	uris := lastResult.([]string)
+	h.contains(h.mustRun("", append([]string{"wait"}, uris...)...), uris[0]+": finished", uris[1]+": finished")
+	statuses := lastResult.([]*bosgo.JobStatus)
+	if len(statuses) != 2 || !statuses[0].Finished || !statuses[1].Finished {
+		t.Errorf("got statuses %+v, want 2 finished jobs", statuses)
+	}
+
+	// Jobs needing an answer stop waiting.
+	h.mustRun("alice\ny\n1234\ny\n1\n", "addaccess", "DE-TAN", "--wait")
+	h.mustRun("", "accesses")
+	accesses := lastResult.(*bosgo.AccessPage).Accesses
+	id := strconv.FormatInt(accesses[len(accesses)-1].ID, 10)
+	out = h.mustRun("", "refreshaccess", "--wait", id)
+	h.contains(out, "waiting for a challenge answer, use: answer "+lastResult.(string))
+
+	h.fails(exitAPI, "login_failed", "wrong\ny\n1234\ny\n", "addaccess", "DE-TEST", "--wait")
+
+	h.mustRun("", "refreshaccess", "1")
+	uri = lastResult.(string)
+	h.fails(exitGeneral, "timed out", "", "wait", "--timeout", "1ns", "--cancel", uri)
+	h.mustRun("", "job", uri)
+	if status := lastResult.(*bosgo.JobStatus); !status.Finished || len(status.Errors) == 0 || status.Errors[0].Code != "canceled" {
+		t.Errorf("got job status %+v after timeout, want canceled", status)
+	}
+	h.fails(exitAPI, "canceled", "", "wait", uri)
+
+	h.fails(exitValidation, "usage: wait", "", "wait")
+	h.fails(exitSyntax, "flag provided but not defined", "", "wait", "--wiat", uri)
+}